@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AlertChannelLog     = "log"
+	AlertChannelWebhook = "webhook"
+	AlertChannelMail    = "mail"
+
+	defaultSMTPPort = 25
+)
+
+var defaultAlertChannels = []string{AlertChannelLog, AlertChannelWebhook}
+
+// AlertNotification is sent when an alert is raised, repeated or resolved.
+type AlertNotification struct {
+	Alert    Alert
+	Resolved bool
+}
+
+func (n AlertNotification) subject() string {
+	if n.Resolved {
+		return fmt.Sprintf("Resolved: %s", n.Alert.Message)
+	}
+	return fmt.Sprintf("Alert: %s", n.Alert.Message)
+}
+
+// AlertChannel delivers alert notifications.
+type AlertChannel interface {
+	Name() string
+	Notify(notification AlertNotification) error
+}
+
+// newAlertChannels creates the channels listed in the config.
+func newAlertChannels(config Config, events *EventBroker) ([]AlertChannel, error) {
+	names := config.AlertChannels
+	if len(names) == 0 {
+		names = defaultAlertChannels
+	}
+
+	channels := []AlertChannel{}
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case AlertChannelLog:
+			channels = append(channels, logAlertChannel{})
+		case AlertChannelWebhook:
+			channels = append(channels, webhookAlertChannel{events: events})
+		case AlertChannelMail:
+			channel, err := newMailAlertChannel(config)
+			if err != nil {
+				return nil, err
+			}
+			channels = append(channels, channel)
+		default:
+			return nil, fmt.Errorf("unknown alert channel: %s", name)
+		}
+	}
+	return channels, nil
+}
+
+// logAlertChannel writes alerts to the log.
+type logAlertChannel struct{}
+
+func (logAlertChannel) Name() string {
+	return AlertChannelLog
+}
+
+func (logAlertChannel) Notify(notification AlertNotification) error {
+	level := WarnLog
+	if notification.Resolved {
+		level = InfoLog
+	}
+	logWithCaller(fmt.Sprintf("%s (alert %d, mount %s)", notification.subject(), notification.Alert.ID, notification.Alert.MountName), level)
+	return nil
+}
+
+// webhookAlertChannel publishes alerts as events, so they reach the event
+// stream and all webhooks subscribed to alert events.
+type webhookAlertChannel struct {
+	events *EventBroker
+}
+
+func (webhookAlertChannel) Name() string {
+	return AlertChannelWebhook
+}
+
+func (c webhookAlertChannel) Notify(notification AlertNotification) error {
+	eventType := EventAlertRaised
+	if notification.Resolved {
+		eventType = EventAlertResolved
+	}
+	c.events.Publish(eventType, notification.Alert.MountName, notification.Alert)
+	return nil
+}
+
+// mailAlertChannel sends alerts by SMTP. With a mail folder configured the
+// mails are written there instead, as stand-in for a mail server.
+type mailAlertChannel struct {
+	from     string
+	to       []string
+	address  string
+	auth     smtp.Auth
+	folder   string
+	hostname string
+}
+
+func newMailAlertChannel(config Config) (*mailAlertChannel, error) {
+	if len(config.AlertMailTo) == 0 || config.AlertMailFrom == "" {
+		return nil, fmt.Errorf("mail alerts need alert_mail_from and alert_mail_to")
+	}
+	if config.SMTPHost == "" && config.AlertMailFolder == "" {
+		return nil, fmt.Errorf("mail alerts need smtp_host or alert_mail_folder")
+	}
+
+	port := config.SMTPPort
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	channel := &mailAlertChannel{
+		from:    config.AlertMailFrom,
+		to:      config.AlertMailTo,
+		address: config.SMTPHost + ":" + strconv.Itoa(port),
+		folder:  config.AlertMailFolder,
+	}
+	if config.SMTPUsername != "" {
+		channel.auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+	channel.hostname, _ = os.Hostname()
+	return channel, nil
+}
+
+func (*mailAlertChannel) Name() string {
+	return AlertChannelMail
+}
+
+func (c *mailAlertChannel) message(notification AlertNotification, now time.Time) []byte {
+	alert := notification.Alert
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", c.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(c.to, ", "))
+	fmt.Fprintf(&b, "Subject: [%s] %s\r\n", getApplicationName(), notification.subject())
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <alert-%d-%d@%s>\r\n", alert.ID, now.UnixNano(), c.hostname)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+
+	fmt.Fprintf(&b, "%s\r\n\r\n", alert.Message)
+	fmt.Fprintf(&b, "Stream: %s\r\n", alert.MountName)
+	fmt.Fprintf(&b, "Type: %s\r\n", alert.Type)
+	fmt.Fprintf(&b, "Scheduled start: %s\r\n", alert.OccurrenceStart.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Raised: %s\r\n", alert.CreatedAt.Format(time.RFC3339))
+	if notification.Resolved && alert.ResolvedAt != nil {
+		fmt.Fprintf(&b, "Resolved: %s\r\n", alert.ResolvedAt.Format(time.RFC3339))
+	}
+	if !notification.Resolved {
+		fmt.Fprintf(&b, "\r\nAcknowledge with POST /api/v1/alerts/%d/ack\r\n", alert.ID)
+	}
+	return []byte(b.String())
+}
+
+func (c *mailAlertChannel) Notify(notification AlertNotification) error {
+	now := time.Now()
+	message := c.message(notification, now)
+	if c.folder != "" {
+		fileName := fmt.Sprintf("alert-%d-%d.eml", notification.Alert.ID, now.UnixNano())
+		return os.WriteFile(filepath.Join(c.folder, fileName), message, 0640)
+	}
+	return smtp.SendMail(c.address, c.auth, c.from, c.to, message)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const alertColumns = `id, mount_name, type, message, occurrence_start, created_at, notified_at, acknowledged_at, acknowledged_by, resolved_at`
+
+func scanAlert(scanner interface{ Scan(...any) error }) (Alert, error) {
+	var alert Alert
+	var notifiedAt, acknowledgedAt, resolvedAt sql.NullTime
+	err := scanner.Scan(&alert.ID, &alert.MountName, &alert.Type, &alert.Message, &alert.OccurrenceStart, &alert.CreatedAt,
+		&notifiedAt, &acknowledgedAt, &alert.AcknowledgedBy, &resolvedAt)
+	if err != nil {
+		return Alert{}, err
+	}
+	if notifiedAt.Valid {
+		alert.NotifiedAt = &notifiedAt.Time
+	}
+	if acknowledgedAt.Valid {
+		alert.AcknowledgedAt = &acknowledgedAt.Time
+	}
+	if resolvedAt.Valid {
+		alert.ResolvedAt = &resolvedAt.Time
+	}
+	return alert, nil
+}
+
+func (s *SqliteStorage) CreateAlert(alert Alert) (Alert, error) {
+	defer observeQuery("CreateAlert", time.Now())
+	logWithCaller(fmt.Sprintf("Creating %s alert for mount: %s", alert.Type, alert.MountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	INSERT INTO alerts (mount_name, type, message, occurrence_start)
+	VALUES ($1, $2, $3, $4)
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return Alert{}, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(alert.MountName, alert.Type, alert.Message, alert.OccurrenceStart.UTC())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return Alert{}, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting last insert ID: %v", err), FatalLog)
+		return Alert{}, err
+	}
+	logWithCaller(fmt.Sprintf("Inserted alert with ID: %d", lastID), InfoLog)
+
+	return s.GetAlert(lastID)
+}
+
+func (s *SqliteStorage) GetAlert(id int64) (Alert, error) {
+	defer observeQuery("GetAlert", time.Now())
+	logWithCaller(fmt.Sprintf("Getting alert %d", id), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + alertColumns + `
+	FROM alerts
+	WHERE id = $1
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return Alert{}, err
+	}
+	defer stmt.Close()
+
+	alert, err := scanAlert(stmt.QueryRow(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logWithCaller(fmt.Sprintf("No alert %d", id), DebugLog)
+		}
+		return Alert{}, err
+	}
+	return alert, nil
+}
+
+// GetAlerts returns the alerts of a mount, or of all mounts if mountName is
+// empty, newest first. With openOnly resolved alerts are left out.
+func (s *SqliteStorage) GetAlerts(mountName string, openOnly bool) ([]Alert, error) {
+	defer observeQuery("GetAlerts", time.Now())
+	logWithCaller(fmt.Sprintf("Getting alerts for mount: %s", mountName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + alertColumns + `
+	FROM alerts
+	WHERE ($1 = '' OR mount_name = $1) AND (NOT $2 OR resolved_at IS NULL)
+	ORDER BY id DESC
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(mountName, openOnly)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// AlertExists reports whether an alert was raised for the scheduled window of a mount.
+func (s *SqliteStorage) AlertExists(mountName string, occurrenceStart time.Time) (bool, error) {
+	defer observeQuery("AlertExists", time.Now())
+	stmt, err := s.db.Prepare(`
+	SELECT COUNT(*)
+	FROM alerts
+	WHERE mount_name = $1 AND occurrence_start = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return false, err
+	}
+	defer stmt.Close()
+
+	var count int
+	err = stmt.QueryRow(mountName, occurrenceStart.UTC()).Scan(&count)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *SqliteStorage) UpdateAlert(alert Alert) error {
+	defer observeQuery("UpdateAlert", time.Now())
+	logWithCaller(fmt.Sprintf("Updating alert %d", alert.ID), DebugLog)
+	stmt, err := s.db.Prepare(`
+	UPDATE alerts
+	SET notified_at = $1,
+	acknowledged_at = $2,
+	acknowledged_by = $3,
+	resolved_at = $4
+	WHERE id = $5
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(nullableTime(alert.NotifiedAt), nullableTime(alert.AcknowledgedAt), alert.AcknowledgedBy,
+		nullableTime(alert.ResolvedAt), alert.ID)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for alert: %d", affectedRows, alert.ID), FatalLog)
+		return affectedRowsError(affectedRows, "alert", alert.ID)
+	}
+	return nil
+}
+
+const alertSilenceColumns = `id, mount_name, until, reason, created_by, created_at`
+
+func scanAlertSilence(scanner interface{ Scan(...any) error }) (AlertSilence, error) {
+	var silence AlertSilence
+	err := scanner.Scan(&silence.ID, &silence.MountName, &silence.Until, &silence.Reason, &silence.CreatedBy, &silence.CreatedAt)
+	if err != nil {
+		return AlertSilence{}, err
+	}
+	return silence, nil
+}
+
+func (s *SqliteStorage) CreateAlertSilence(silence AlertSilence) (AlertSilence, error) {
+	defer observeQuery("CreateAlertSilence", time.Now())
+	logWithCaller(fmt.Sprintf("Silencing alerts of mount %q until %s", silence.MountName, silence.Until), InfoLog)
+	stmt, err := s.db.Prepare(`
+	INSERT INTO alert_silences (mount_name, until, reason, created_by)
+	VALUES ($1, $2, $3, $4)
+	RETURNING ` + alertSilenceColumns)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return AlertSilence{}, err
+	}
+	defer stmt.Close()
+
+	created, err := scanAlertSilence(stmt.QueryRow(silence.MountName, silence.Until.UTC(), silence.Reason, silence.CreatedBy))
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return AlertSilence{}, err
+	}
+	return created, nil
+}
+
+// GetAlertSilences returns all silences, including expired ones.
+func (s *SqliteStorage) GetAlertSilences() ([]AlertSilence, error) {
+	defer observeQuery("GetAlertSilences", time.Now())
+	stmt, err := s.db.Prepare(`
+	SELECT ` + alertSilenceColumns + `
+	FROM alert_silences
+	ORDER BY id
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	silences := []AlertSilence{}
+	for rows.Next() {
+		silence, err := scanAlertSilence(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		silences = append(silences, silence)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return silences, nil
+}
+
+func (s *SqliteStorage) DeleteAlertSilence(id int64) error {
+	defer observeQuery("DeleteAlertSilence", time.Now())
+	logWithCaller(fmt.Sprintf("Deleting alert silence %d", id), InfoLog)
+	stmt, err := s.db.Prepare(`
+	DELETE FROM alert_silences
+	WHERE id = $1
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(id)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for alert silence: %d", affectedRows, id), FatalLog)
+		return affectedRowsError(affectedRows, "alert silence", id)
+	}
+	return nil
+}
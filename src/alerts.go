@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AlertMissingSource = "missing_source"
+	AlertSourceDropped = "source_dropped"
+
+	alertInterval            = 30 * time.Second
+	defaultAlertGraceMinutes = 5
+)
+
+// Alert is raised when a scheduled stream has no source. It stays open until
+// the source connects or the scheduled window ends.
+type Alert struct {
+	ID              int64      `json:"id"`
+	MountName       string     `json:"mount_name"`
+	Type            string     `json:"type"`
+	Message         string     `json:"message"`
+	OccurrenceStart time.Time  `json:"occurrence_start"`
+	CreatedAt       time.Time  `json:"created_at"`
+	NotifiedAt      *time.Time `json:"notified_at,omitempty"`
+	AcknowledgedAt  *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy  string     `json:"acknowledged_by,omitempty"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+}
+
+// AlertSilence suppresses the notifications of a stream, or of all streams if
+// MountName is empty, until the given time. Alerts are still recorded.
+type AlertSilence struct {
+	ID        int64     `json:"id"`
+	MountName string    `json:"mount_name,omitempty"`
+	Until     time.Time `json:"until"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func isSilenced(silences []AlertSilence, mountName string, now time.Time) bool {
+	for _, silence := range silences {
+		if (silence.MountName == "" || silence.MountName == mountName) && now.Before(silence.Until) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentWindows returns the scheduled window every mount is in at now. The
+// lead time is not part of the window, sources are not expected before the start.
+func currentWindows(schedules []StreamSchedule, now time.Time) map[string]ScheduleOccurrence {
+	windows := make(map[string]ScheduleOccurrence)
+	for _, schedule := range schedules {
+		occurrences, err := schedule.Occurrences(now, now.Add(time.Nanosecond))
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Invalid schedule %d for mount %s: %s", schedule.ID, schedule.MountName, err), WarnLog)
+			continue
+		}
+		for _, occurrence := range occurrences {
+			if now.Before(occurrence.Start) || !now.Before(occurrence.End) {
+				continue
+			}
+			if known, ok := windows[schedule.MountName]; !ok || occurrence.Start.Before(known.Start) {
+				windows[schedule.MountName] = occurrence
+			}
+		}
+	}
+	return windows
+}
+
+// AlertEngine compares the schedules with the live state reported by Icecast.
+// It raises an alert if a mount has no source some minutes into its window or
+// if the source disconnects during the window, and resolves it once the source
+// is back or the window is over.
+type AlertEngine struct {
+	storage  Store
+	status   *IcecastStatusPoller
+	events   *EventBroker
+	channels []AlertChannel
+	grace    time.Duration
+	repeat   time.Duration
+	enabled  bool
+}
+
+func NewAlertEngine(config Config, storage Store, status *IcecastStatusPoller, events *EventBroker) (*AlertEngine, error) {
+	channels, err := newAlertChannels(config, events)
+	if err != nil {
+		return nil, err
+	}
+	graceMinutes := config.AlertGraceMinutes
+	if graceMinutes <= 0 {
+		graceMinutes = defaultAlertGraceMinutes
+	}
+	return &AlertEngine{
+		storage:  storage,
+		status:   status,
+		events:   events,
+		channels: channels,
+		grace:    time.Duration(graceMinutes) * time.Minute,
+		repeat:   time.Duration(config.AlertRepeatMinutes) * time.Minute,
+		enabled:  config.IcecastStatusURL != "",
+	}, nil
+}
+
+// Run checks the schedules every interval and reacts to source events
+// immediately. It never returns.
+func (e *AlertEngine) Run(interval time.Duration) {
+	if !e.enabled {
+		logWithCaller("No Icecast status URL configured, alerts disabled", InfoLog)
+		return
+	}
+	logWithCaller(fmt.Sprintf("Starting alert engine with interval %s", interval), InfoLog)
+
+	subscriber, _ := e.events.Subscribe(0, func(event Event) bool {
+		return event.Type == EventSourceConnected || event.Type == EventSourceDisconnected
+	})
+	defer e.events.Unsubscribe(subscriber)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.tick(now)
+		case event := <-subscriber.events:
+			e.handleSourceEvent(event, time.Now())
+		}
+	}
+}
+
+func (e *AlertEngine) tick(now time.Time) {
+	if !e.status.Ready() {
+		return
+	}
+	schedules, err := e.storage.GetAllSchedules()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not load schedules: %s", err), WarnLog)
+		return
+	}
+	windows := currentWindows(schedules, now)
+
+	for mountName, window := range windows {
+		if e.status.Status(mountName).Live || now.Before(window.Start.Add(e.grace)) {
+			continue
+		}
+		exists, err := e.storage.AlertExists(mountName, window.Start)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Alert engine could not check alerts of mount %s: %s", mountName, err), WarnLog)
+			continue
+		}
+		if exists {
+			continue
+		}
+		message := fmt.Sprintf("%s has no source %d minutes after its scheduled start", mountName, int(now.Sub(window.Start).Minutes()))
+		e.raise(Alert{MountName: mountName, Type: AlertMissingSource, Message: message, OccurrenceStart: window.Start}, now)
+	}
+
+	alerts, err := e.storage.GetAlerts("", true)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not load open alerts: %s", err), WarnLog)
+		return
+	}
+	for _, alert := range alerts {
+		_, inWindow := windows[alert.MountName]
+		if e.status.Status(alert.MountName).Live || !inWindow {
+			e.resolve(alert, now)
+			continue
+		}
+		e.notifyIfDue(alert, now)
+	}
+}
+
+// handleSourceEvent raises an alert when a source disconnects during its
+// window and resolves the alerts of a mount when its source connects.
+func (e *AlertEngine) handleSourceEvent(event Event, now time.Time) {
+	if event.Type == EventSourceConnected {
+		alerts, err := e.storage.GetAlerts(event.MountName, true)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Alert engine could not load alerts of mount %s: %s", event.MountName, err), WarnLog)
+			return
+		}
+		for _, alert := range alerts {
+			e.resolve(alert, now)
+		}
+		return
+	}
+
+	schedules, err := e.storage.GetSchedules(event.MountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not load schedules of mount %s: %s", event.MountName, err), WarnLog)
+		return
+	}
+	window, inWindow := currentWindows(schedules, now)[event.MountName]
+	if !inWindow {
+		return
+	}
+	alerts, err := e.storage.GetAlerts(event.MountName, true)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not load alerts of mount %s: %s", event.MountName, err), WarnLog)
+		return
+	}
+	if len(alerts) > 0 {
+		return
+	}
+	message := fmt.Sprintf("%s lost its source during the scheduled window", event.MountName)
+	e.raise(Alert{MountName: event.MountName, Type: AlertSourceDropped, Message: message, OccurrenceStart: window.Start}, now)
+}
+
+func (e *AlertEngine) raise(alert Alert, now time.Time) {
+	created, err := e.storage.CreateAlert(alert)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not save alert of mount %s: %s", alert.MountName, err), WarnLog)
+		return
+	}
+	e.notifyIfDue(created, now)
+}
+
+// notifyIfDue sends an open alert unless it is silenced. Unacknowledged alerts
+// are repeated every repeat interval if configured.
+func (e *AlertEngine) notifyIfDue(alert Alert, now time.Time) {
+	if alert.NotifiedAt != nil && (alert.AcknowledgedAt != nil || e.repeat == 0 || now.Sub(*alert.NotifiedAt) < e.repeat) {
+		return
+	}
+	if e.silenced(alert.MountName, now) {
+		return
+	}
+
+	e.notify(AlertNotification{Alert: alert})
+	alert.NotifiedAt = &now
+	err := e.storage.UpdateAlert(alert)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not save alert %d: %s", alert.ID, err), WarnLog)
+	}
+}
+
+func (e *AlertEngine) resolve(alert Alert, now time.Time) {
+	alert.ResolvedAt = &now
+	err := e.storage.UpdateAlert(alert)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not resolve alert %d: %s", alert.ID, err), WarnLog)
+		return
+	}
+	if alert.NotifiedAt != nil && !e.silenced(alert.MountName, now) {
+		e.notify(AlertNotification{Alert: alert, Resolved: true})
+	}
+}
+
+func (e *AlertEngine) silenced(mountName string, now time.Time) bool {
+	silences, err := e.storage.GetAlertSilences()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Alert engine could not load silences: %s", err), WarnLog)
+		return false
+	}
+	return isSilenced(silences, mountName, now)
+}
+
+func (e *AlertEngine) notify(notification AlertNotification) {
+	for _, channel := range e.channels {
+		err := channel.Notify(notification)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Could not send alert %d via %s: %s", notification.Alert.ID, channel.Name(), err), WarnLog)
+		}
+	}
+}
+
+// ###########
+// Alert routes
+// ###########
+
+func (s *ApiServer) addAlertRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"alerts", makeHTTPHandleFunc(s.handleGetAlerts))
+	addToRouteRightsMap("GET "+autherized+"alerts", "get_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"alerts/{alertID}", makeHTTPHandleFunc(s.handleGetAlert))
+	addToRouteRightsMap("GET "+autherized+"alerts/{alertID}", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"alerts/{alertID}/ack", makeHTTPHandleFunc(s.handleAcknowledgeAlert))
+	addToRouteRightsMap("POST "+autherized+"alerts/{alertID}/ack", "post_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"alerts/silences", makeHTTPHandleFunc(s.handleGetAlertSilences))
+	addToRouteRightsMap("GET "+autherized+"alerts/silences", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"alerts/silences", makeHTTPHandleFunc(s.handleCreateAlertSilence))
+	addToRouteRightsMap("POST "+autherized+"alerts/silences", "post_stream")
+
+	autherizedRouter.HandleFunc("DELETE "+autherized+"alerts/silences/{silenceID}", makeHTTPHandleFunc(s.handleDeleteAlertSilence))
+	addToRouteRightsMap("DELETE "+autherized+"alerts/silences/{silenceID}", "delete_stream")
+}
+
+// getRequestAlert loads the alert of the request if the caller may see it.
+func (s *ApiServer) getRequestAlert(r *http.Request) (Alert, error) {
+	id, err := strconv.ParseInt(r.PathValue("alertID"), 10, 64)
+	if err != nil {
+		return Alert{}, fmt.Errorf("invalid alert id")
+	}
+
+	alert, err := s.storage.GetAlert(id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching alert: %d %s", id, err), WarnLog)
+		return Alert{}, databaseError(err, "alert")
+	}
+
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return Alert{}, err
+	}
+	if !all && (ownStream == "" || alert.MountName != ownStream) {
+		return Alert{}, notFoundError("alert not found")
+	}
+	return alert, nil
+}
+
+// handleGetAlerts lists the alerts, newest first. With state=open only
+// unresolved alerts are returned.
+func (s *ApiServer) handleGetAlerts(w http.ResponseWriter, r *http.Request) error {
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return err
+	}
+	if !all && ownStream == "" {
+		return WriteJson(w, http.StatusOK, []Alert{})
+	}
+
+	state := r.URL.Query().Get("state")
+	if state != "" && state != "open" && state != "all" {
+		return fmt.Errorf("state must be open or all")
+	}
+
+	alerts, err := s.storage.GetAlerts(ownStream, state == "open")
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching alerts: %s", err), WarnLog)
+		return databaseError(err, "alert")
+	}
+
+	return WriteJson(w, http.StatusOK, alerts)
+}
+
+func (s *ApiServer) handleGetAlert(w http.ResponseWriter, r *http.Request) error {
+	alert, err := s.getRequestAlert(r)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, alert)
+}
+
+// handleAcknowledgeAlert stops the repetition of an alert.
+func (s *ApiServer) handleAcknowledgeAlert(w http.ResponseWriter, r *http.Request) error {
+	alert, err := s.getRequestAlert(r)
+	if err != nil {
+		return err
+	}
+	if alert.AcknowledgedAt != nil {
+		return WriteJson(w, http.StatusOK, alert)
+	}
+
+	now := time.Now().UTC()
+	alert.AcknowledgedAt = &now
+	alert.AcknowledgedBy = getAuthInfo(r).Username
+	err = s.storage.UpdateAlert(alert)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error acknowledging alert: %d %s", alert.ID, err), WarnLog)
+		return databaseError(err, "alert")
+	}
+
+	return WriteJson(w, http.StatusOK, alert)
+}
+
+// handleGetAlertSilences lists the silences that are still active.
+func (s *ApiServer) handleGetAlertSilences(w http.ResponseWriter, r *http.Request) error {
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return err
+	}
+
+	silences, err := s.storage.GetAlertSilences()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching alert silences: %s", err), WarnLog)
+		return databaseError(err, "silence")
+	}
+
+	now := time.Now()
+	visible := []AlertSilence{}
+	for _, silence := range silences {
+		if !now.Before(silence.Until) {
+			continue
+		}
+		if all || (ownStream != "" && silence.MountName == ownStream) {
+			visible = append(visible, silence)
+		}
+	}
+
+	return WriteJson(w, http.StatusOK, visible)
+}
+
+// handleCreateAlertSilence silences a stream until the given time. Only tokens
+// with get_all_streams may silence all streams at once.
+func (s *ApiServer) handleCreateAlertSilence(w http.ResponseWriter, r *http.Request) error {
+	var silence AlertSilence
+	err := decodeJSON(w, r, &silence)
+	if err != nil {
+		return err
+	}
+
+	if !silence.Until.After(time.Now()) {
+		return validationError(fieldError("until", "must be in the future"))
+	}
+
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return err
+	}
+	silence.MountName = strings.TrimSpace(silence.MountName)
+	switch {
+	case !all && ownStream == "":
+		return fmt.Errorf("user owns no stream")
+	case !all && silence.MountName == "":
+		silence.MountName = ownStream
+	case !all && silence.MountName != ownStream:
+		return fmt.Errorf("you can only silence your own stream")
+	case all && silence.MountName != "":
+		_, err = s.storage.GetIcecastMount(silence.MountName)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Database error fetching icecast mount: %s %s", silence.MountName, err), WarnLog)
+			return notFoundError("stream not found")
+		}
+	}
+	silence.CreatedBy = getAuthInfo(r).Username
+
+	created, err := s.storage.CreateAlertSilence(silence)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error creating alert silence: %s", err), WarnLog)
+		return databaseError(err, "silence")
+	}
+
+	return WriteJson(w, http.StatusCreated, created)
+}
+
+func (s *ApiServer) handleDeleteAlertSilence(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.ParseInt(r.PathValue("silenceID"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid silence id")
+	}
+
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return err
+	}
+	silences, err := s.storage.GetAlertSilences()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching alert silences: %s", err), WarnLog)
+		return databaseError(err, "silence")
+	}
+	found := false
+	for _, silence := range silences {
+		if silence.ID == id && (all || (ownStream != "" && silence.MountName == ownStream)) {
+			found = true
+		}
+	}
+	if !found {
+		return notFoundError("silence not found")
+	}
+
+	err = s.storage.DeleteAlertSilence(id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error deleting alert silence: %d %s", id, err), WarnLog)
+		return databaseError(err, "silence")
+	}
+
+	return WriteJson(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertCurrentWindowsIgnoresLead(t *testing.T) {
+	initTest(t)
+
+	schedules := []StreamSchedule{
+		{ID: 1, MountName: "morning", Start: "2025-04-20T10:00", Timezone: "UTC", DurationMinutes: 60, LeadMinutes: 15, RRule: "FREQ=DAILY"},
+	}
+
+	windows := currentWindows(schedules, time.Date(2025, 4, 21, 9, 50, 0, 0, time.UTC))
+	if len(windows) != 0 {
+		t.Fatalf("Expected no window during the lead time, got %v", windows)
+	}
+
+	windows = currentWindows(schedules, time.Date(2025, 4, 21, 10, 30, 0, 0, time.UTC))
+	window, ok := windows["morning"]
+	if !ok {
+		t.Fatalf("Expected a window for morning")
+	}
+	if !window.Start.Equal(time.Date(2025, 4, 21, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected window start: %s", window.Start)
+	}
+
+	windows = currentWindows(schedules, time.Date(2025, 4, 21, 11, 0, 0, 0, time.UTC))
+	if len(windows) != 0 {
+		t.Fatalf("Expected no window after the end, got %v", windows)
+	}
+}
+
+func TestAlertSilences(t *testing.T) {
+	initTest(t)
+
+	now := time.Date(2025, 4, 20, 10, 0, 0, 0, time.UTC)
+	silences := []AlertSilence{
+		{MountName: "morning", Until: now.Add(time.Hour)},
+		{MountName: "evening", Until: now.Add(-time.Minute)},
+	}
+	if !isSilenced(silences, "morning", now) {
+		t.Fatalf("Expected morning to be silenced")
+	}
+	if isSilenced(silences, "evening", now) {
+		t.Fatalf("Expected expired silence to be ignored")
+	}
+	if isSilenced(silences, "noon", now) {
+		t.Fatalf("Expected noon not to be silenced")
+	}
+
+	silences = append(silences, AlertSilence{Until: now.Add(time.Minute)})
+	if !isSilenced(silences, "noon", now) {
+		t.Fatalf("Expected a silence without stream to silence all streams")
+	}
+}
@@ -19,23 +19,36 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 )
 
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
+// ApiError is the body of every error response. Code is one of the
+// ErrorCode constants, Fields lists the invalid fields of a validation error.
 type ApiError struct {
-	Error string `json:"error"`
+	Error  string       `json:"error"`
+	Code   string       `json:"code"`
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
 type ApiServer struct {
-	listenAddr string
-	storage    Store
-	icecast    *IcecastConfigStore
+	listenAddr       string
+	config           Config
+	storage          Store
+	icecast          *IcecastConfigStore
+	listenerSessions *ListenerSessions
+	listenerAuth     *ListenerAuthLimiter
+	scheduler        *StreamScheduler
+	recordings       *RecordingIndexer
+	icecastStatus    *IcecastStatusPoller
+	events           *EventBroker
+	webhooks         *WebhookDispatcher
+	alerts           *AlertEngine
 }
 
 // routeRightsMap is a map that associates HTTP routes with their corresponding rights.
@@ -51,6 +64,11 @@ func StreamAPI(listenAddr string, config Config) (*ApiServer, error) {
 		return nil, fmt.Errorf("failed to create storage")
 	}
 
+	if config.ListenerAuthType == listenerAuthURL && config.ListenerAuthSecret == "" {
+		logWithCaller("listener_auth_secret is required for url listener authentication", FatalLog)
+		return nil, fmt.Errorf("listener_auth_secret is required for url listener authentication")
+	}
+
 	icecast := NewIcecastConfig(config)
 	if icecast == nil {
 		logWithCaller("Failed to create icecast config", FatalLog)
@@ -59,10 +77,28 @@ func StreamAPI(listenAddr string, config Config) (*ApiServer, error) {
 
 	logWithCaller(fmt.Sprintf("Created storage and icecast config for server listening on %s", listenAddr), DebugLog)
 
+	events := NewEventBroker()
+	icecastStatus := NewIcecastStatusPoller(config, storage, events)
+
+	alerts, err := NewAlertEngine(config, storage, icecastStatus, events)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Failed to create alert engine: %s", err), FatalLog)
+		return nil, fmt.Errorf("failed to create alert engine: %w", err)
+	}
+
 	return &ApiServer{
-		listenAddr: listenAddr,
-		storage:    storage,
-		icecast:    icecast,
+		listenAddr:       listenAddr,
+		config:           config,
+		storage:          storage,
+		icecast:          icecast,
+		listenerSessions: NewListenerSessions(),
+		listenerAuth:     NewListenerAuthLimiter(),
+		scheduler:        NewStreamScheduler(storage, icecast, events),
+		recordings:       NewRecordingIndexer(storage, config),
+		icecastStatus:    icecastStatus,
+		events:           events,
+		webhooks:         NewWebhookDispatcher(storage, events),
+		alerts:           alerts,
 	}, nil
 }
 
@@ -75,81 +111,88 @@ func MiddlewareChain(middlewares ...Middleware) Middleware {
 	}
 }
 
-func requestLoggerMiddleware(next http.Handler) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		logWithCaller(fmt.Sprintf("Request: %s %s", r.Method, r.URL.Path), InfoLog)
-		next.ServeHTTP(w, r)
-	}
+// authInfo is the authenticated caller, stored in the request context by requireAuthMiddlware.
+type authInfo struct {
+	Username string
+	Token    string
 }
 
-func requireAuthMiddlware(next http.Handler, api *ApiServer) http.HandlerFunc {
+type contextKey string
+
+const authContextKey contextKey = "auth"
+
+// getAuthInfo returns the authenticated caller of the request.
+func getAuthInfo(r *http.Request) authInfo {
+	info, _ := r.Context().Value(authContextKey).(authInfo)
+	return info
+}
+
+// hasRight reports whether the caller's token carries the given right.
+func (info authInfo) hasRight(right string) bool {
+	return tokenRightFailure(info.Token, right, info.Username) == ""
+}
+
+func requireAuthMiddlware(next http.Handler, api *ApiServer, router *http.ServeMux) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("Authorization")
-		if !autherized(token, r, api) {
-			WriteJson(w, http.StatusUnauthorized, ApiError{Error: "Unauthorized"})
+		username, ok := autherized(token, r, api, router)
+		if !ok {
+			WriteJson(w, http.StatusUnauthorized, ApiError{Error: "Unauthorized", Code: ErrorCodeUnauthorized})
 			return
 		}
-		logWithCaller("Authorized", InfoLog)
-		next.ServeHTTP(w, r)
+		setRequestUser(r, username)
+		logRequest(r, "Authorized", InfoLog)
+		ctx := context.WithValue(r.Context(), authContextKey, authInfo{Username: username, Token: token})
+		next.ServeHTTP(w, r.WithContext(ctx))
 
 	}
 }
 
-// autherized checks if the request is authorized based on the provided token and request.
-func autherized(token string, r *http.Request, api *ApiServer) bool {
+// autherized checks if the request is authorized based on the provided token and request
+// and returns the user the token belongs to.
+// The right is looked up by the route pattern the router would dispatch the request to,
+// so nested routes like /api/streams/{streamName}/listeners are covered as well.
+func autherized(token string, r *http.Request, api *ApiServer, router *http.ServeMux) (string, bool) {
 
-	logWithCaller("Autherizing", InfoLog)
+	logRequest(r, "Autherizing", InfoLog)
 
 	requestURL := r.URL.Path
-	//we are getting all the parts of the request URL to check which right to use
-	requestURLParts := strings.Split(requestURL, "/")
-
-	logWithCaller(fmt.Sprintf("Autherizing for %s", requestURL), InfoLog)
-
-	if len(requestURLParts) > 4 {
-
-		//Our URLs c3 or for parts /api/streams/{streamName}
-		logWithCaller(fmt.Sprintf("Request URL to long. Conatins %d parts", len(requestURLParts)), DebugLog)
-		return false
-	} else if len(requestURLParts) == 4 {
-		//Case the URL is /api/streams/{streamName}
-		rightsKey := r.Method + " " + strings.Join(requestURLParts[:3], "/") + "/{streamName}"
-		logWithCaller(fmt.Sprintf("Getting rights for this call %s", rightsKey), InfoLog)
-
-		right := routeRightsMap[rightsKey]
-		logWithCaller(fmt.Sprintf("Checking this right %s", right), DebugLog)
-		username, err := api.storage.GetUserByToken(getHash(token))
-		if err != nil {
-			return false
-		}
-		logWithCaller(fmt.Sprintf("Checking for user %s and token %s: %s", username, getHash(token), right), DebugLog)
-		return checkTokeHasRight(token, right, username)
-	} else if len(requestURLParts) == 3 {
-		//Case the URL is /api/streams
-		rightsKey := r.Method + " " + requestURL
-		logWithCaller(fmt.Sprintf("Getting rights for this call %s", rightsKey), InfoLog)
-
-		right := routeRightsMap[rightsKey]
-		logWithCaller(fmt.Sprintf("Checking this right %s", right), DebugLog)
-		username, err := api.storage.GetUserByToken(getHash(token))
-		if err != nil {
-			return false
-		}
-		logWithCaller(fmt.Sprintf("Checking for user %s and token %s: %s", username, getHash(token), right), DebugLog)
-		return checkTokeHasRight(token, right, username)
+	logRequest(r, fmt.Sprintf("Autherizing for %s", requestURL), InfoLog)
 
+	_, rightsKey := router.Handler(r)
+	if rightsKey == "" {
+		logRequest(r, "Nothing matches", DebugLog)
+		authFailures.Inc("no_route")
+		return "", false
 	}
-
-	logWithCaller("Nothing matches", DebugLog)
-	return false
-
+	setRoutePattern(r, rightsKey)
+	logRequest(r, fmt.Sprintf("Getting rights for this call %s", rightsKey), InfoLog)
+
+	right, ok := routeRightsMap[rightsKey]
+	if !ok {
+		logRequest(r, fmt.Sprintf("No right registered for %s", rightsKey), DebugLog)
+		authFailures.Inc("no_route")
+		return "", false
+	}
+	logRequest(r, fmt.Sprintf("Checking this right %s", right), DebugLog)
+	username, err := api.storage.GetUserByToken(getHash(token))
+	if err != nil {
+		authFailures.Inc("unknown_token")
+		return "", false
+	}
+	logRequest(r, fmt.Sprintf("Checking right %s for user %s", right, username), DebugLog)
+	return username, checkTokeHasRight(token, right, username)
 }
 
 func makeHTTPHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		setRoutePattern(r, r.Pattern)
 		err := f(w, r)
 		if err != nil {
-			WriteJson(w, http.StatusBadRequest, ApiError{Error: err.Error()})
+			logRequest(r, fmt.Sprintf("Request failed: %s", err), WarnLog)
+			setAuditError(r, err)
+			status, apiErr := apiErrorResponse(err)
+			WriteJson(w, status, apiErr)
 		}
 	}
 }
@@ -161,25 +204,49 @@ func WriteJson(w http.ResponseWriter, status int, v any) error {
 }
 
 func (s *ApiServer) Run() error {
+	server := http.Server{
+		Addr:    s.listenAddr,
+		Handler: s.Handler(),
+	}
+	logWithCaller(fmt.Sprintf("Server listening on %s", server.Addr), DebugLog)
+
+	go s.scheduler.Run(schedulerInterval)
+	go s.recordings.Run(recordingIndexInterval)
+	go s.icecastStatus.Run(s.getIcecastPollInterval())
+	go s.webhooks.Run(webhookDeliveryInterval)
+	go s.alerts.Run(alertInterval)
+	go s.RunTrashPurge(trashPurgeInterval)
+	go s.RunIdempotencyPurge(idempotencyPurgeInterval)
+	go s.RunWebhookDeliveryPurge(webhookPurgeInterval)
+
+	logWithCaller(fmt.Sprintf("Starting server on %s", s.listenAddr), InfoLog)
+	return server.ListenAndServe()
+
+}
+
+// Handler returns the router with all routes, without starting the background loops.
+func (s *ApiServer) Handler() http.Handler {
 	router := http.NewServeMux()
 
 	middlewareChain := MiddlewareChain(
+		requestIDMiddleware,
+		metricsMiddleware,
 		requestLoggerMiddleware,
 	)
 
-	server := http.Server{
-		Addr:    s.listenAddr,
-		Handler: middlewareChain(router),
-	}
-	logWithCaller(fmt.Sprintf("Server listening on %s", server.Addr), DebugLog)
-
+	router.HandleFunc("GET /metrics", makeHTTPHandleFunc(s.handleMetrics))
 	s.addPublicRoutes(router)
 	s.addAuthorizedRoutes(router)
 	s.addUserRoutes(router)
 
-	logWithCaller(fmt.Sprintf("Starting server on %s", s.listenAddr), InfoLog)
-	return server.ListenAndServe()
+	return middlewareChain(router)
+}
 
+func (s *ApiServer) getIcecastPollInterval() time.Duration {
+	if s.config.IcecastPollIntervalSeconds > 0 {
+		return time.Duration(s.config.IcecastPollIntervalSeconds) * time.Second
+	}
+	return defaultIcecastPollInterval
 }
 
 // ###########
@@ -191,6 +258,16 @@ func (s *ApiServer) addPublicRoutes(router *http.ServeMux) {
 	public := "/public/"
 	publicRouter.HandleFunc("GET "+public+"health", makeHTTPHandleFunc(s.handleHealthCheck))
 	publicRouter.HandleFunc("GET "+public+"version", makeHTTPHandleFunc(s.handleVersion))
+	publicRouter.HandleFunc("GET "+public+"openapi.json", makeHTTPHandleFunc(s.handleOpenAPI))
+	publicRouter.HandleFunc("POST "+public+"listener-auth", makeHTTPHandleFunc(s.handleListenerAuth))
+	publicRouter.HandleFunc("GET "+public+"streams", makeHTTPHandleFunc(s.handleGetPublicStreams))
+	publicRouter.HandleFunc("GET "+public+"streams/{playlist}", makeHTTPHandleFunc(s.handleGetPlaylist))
+	publicRouter.HandleFunc("GET "+public+"streams/{streamName}/status", makeHTTPHandleFunc(s.handleGetPublicStream))
+	publicRouter.HandleFunc("GET "+public+"player/{streamName}", makeHTTPHandleFunc(s.handlePlayer))
+	publicRouter.HandleFunc("GET "+public+"player/{streamName}/embed", makeHTTPHandleFunc(s.handlePlayerEmbed))
+	publicRouter.HandleFunc("GET "+public+"streams/{streamName}/schedule.ics", makeHTTPHandleFunc(s.handlePublicStreamCalendar))
+	publicRouter.HandleFunc("GET "+public+"streams/{streamName}/podcast.xml", makeHTTPHandleFunc(s.handlePodcastFeed))
+	publicRouter.HandleFunc("GET "+public+"streams/{streamName}/episodes/{recordingID}/{fileName}", makeHTTPHandleFunc(s.handlePodcastEpisode))
 
 	router.Handle(public, publicRouter)
 	logWithCaller("Added public routes", InfoLog)
@@ -224,12 +301,12 @@ func (s *ApiServer) addUserRoutes(router *http.ServeMux) {
 func (s *ApiServer) handleGetToken(w http.ResponseWriter, r *http.Request) error {
 	username := r.URL.Query().Get("username")
 	password := r.URL.Query().Get("password")
-	logWithCaller(fmt.Sprintf("Getting token for user %s", username), InfoLog)
+	logRequest(r, fmt.Sprintf("Getting token for user %s", username), InfoLog)
 	if username == "" || password == "" {
 		return fmt.Errorf("invalid credentials")
 	}
 
-	logWithCaller(fmt.Sprintf("Checking Password for user %s", username), InfoLog)
+	logRequest(r, fmt.Sprintf("Checking Password for user %s", username), InfoLog)
 	passwordHash, err := s.storage.GetUser(username)
 	if err != nil {
 		return fmt.Errorf("invalid credentials")
@@ -239,7 +316,7 @@ func (s *ApiServer) handleGetToken(w http.ResponseWriter, r *http.Request) error
 		return fmt.Errorf("invalid credentials")
 	}
 
-	logWithCaller(fmt.Sprintf("Creating token for user %s", username), InfoLog)
+	logRequest(r, fmt.Sprintf("Creating token for user %s", username), InfoLog)
 	token, err := createToken(username, rightsAdmin, time.Now().Add(24*365*time.Hour))
 
 	s.storage.SaveToken(username, getHash(token))
@@ -257,9 +334,40 @@ func (s *ApiServer) handleGetToken(w http.ResponseWriter, r *http.Request) error
 func (s *ApiServer) addAuthorizedRoutes(router *http.ServeMux) {
 
 	autherizedRouter := http.NewServeMux()
-	autherized := "/api/"
 
-	autherizedRouter.HandleFunc("POST "+autherized+"streams", makeHTTPHandleFunc(s.handleCreateStream))
+	// The unversioned paths stay as aliases of /api/v1/ for existing clients
+	for _, autherized := range []string{apiV1Prefix, apiPrefix} {
+		s.addStreamRoutes(autherizedRouter, autherized)
+		s.addListenerRoutes(autherizedRouter, autherized)
+		s.addRevisionRoutes(autherizedRouter, autherized)
+		s.addRenameRoutes(autherizedRouter, autherized)
+		s.addImportRoutes(autherizedRouter, autherized)
+		s.addBatchRoutes(autherizedRouter, autherized)
+		s.addTrashRoutes(autherizedRouter, autherized)
+		s.addScheduleRoutes(autherizedRouter, autherized)
+		s.addRecordingRoutes(autherizedRouter, autherized)
+		s.addEventRoutes(autherizedRouter, autherized)
+		s.addWebhookRoutes(autherizedRouter, autherized)
+		s.addLogLevelRoutes(autherizedRouter, autherized)
+		s.addAlertRoutes(autherizedRouter, autherized)
+		s.addAuditRoutes(autherizedRouter, autherized)
+	}
+
+	middlewareChain := MiddlewareChain(
+		func(next http.Handler) http.HandlerFunc {
+			return auditMiddleware(next, s)
+		},
+		func(next http.Handler) http.HandlerFunc {
+			return requireAuthMiddlware(next, s, autherizedRouter)
+		},
+	)
+
+	router.Handle(apiPrefix, middlewareChain(autherizedRouter))
+	logWithCaller("Added authorized routes", InfoLog)
+}
+
+func (s *ApiServer) addStreamRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("POST "+autherized+"streams", makeHTTPHandleFunc(s.idempotent(s.handleCreateStream)))
 	addToRouteRightsMap("POST "+autherized+"streams", "post_stream")
 
 	autherizedRouter.HandleFunc("GET "+autherized+"streams", makeHTTPHandleFunc(s.handleGetAllStreams))
@@ -271,17 +379,11 @@ func (s *ApiServer) addAuthorizedRoutes(router *http.ServeMux) {
 	autherizedRouter.HandleFunc("POST "+autherized+"streams/{streamName}", makeHTTPHandleFunc(s.handleUpdateStream))
 	addToRouteRightsMap("POST "+autherized+"streams/{streamName}", "post_stream")
 
+	autherizedRouter.HandleFunc("PATCH "+autherized+"streams/{streamName}", makeHTTPHandleFunc(s.handlePatchStream))
+	addToRouteRightsMap("PATCH "+autherized+"streams/{streamName}", "post_stream")
+
 	autherizedRouter.HandleFunc("DELETE "+autherized+"streams/{streamName}", makeHTTPHandleFunc(s.handleDeleteStream))
 	addToRouteRightsMap("DELETE "+autherized+"streams/{streamName}", "delete_stream")
-
-	middlewareChain := MiddlewareChain(
-		func(next http.Handler) http.HandlerFunc {
-			return requireAuthMiddlware(next, s)
-		},
-	)
-
-	router.Handle(autherized, middlewareChain(autherizedRouter))
-	logWithCaller("Added authorized routes", InfoLog)
 }
 
 func addToRouteRightsMap(route, right string) {
@@ -290,103 +392,206 @@ func addToRouteRightsMap(route, right string) {
 
 func (s *ApiServer) handleCreateStream(w http.ResponseWriter, r *http.Request) error {
 	var mount IcecastMount
-	err := json.NewDecoder(r.Body).Decode(&mount)
+	err := decodeJSON(w, r, &mount)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("JSON error updating icecast mount: %v %s", mount, err), WarnLog)
-		return fmt.Errorf("invalid JSON")
+		return err
 	}
-	defer r.Body.Close()
 
-	err = s.storage.CreateIcecastMount(mount)
+	err = mount.Validate()
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Database error creating icecast mount: %v %s", mount, err), WarnLog)
-		return fmt.Errorf("database error")
+		return err
 	}
 
-	err = s.icecast.SaveMountConfig(mount)
+	mount, err = s.createStream(r, mount)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Config error creating icecast mount: %v %s", mount, err), WarnLog)
-		return fmt.Errorf("file error")
+		return err
 	}
 
+	setAuditTarget(r, "streams/"+mount.MountName)
+	setAuditChange(r, nil, mount)
+	setMountETag(w, mount)
 	return WriteJson(w, http.StatusCreated, mount)
 }
 
-func (s *ApiServer) handleGetAllStreams(w http.ResponseWriter, r *http.Request) error {
-	mounts, err := s.storage.GetIcecastMounts()
+// createStream stores a validated new mount and writes its config.
+func (s *ApiServer) createStream(r *http.Request, mount IcecastMount) (IcecastMount, error) {
+	_, err := s.storage.GetTrashedIcecastMount(mount.MountName)
+	if err == nil {
+		return IcecastMount{}, conflictError("stream %s is in the trash, restore or purge it first", mount.MountName)
+	}
+
+	// A new stream takes over the name of a renamed one
+	_, err = s.storage.GetMountRedirect(mount.MountName)
+	if err == nil {
+		err = s.removeRedirect(mount.MountName)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Error deleting redirect: %s %s", mount.MountName, err), WarnLog)
+			return IcecastMount{}, internalError("file error")
+		}
+	}
+
+	err = s.storage.CreateIcecastMount(mount)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Database error fetching icecast mounts: %s", err), WarnLog)
-		return fmt.Errorf("database error")
+		logRequest(r, fmt.Sprintf("Database error creating icecast mount: %v %s", mount.redacted(), err), WarnLog)
+		return IcecastMount{}, databaseError(err, "stream")
 	}
 
-	return WriteJson(w, http.StatusOK, mounts)
+	err = s.scheduler.ApplyMountConfig(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error creating icecast mount: %v %s", mount.redacted(), err), WarnLog)
+		return IcecastMount{}, internalError("file error")
+	}
+
+	s.events.Publish(EventStreamCreated, mount.MountName, mount.redacted())
+	mount.Version = 1
+	return mount, nil
 }
 
 func (s *ApiServer) handleGetSingleStream(w http.ResponseWriter, r *http.Request) error {
 	mountName := r.PathValue("streamName")
-	logWithCaller(fmt.Sprintf("Getting mount for mountName: %s", mountName), InfoLog)
+	logRequest(r, fmt.Sprintf("Getting mount for mountName: %s", mountName), InfoLog)
 	if mountName == "" {
 		return fmt.Errorf("missing stream name")
 	}
 
 	mount, err := s.storage.GetIcecastMount(mountName)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Database error fetching icecast mount: %s %s", mountName, err), WarnLog)
-		return fmt.Errorf("database error")
+		logRequest(r, fmt.Sprintf("Database error fetching icecast mount: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "stream")
 	}
 
+	setMountETag(w, mount)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && matchesETag(ifNoneMatch, mount) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
 	return WriteJson(w, http.StatusOK, mount)
 }
+
+// handleUpdateStream replaces the stream with the body. The If-Match header
+// must name the current version.
 func (s *ApiServer) handleUpdateStream(w http.ResponseWriter, r *http.Request) error {
-	mountName := r.PathValue("streamName")
-	if mountName == "" {
-		return fmt.Errorf("missing stream name")
+	before, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	logRequest(r, fmt.Sprintf("Updating stream %s", before.MountName), InfoLog)
+
+	err = checkIfMatch(r, before)
+	if err != nil {
+		return err
 	}
-	logWithCaller(fmt.Sprintf("Updating stream %s", mountName), InfoLog)
 
 	var mount IcecastMount
-	err := json.NewDecoder(r.Body).Decode(&mount)
+	err = decodeJSON(w, r, &mount)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("JSON error updating icecast mount: %s %s", mountName, err), WarnLog)
-		return fmt.Errorf("invalid JSON")
+		return err
 	}
-	defer r.Body.Close()
 
-	mount.MountName = mountName
+	mount.MountName = before.MountName
 
-	err = s.storage.UpdateIcecastMount(mount)
+	err = mount.Validate()
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Database error updating icecast mount: %s %s", mountName, err), WarnLog)
-		return fmt.Errorf("database error")
+		return err
 	}
 
-	err = s.icecast.SaveMountConfig(mount)
+	mount, err = s.saveStream(r, before, mount, RevisionReasonUpdate)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Config error creating icecast mount: %s %s", mountName, err), WarnLog)
-		return fmt.Errorf("file error")
+		return err
 	}
 
+	setMountETag(w, mount)
 	return WriteJson(w, http.StatusOK, mount)
 }
+
+// saveStream replaces before with the changed mount and applies its Icecast
+// config. The update fails if the stream changed since before was read. The
+// previous version is kept as revision.
+func (s *ApiServer) saveStream(r *http.Request, before, mount IcecastMount, reason string) (IcecastMount, error) {
+	mountName := mount.MountName
+	err := s.saveRevision(r, before, reason)
+	if err != nil {
+		return IcecastMount{}, err
+	}
+
+	mount.Version = before.Version
+	err = s.storage.UpdateIcecastMount(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error updating icecast mount: %s %s", mountName, err), WarnLog)
+		return IcecastMount{}, databaseError(err, "stream")
+	}
+	mount.Version++
+
+	err = s.scheduler.ApplyMountConfig(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error creating icecast mount: %s %s", mountName, err), WarnLog)
+		return IcecastMount{}, internalError("file error")
+	}
+
+	setAuditChange(r, before, mount)
+	s.events.Publish(EventStreamUpdated, mount.MountName, mount.redacted())
+	return mount, nil
+}
 func (s *ApiServer) handleDeleteStream(w http.ResponseWriter, r *http.Request) error {
 
 	mountName := r.PathValue("streamName")
 	if mountName == "" {
 		return fmt.Errorf("missing stream name")
 	}
-	logWithCaller(fmt.Sprintf("Deleting stream %s", mountName), InfoLog)
+	logRequest(r, fmt.Sprintf("Deleting stream %s", mountName), InfoLog)
 
-	mount, err := s.storage.DeleteIcecastMount(mountName)
+	current, err := s.storage.GetIcecastMount(mountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching icecast mount: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "stream")
+	}
+	err = checkIfMatch(r, current)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Database error deleting icecast mount: %s %s", mountName, err), WarnLog)
-		return fmt.Errorf("database error")
+		return err
 	}
+	err = s.saveRevision(r, current, RevisionReasonDelete)
+	if err != nil {
+		return err
+	}
+
+	// The mount goes to the trash, the files are removed so Icecast drops it
+	mount, err := s.storage.TrashIcecastMount(mountName, getAuthInfo(r).Username)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error deleting icecast mount: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "stream")
+	}
+
+	err = s.removeStreamConfig(r, mount)
+	if err != nil {
+		return err
+	}
+
+	setAuditChange(r, mount, nil)
+	s.events.Publish(EventStreamDeleted, mountName, mount.redacted())
+
+	return WriteJson(w, http.StatusOK, map[string]string{
+		"status":   "deleted",
+		"purge_at": time.Now().Add(s.trashRetention()).UTC().Format(time.RFC3339),
+	})
+}
 
-	err = s.icecast.DeleteMountConfig(mount)
+// removeStreamConfig removes the files of a stream moved to the trash, so
+// Icecast drops it.
+func (s *ApiServer) removeStreamConfig(r *http.Request, mount IcecastMount) error {
+	var err error
+	s.scheduler.Forget(mount.MountName)
+	if s.icecast.MountConfigExists(mount) {
+		err = s.icecast.DeleteMountConfig(mount)
+	}
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Config error creating icecast mount: %s %s", mountName, err), WarnLog)
-		return fmt.Errorf("file error")
+		logRequest(r, fmt.Sprintf("Config error deleting icecast mount: %s %s", mount.MountName, err), WarnLog)
+		return internalError("file error")
 	}
 
-	return WriteJson(w, http.StatusOK, map[string]string{"status": "deleted"})
+	err = s.icecast.DeleteListenerHtpasswd(mount.MountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error deleting listener accounts: %s %s", mount.MountName, err), WarnLog)
+		return internalError("file error")
+	}
+	return nil
 }
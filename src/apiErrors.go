@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Error codes returned in ApiError.Code.
+const (
+	ErrorCodeBadRequest           = "bad_request"
+	ErrorCodeUnauthorized         = "unauthorized"
+	ErrorCodeForbidden            = "forbidden"
+	ErrorCodeNotFound             = "not_found"
+	ErrorCodeConflict             = "conflict"
+	ErrorCodePreconditionFailed   = "precondition_failed"
+	ErrorCodeTooLarge             = "too_large"
+	ErrorCodeValidation           = "validation"
+	ErrorCodePreconditionRequired = "precondition_required"
+	ErrorCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrorCodeBatchAborted         = "aborted"
+	ErrorCodeIdempotencyKeyReused = "idempotency_key_reused"
+	ErrorCodeInternal             = "internal"
+)
+
+// ErrVersionMismatch is returned by the storage if a row changed since it was read.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// FieldError describes an invalid field of the request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// StatusError is a handler error with the HTTP status and error code of the
+// response. Handler errors of other types are bad requests.
+type StatusError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// forbiddenError is for authenticated callers that lack a permission.
+func forbiddenError(format string, args ...any) error {
+	return &StatusError{Status: http.StatusForbidden, Code: ErrorCodeForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+func notFoundError(format string, args ...any) error {
+	return &StatusError{Status: http.StatusNotFound, Code: ErrorCodeNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func preconditionFailedError(format string, args ...any) error {
+	return &StatusError{Status: http.StatusPreconditionFailed, Code: ErrorCodePreconditionFailed, Message: fmt.Sprintf(format, args...)}
+}
+
+func conflictError(format string, args ...any) error {
+	return &StatusError{Status: http.StatusConflict, Code: ErrorCodeConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// internalError hides the cause, which is logged by the caller.
+func internalError(message string) error {
+	return &StatusError{Status: http.StatusInternalServerError, Code: ErrorCodeInternal, Message: message}
+}
+
+// fieldError returns a FieldError, the message follows the field name, e.g. "must not be negative".
+func fieldError(field, format string, args ...any) FieldError {
+	return FieldError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// validationError reports one or more invalid fields.
+func validationError(fields ...FieldError) error {
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return &StatusError{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    ErrorCodeValidation,
+		Message: strings.Join(messages, "; "),
+		Fields:  fields,
+	}
+}
+
+// databaseError maps a storage error about object, e.g. "stream": a missing
+// row is not found, a duplicate a conflict, a changed row a failed
+// precondition and anything else an internal error.
+func databaseError(err error, object string) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return notFoundError("%s not found", object)
+	case errors.Is(err, ErrVersionMismatch):
+		return preconditionFailedError("%s was changed by someone else, fetch it again", object)
+	case isUniqueViolation(err):
+		return conflictError("%s already exists", object)
+	}
+	return internalError("database error")
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// affectedRowsError reports an update or delete that did not change exactly
+// one row. No changed row means there is no such row.
+func affectedRowsError(affectedRows int64, object string, id any) error {
+	if affectedRows == 0 {
+		return fmt.Errorf("no %s %v: %w", object, id, sql.ErrNoRows)
+	}
+	return fmt.Errorf("affected rows %d for %s: %v", affectedRows, object, id)
+}
+
+// apiErrorResponse returns the status and body for an error returned by a handler.
+func apiErrorResponse(err error) (int, ApiError) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status, ApiError{Error: statusErr.Message, Code: statusErr.Code, Fields: statusErr.Fields}
+	}
+	return http.StatusBadRequest, ApiError{Error: err.Error(), Code: ErrorCodeBadRequest}
+}
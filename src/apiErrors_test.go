@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestDatabaseError(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	mount := IcecastMount{MountName: "christmas", Username: "source", Password: "secret", TemplateType: "default"}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"duplicate", storage.CreateIcecastMount(mount), http.StatusConflict, ErrorCodeConflict},
+		{"missing", func() error { _, err := storage.GetIcecastMount("easter"); return err }(), http.StatusNotFound, ErrorCodeNotFound},
+		{"missing update", storage.UpdateIcecastMount(IcecastMount{MountName: "easter"}), http.StatusNotFound, ErrorCodeNotFound},
+		{"other", fmt.Errorf("disk I/O error"), http.StatusInternalServerError, ErrorCodeInternal},
+	}
+	for _, test := range tests {
+		if test.err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+		status, apiErr := apiErrorResponse(databaseError(test.err, "stream"))
+		if status != test.status || apiErr.Code != test.code {
+			t.Fatalf("%s: expected %d %s, got %d %+v", test.name, test.status, test.code, status, apiErr)
+		}
+	}
+}
+
+func TestApiErrorResponse(t *testing.T) {
+	status, apiErr := apiErrorResponse(validationError(
+		fieldError("duration_minutes", "must be positive"),
+		fieldError("lead_minutes", "must not be negative")))
+	if status != http.StatusUnprocessableEntity || apiErr.Code != ErrorCodeValidation || len(apiErr.Fields) != 2 {
+		t.Fatalf("Unexpected validation response: %d %+v", status, apiErr)
+	}
+	if apiErr.Error != "duration_minutes must be positive; lead_minutes must not be negative" {
+		t.Fatalf("Unexpected validation message: %s", apiErr.Error)
+	}
+
+	status, apiErr = apiErrorResponse(fmt.Errorf("invalid JSON"))
+	if status != http.StatusBadRequest || apiErr.Code != ErrorCodeBadRequest {
+		t.Fatalf("Unexpected response for a plain error: %d %+v", status, apiErr)
+	}
+}
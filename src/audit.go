@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	auditEntryLimit    = 50
+	auditMaxEntryLimit = 500
+	auditExportBatch   = 500
+	auditTokenIDLength = 16
+
+	auditContextKey contextKey = "audit"
+)
+
+// AuditEntry records one mutating API call. Entries are never changed or deleted.
+type AuditEntry struct {
+	ID        int64                  `json:"id"`
+	Time      time.Time              `json:"time"`
+	Actor     string                 `json:"actor"`
+	TokenID   string                 `json:"token_id,omitempty"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target"`
+	Changes   map[string]AuditChange `json:"changes,omitempty"`
+	SourceIP  string                 `json:"source_ip"`
+	RequestID string                 `json:"request_id"`
+	Status    int                    `json:"status"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// AuditChange is the value of a field before and after the call.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditFilter selects audit entries. Cursor is the ID the entries are older than.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Cursor int64
+	Limit  int
+}
+
+// AuditPage is a page of audit entries, newest first.
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// auditRecord collects what the handler reports about the call.
+type auditRecord struct {
+	target  string
+	changes map[string]AuditChange
+	err     string
+}
+
+func getAuditRecord(r *http.Request) *auditRecord {
+	record, _ := r.Context().Value(auditContextKey).(*auditRecord)
+	return record
+}
+
+// setAuditChange records the object before and after the call, nil for a
+// created or deleted object. Secrets are redacted.
+func setAuditChange(r *http.Request, before, after any) {
+	if record := getAuditRecord(r); record != nil {
+		record.changes = auditChanges(before, after)
+	}
+}
+
+// setAuditTarget overrides the target, which is the path below /api/ otherwise,
+// e.g. for objects created by posting to a collection.
+func setAuditTarget(r *http.Request, target string) {
+	if record := getAuditRecord(r); record != nil {
+		record.target = target
+	}
+}
+
+func setAuditError(r *http.Request, err error) {
+	if record := getAuditRecord(r); record != nil {
+		record.err = err.Error()
+	}
+}
+
+func auditFields(object any) map[string]any {
+	fields := map[string]any{}
+	if object == nil {
+		return fields
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+// auditChanges returns the JSON fields that differ between before and after.
+func auditChanges(before, after any) map[string]AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	changes := map[string]AuditChange{}
+	for _, fields := range []map[string]any{beforeFields, afterFields} {
+		for key := range fields {
+			if _, done := changes[key]; done || reflect.DeepEqual(beforeFields[key], afterFields[key]) {
+				continue
+			}
+			change := AuditChange{Before: beforeFields[key], After: afterFields[key]}
+			if sensitiveLogKeys[strings.ToLower(key)] {
+				change.Before = redactAuditValue(change.Before)
+				change.After = redactAuditValue(change.After)
+			}
+			changes[key] = change
+		}
+	}
+	return changes
+}
+
+// redactAuditValue hides a secret but keeps whether it was set.
+func redactAuditValue(value any) any {
+	if value == nil || value == "" {
+		return value
+	}
+	return redactedValue
+}
+
+// auditTokenID identifies a token by the start of its hash, as stored in the token table.
+func auditTokenID(token string) string {
+	if token == "" {
+		return ""
+	}
+	return getHash(token)[:auditTokenIDLength]
+}
+
+// clientIP returns the address of the caller. Behind a local reverse proxy
+// the address the proxy appended to X-Forwarded-For is used.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	forwarded := r.Header.Get("X-Forwarded-For")
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() && forwarded != "" {
+		parts := strings.Split(forwarded, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	return host
+}
+
+func auditedMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// auditMiddleware writes an audit entry for every mutating call, including
+// calls that were not authorized.
+func auditMiddleware(next http.Handler, api *ApiServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auditedMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		record := &auditRecord{}
+		recorder := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), auditContextKey, record))
+		next.ServeHTTP(recorder, r)
+
+		entry := AuditEntry{
+			Time:     time.Now(),
+			TokenID:  auditTokenID(r.Header.Get("Authorization")),
+			Action:   r.Method + " " + r.URL.Path,
+			Target:   apiPath(r.URL.Path),
+			Changes:  record.changes,
+			SourceIP: clientIP(r),
+			Status:   recorder.status,
+			Error:    record.err,
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if record.target != "" {
+			entry.Target = record.target
+		}
+		if info := getRequestInfo(r.Context()); info != nil {
+			entry.Actor = info.user
+			entry.RequestID = info.id
+			if info.route != unmatchedRoute {
+				entry.Action = info.route
+			}
+		}
+
+		err := api.storage.CreateAuditEntry(entry)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Database error writing audit entry: %s", err), FatalLog)
+		}
+	}
+}
+
+func (s *ApiServer) addAuditRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"audit", makeHTTPHandleFunc(s.handleGetAuditLog))
+	addToRouteRightsMap("GET "+autherized+"audit", "get_audit_log")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"audit/export", makeHTTPHandleFunc(s.handleExportAuditLog))
+	addToRouteRightsMap("GET "+autherized+"audit/export", "get_audit_log")
+}
+
+// getAuditFilter reads the filters actor, action, target, since and until.
+func getAuditFilter(r *http.Request) (AuditFilter, error) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: strings.Trim(query.Get("target"), "/"),
+	}
+
+	var err error
+	if value := query.Get("since"); value != "" {
+		filter.Since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return AuditFilter{}, fmt.Errorf("invalid since, expected RFC 3339 time")
+		}
+	}
+	if value := query.Get("until"); value != "" {
+		filter.Until, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return AuditFilter{}, fmt.Errorf("invalid until, expected RFC 3339 time")
+		}
+	}
+	return filter, nil
+}
+
+func (s *ApiServer) handleGetAuditLog(w http.ResponseWriter, r *http.Request) error {
+	filter, err := getAuditFilter(r)
+	if err != nil {
+		return err
+	}
+
+	filter.Limit = auditEntryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > auditMaxEntryLimit {
+			return fmt.Errorf("limit must be between 1 and %d", auditMaxEntryLimit)
+		}
+	}
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		filter.Cursor, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || filter.Cursor < 1 {
+			return fmt.Errorf("invalid cursor")
+		}
+	}
+
+	// One more entry than requested tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	entries, err := s.storage.GetAuditEntries(filter)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching audit entries: %s", err), WarnLog)
+		return databaseError(err, "audit entry")
+	}
+
+	page := AuditPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = strconv.FormatInt(page.Entries[limit-1].ID, 10)
+	}
+	return WriteJson(w, http.StatusOK, page)
+}
+
+// handleExportAuditLog streams all matching entries as newline delimited JSON, newest first.
+func (s *ApiServer) handleExportAuditLog(w http.ResponseWriter, r *http.Request) error {
+	filter, err := getAuditFilter(r)
+	if err != nil {
+		return err
+	}
+	filter.Limit = auditExportBatch
+
+	entries, err := s.storage.GetAuditEntries(filter)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error exporting audit entries: %s", err), WarnLog)
+		return databaseError(err, "audit entry")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for len(entries) > 0 {
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				logRequest(r, fmt.Sprintf("Error writing audit export: %s", err), WarnLog)
+				return nil
+			}
+		}
+		if len(entries) < auditExportBatch {
+			break
+		}
+		filter.Cursor = entries[len(entries)-1].ID
+		entries, err = s.storage.GetAuditEntries(filter)
+		if err != nil {
+			// The status is sent already, the export ends early
+			logRequest(r, fmt.Sprintf("Database error exporting audit entries: %s", err), WarnLog)
+			return nil
+		}
+	}
+	return nil
+}
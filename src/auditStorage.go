@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const auditEntryColumns = `id, created_at, actor, token_id, action, target, changes, source_ip, request_id, status, error`
+
+func scanAuditEntry(scanner interface{ Scan(...any) error }) (AuditEntry, error) {
+	var entry AuditEntry
+	var changes sql.NullString
+	err := scanner.Scan(&entry.ID, &entry.Time, &entry.Actor, &entry.TokenID, &entry.Action, &entry.Target, &changes,
+		&entry.SourceIP, &entry.RequestID, &entry.Status, &entry.Error)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	if changes.Valid && changes.String != "" {
+		err = json.Unmarshal([]byte(changes.String), &entry.Changes)
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("invalid changes of audit entry %d: %w", entry.ID, err)
+		}
+	}
+	return entry, nil
+}
+
+func (s *SqliteStorage) CreateAuditEntry(entry AuditEntry) error {
+	defer observeQuery("CreateAuditEntry", time.Now())
+	var changes sql.NullString
+	if len(entry.Changes) > 0 {
+		data, err := json.Marshal(entry.Changes)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error encoding audit changes: %v", err), FatalLog)
+			return err
+		}
+		changes = sql.NullString{String: string(data), Valid: true}
+	}
+
+	stmt, err := s.db.Prepare(`
+	INSERT INTO audit_log (created_at, actor, token_id, action, target, changes, source_ip, request_id, status, error)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(entry.Time.UTC(), entry.Actor, entry.TokenID, entry.Action, entry.Target, changes,
+		entry.SourceIP, entry.RequestID, entry.Status, entry.Error)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return nil
+}
+
+// GetAuditEntries returns the entries matching the filter, newest first. The
+// target matches the target itself and everything below it.
+func (s *SqliteStorage) GetAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	defer observeQuery("GetAuditEntries", time.Now())
+	stmt, err := s.db.Prepare(`
+	SELECT ` + auditEntryColumns + `
+	FROM audit_log
+	WHERE ($1 = '' OR actor = $1)
+	AND ($2 = '' OR action = $2)
+	AND ($3 = '' OR target = $3 OR target LIKE $3 || '/%')
+	AND ($4 IS NULL OR created_at >= $4)
+	AND ($5 IS NULL OR created_at < $5)
+	AND ($6 = 0 OR id < $6)
+	ORDER BY id DESC
+	LIMIT $7
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var since, until *time.Time
+	if !filter.Since.IsZero() {
+		value := filter.Since.UTC()
+		since = &value
+	}
+	if !filter.Until.IsZero() {
+		value := filter.Until.UTC()
+		until = &value
+	}
+	rows, err := stmt.Query(filter.Actor, filter.Action, filter.Target, nullableTime(since), nullableTime(until),
+		filter.Cursor, filter.Limit)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return entries, nil
+}
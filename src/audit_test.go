@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditChanges(t *testing.T) {
+	initTest(t)
+
+	before := IcecastMount{MountName: "christmas", Password: "old", Public: 1, StreamName: "Christmas"}
+	after := before
+	after.Password = "new"
+	after.Public = 0
+
+	changes := auditChanges(before, after)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v", changes)
+	}
+	if changes["public"].Before != float64(1) || changes["public"].After != float64(0) {
+		t.Fatalf("Unexpected public change: %+v", changes["public"])
+	}
+	if changes["password"].Before != redactedValue || changes["password"].After != redactedValue {
+		t.Fatalf("Password is not redacted: %+v", changes["password"])
+	}
+
+	deleted := auditChanges(before, nil)
+	if deleted["mount_name"].Before != "christmas" || deleted["mount_name"].After != nil {
+		t.Fatalf("Unexpected change of deleted mount: %+v", deleted["mount_name"])
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	initTest(t)
+
+	r := httptest.NewRequest("POST", "/api/streams", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if ip := clientIP(r); ip != "203.0.113.7" {
+		t.Fatalf("Expected the remote address for a direct call, got %s", ip)
+	}
+
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 192.0.2.4")
+	if ip := clientIP(r); ip != "192.0.2.4" {
+		t.Fatalf("Expected the address appended by the proxy, got %s", ip)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"os"
-	"runtime"
+	"slices"
+	"strings"
 	"sync"
 )
 
@@ -41,48 +43,29 @@ func getApplicationName() string {
 	return "StreamAPI"
 }
 
-var (
-	logLevel = DebugLog
-	mu       = sync.Mutex{}
-)
+var mu = sync.Mutex{}
 
-func setLogLevel(level LogType) {
-	mu.Lock()
-	defer mu.Unlock()
-	// Set the log level
-	logLevel = level
-}
+// logLevels are the valid log levels, from the most to the least verbose.
+var logLevels = []LogType{DebugLog, InfoLog, WarnLog, FatalLog}
 
-// logWithCaller logs a message with the class name and line number
-func logWithCaller(message string, logtype LogType) {
-	// Get the caller information
-	pc, file, line, ok := runtime.Caller(1) // 1 means the immediate caller of this function
-	if !ok {
-		log.Fatalf("[%s:%d] %s - FATAL: %s", file, line, "logWithCaller", message)
-		return
+// parseLogLevel validates a log level given in the flags or the API.
+func parseLogLevel(value string) (LogType, error) {
+	level := LogType(strings.ToLower(strings.TrimSpace(value)))
+	if !slices.Contains(logLevels, level) {
+		return "", fmt.Errorf("unknown log level: %s", value)
 	}
+	return level, nil
+}
 
-	// Get the function name
-	funcName := runtime.FuncForPC(pc).Name()
+// setLogLevel sets the configured log level, runtime changes return to it.
+func setLogLevel(level LogType) {
+	logLevelState.Lock()
+	defer logLevelState.Unlock()
+	logLevelState.configured = level
+	logLevelState.applyLocked(level, 0)
+}
 
-	switch logtype {
-	case DebugLog:
-		if logLevel == DebugLog {
-			log.Printf("[%s:%d] %s - DEBUG: %s", file, line, funcName, message)
-		}
-	case InfoLog:
-		if logLevel == DebugLog || logLevel == InfoLog {
-			log.Printf("[%s:%d] %s - INFO: %s", file, line, funcName, message)
-		}
-	case WarnLog:
-		if logLevel == DebugLog || logLevel == InfoLog || logLevel == WarnLog {
-			log.Printf("[%s:%d] %s - WARN: %s", file, line, funcName, message)
-		}
-	case FatalLog:
-		if logLevel == DebugLog || logLevel == InfoLog || logLevel == WarnLog || logLevel == FatalLog {
-			log.Printf("[%s:%d] %s - FATAL: %s", file, line, funcName, message)
-		}
-	default:
-		log.Printf("[%s:%d] %s - %s: %s", file, line, funcName, logtype, message)
-	}
+// logWithCaller logs a message with the file and line of the caller
+func logWithCaller(message string, logtype LogType) {
+	logRecord(context.Background(), 3, message, logtype)
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// maxBatchBodySize limits batch bodies, they hold many streams.
+	maxBatchBodySize   = 1 << 20
+	maxBatchOperations = 500
+)
+
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpPatch  = "patch"
+	BatchOpDelete = "delete"
+)
+
+// BatchOperation is one operation of a batch. Stream is the stream to create,
+// the new stream of an update or the JSON Merge Patch of a patch. IfMatch is
+// required for update, patch and delete, like the If-Match header.
+type BatchOperation struct {
+	Op        string          `json:"op"`
+	MountName string          `json:"mount_name"`
+	Stream    json.RawMessage `json:"stream,omitempty"`
+	IfMatch   string          `json:"if_match,omitempty"`
+}
+
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchResult reports one operation with the status and error code the
+// single request would have returned.
+type BatchResult struct {
+	Index     int          `json:"index"`
+	Op        string       `json:"op"`
+	MountName string       `json:"mount_name"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	ETag      string       `json:"etag,omitempty"`
+
+	before IcecastMount
+	mount  IcecastMount
+}
+
+type BatchResponse struct {
+	Atomic  bool          `json:"atomic"`
+	Applied int           `json:"applied"`
+	Failed  int           `json:"failed"`
+	Results []BatchResult `json:"results"`
+}
+
+func (result *BatchResult) fail(err error) {
+	status, apiErr := apiErrorResponse(err)
+	result.Status, result.Code, result.Error, result.Fields = status, apiErr.Code, apiErr.Error, apiErr.Fields
+}
+
+func (result BatchResult) failed() bool {
+	return result.Status >= http.StatusBadRequest
+}
+
+func (s *ApiServer) addBatchRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("POST "+autherized+"streams:batch", makeHTTPHandleFunc(s.idempotent(s.handleBatchStreams)))
+	addToRouteRightsMap("POST "+autherized+"streams:batch", "post_stream")
+}
+
+// handleBatchStreams runs many create, update, patch and delete operations
+// in one database transaction and writes the Icecast configs once afterwards.
+// Failed operations are reported per operation, with atomic nothing is
+// changed if one of them fails.
+func (s *ApiServer) handleBatchStreams(w http.ResponseWriter, r *http.Request) error {
+	var request BatchRequest
+	err := decodeJSONLimit(w, r, &request, maxBatchBodySize)
+	if err != nil {
+		return err
+	}
+	if len(request.Operations) == 0 || len(request.Operations) > maxBatchOperations {
+		return validationError(fieldError("operations", "must hold 1 to %d operations", maxBatchOperations))
+	}
+	logRequest(r, fmt.Sprintf("Running batch of %d operations, atomic %t", len(request.Operations), request.Atomic), InfoLog)
+
+	response := BatchResponse{Atomic: request.Atomic, Results: make([]BatchResult, len(request.Operations))}
+	seen := map[string]bool{}
+	var changes []MountChange
+	var planned []*BatchResult
+	for i, operation := range request.Operations {
+		result := &response.Results[i]
+		*result = BatchResult{Index: i, Op: operation.Op, MountName: operation.MountName}
+		change, err := s.planBatchOperation(r, operation, result)
+		if err == nil && seen[result.MountName] {
+			err = conflictError("stream %s appears more than once in the batch", result.MountName)
+		}
+		if err != nil {
+			result.fail(err)
+			continue
+		}
+		seen[result.MountName] = true
+		changes = append(changes, change)
+		planned = append(planned, result)
+	}
+
+	aborted := request.Atomic && len(planned) < len(request.Operations)
+	if !aborted && len(changes) > 0 {
+		errs, err := s.storage.ApplyIcecastMountChanges(changes, request.Atomic)
+		switch {
+		case errors.Is(err, ErrBatchAborted):
+			aborted = true
+		case err != nil:
+			logRequest(r, fmt.Sprintf("Database error running batch: %s", err), WarnLog)
+			return databaseError(err, "stream")
+		}
+		for i, result := range planned {
+			if errs[i] != nil {
+				result.fail(databaseError(errs[i], "stream"))
+			}
+		}
+	}
+
+	for _, result := range planned {
+		switch {
+		case result.failed():
+		case aborted:
+			result.fail(&StatusError{
+				Status:  http.StatusFailedDependency,
+				Code:    ErrorCodeBatchAborted,
+				Message: "not applied because another operation failed",
+			})
+		default:
+			s.finishBatchOperation(r, result)
+		}
+	}
+
+	status := http.StatusOK
+	for _, result := range response.Results {
+		if !result.failed() {
+			response.Applied++
+			continue
+		}
+		response.Failed++
+		if aborted && status == http.StatusOK && result.Code != ErrorCodeBatchAborted {
+			status = result.Status
+		}
+	}
+
+	setAuditTarget(r, "streams:batch")
+	if response.Applied > 0 {
+		setAuditChange(r, nil, response)
+	}
+	return WriteJson(w, status, response)
+}
+
+// planBatchOperation checks an operation like the single request would and
+// returns the change to store, without changing anything.
+func (s *ApiServer) planBatchOperation(r *http.Request, operation BatchOperation, result *BatchResult) (MountChange, error) {
+	if operation.Op == BatchOpCreate {
+		var mount IcecastMount
+		err := decodeBatchStream(operation.Stream, &mount)
+		if err != nil {
+			return MountChange{}, err
+		}
+		if operation.MountName != "" && operation.MountName != mount.MountName {
+			return MountChange{}, validationError(fieldError("mount_name", "must match the mount_name of the stream"))
+		}
+		result.MountName = mount.MountName
+		err = mount.Validate()
+		if err != nil {
+			return MountChange{}, err
+		}
+		_, err = s.storage.GetTrashedIcecastMount(mount.MountName)
+		if err == nil {
+			return MountChange{}, conflictError("stream %s is in the trash, restore or purge it first", mount.MountName)
+		}
+		_, err = s.storage.GetIcecastMount(mount.MountName)
+		if err == nil {
+			return MountChange{}, conflictError("stream %s already exists", mount.MountName)
+		}
+		result.mount = mount
+		return MountChange{Op: MountChangeCreate, Mount: mount}, nil
+	}
+
+	if operation.Op != BatchOpUpdate && operation.Op != BatchOpPatch && operation.Op != BatchOpDelete {
+		return MountChange{}, validationError(fieldError("op", "must be %s, %s, %s or %s", BatchOpCreate, BatchOpUpdate, BatchOpPatch, BatchOpDelete))
+	}
+	if operation.Op == BatchOpDelete && !getAuthInfo(r).hasRight("delete_stream") {
+		return MountChange{}, forbiddenError("deleting streams needs the delete_stream permission")
+	}
+	before, err := s.storage.GetIcecastMount(operation.MountName)
+	if err != nil {
+		return MountChange{}, databaseError(err, "stream")
+	}
+	if operation.IfMatch == "" {
+		return MountChange{}, &StatusError{
+			Status:  http.StatusPreconditionRequired,
+			Code:    ErrorCodePreconditionRequired,
+			Message: "if_match with the ETag of the stream is required",
+		}
+	}
+	if !matchesETag(operation.IfMatch, before) {
+		return MountChange{}, preconditionFailedError("stream was changed by someone else, fetch it again")
+	}
+	result.before = before
+
+	if operation.Op == BatchOpDelete {
+		return MountChange{Op: MountChangeDelete, Mount: before, DeletedBy: getAuthInfo(r).Username}, nil
+	}
+
+	var mount IcecastMount
+	if operation.Op == BatchOpPatch {
+		var patch map[string]any
+		err = decodeBatchStream(operation.Stream, &patch)
+		if err != nil {
+			return MountChange{}, err
+		}
+		mount, err = patchMount(before, patch)
+		if err != nil {
+			return MountChange{}, err
+		}
+		if mount.MountName != before.MountName {
+			return MountChange{}, validationError(fieldError("mount_name", "cannot be changed, rename the stream instead"))
+		}
+	} else {
+		err = decodeBatchStream(operation.Stream, &mount)
+		if err != nil {
+			return MountChange{}, err
+		}
+		mount.MountName = before.MountName
+	}
+	err = mount.Validate()
+	if err != nil {
+		return MountChange{}, err
+	}
+	mount.Version = before.Version
+	result.mount = mount
+	return MountChange{Op: MountChangeUpdate, Mount: mount}, nil
+}
+
+// decodeBatchStream decodes the stream of an operation as strictly as the
+// body of the single request.
+func decodeBatchStream(data json.RawMessage, v any) error {
+	if len(data) == 0 {
+		return validationError(fieldError("stream", "is required"))
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return jsonError(err)
+	}
+	return nil
+}
+
+// finishBatchOperation keeps the revision and writes the config of a stored
+// operation, like the single request does.
+func (s *ApiServer) finishBatchOperation(r *http.Request, result *BatchResult) {
+	var err error
+	switch result.Op {
+	case BatchOpCreate:
+		mount := result.mount
+		err = s.icecast.DeleteRedirectConfig(mount.MountName)
+		if err == nil {
+			err = s.scheduler.ApplyMountConfig(mount)
+		}
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Config error creating icecast mount: %s %s", mount.MountName, err), WarnLog)
+			err = internalError("file error")
+			break
+		}
+		s.events.Publish(EventStreamCreated, mount.MountName, mount.redacted())
+		mount.Version = 1
+		result.Status, result.ETag = http.StatusCreated, mountETag(mount)
+	case BatchOpDelete:
+		err = s.saveRevision(r, result.before, RevisionReasonDelete)
+		if err == nil {
+			err = s.removeStreamConfig(r, result.before)
+		}
+		if err != nil {
+			break
+		}
+		s.events.Publish(EventStreamDeleted, result.before.MountName, result.before.redacted())
+		result.Status = http.StatusOK
+	default:
+		mount := result.mount
+		err = s.saveRevision(r, result.before, RevisionReasonUpdate)
+		if err != nil {
+			break
+		}
+		err = s.scheduler.ApplyMountConfig(mount)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Config error updating icecast mount: %s %s", mount.MountName, err), WarnLog)
+			err = internalError("file error")
+			break
+		}
+		s.events.Publish(EventStreamUpdated, mount.MountName, mount.redacted())
+		mount.Version++
+		result.Status, result.ETag = http.StatusOK, mountETag(mount)
+	}
+	if err != nil {
+		result.fail(err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	MountChangeCreate = "create"
+	MountChangeUpdate = "update"
+	MountChangeDelete = "delete"
+)
+
+// ErrBatchAborted is returned if an all-or-nothing batch was rolled back.
+var ErrBatchAborted = errors.New("batch aborted")
+
+// MountChange is one change of a batch. Updates and deletes only apply if
+// the mount still has the version of Mount, unless it is 0.
+type MountChange struct {
+	Op        string
+	Mount     IcecastMount
+	DeletedBy string
+}
+
+// ApplyIcecastMountChanges runs the changes in one transaction and returns an
+// error per change. With atomic a single failed change rolls back all of them
+// and ErrBatchAborted is returned, otherwise the other changes are kept.
+func (s *SqliteStorage) ApplyIcecastMountChanges(changes []MountChange, atomic bool) ([]error, error) {
+	defer observeQuery("ApplyIcecastMountChanges", time.Now())
+	logWithCaller(fmt.Sprintf("Applying %d mount changes, atomic %t", len(changes), atomic), InfoLog)
+	tx, err := s.db.Begin()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error starting transaction: %v", err), FatalLog)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	errs := make([]error, len(changes))
+	failed := false
+	for i, change := range changes {
+		errs[i] = applyIcecastMountChange(tx, change)
+		if errs[i] != nil {
+			logWithCaller(fmt.Sprintf("Error applying %s of mount %s: %v", change.Op, change.Mount.MountName, errs[i]), WarnLog)
+			failed = true
+		}
+	}
+	if failed && atomic {
+		return errs, ErrBatchAborted
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error committing transaction: %v", err), FatalLog)
+		return nil, err
+	}
+	return errs, nil
+}
+
+// applyIcecastMountChange runs the change in a savepoint, so a failed change
+// leaves nothing behind in the transaction.
+func applyIcecastMountChange(tx *sql.Tx, change MountChange) error {
+	_, err := tx.Exec(`SAVEPOINT mount_change`)
+	if err != nil {
+		return err
+	}
+	err = execIcecastMountChange(tx, change)
+	if err != nil {
+		_, rollbackErr := tx.Exec(`ROLLBACK TO mount_change`)
+		if rollbackErr != nil {
+			return fmt.Errorf("%w, rolling back the change failed: %v", err, rollbackErr)
+		}
+	}
+	_, releaseErr := tx.Exec(`RELEASE mount_change`)
+	if err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func execIcecastMountChange(tx *sql.Tx, change MountChange) error {
+	mount := change.Mount
+	var result sql.Result
+	var err error
+	switch change.Op {
+	case MountChangeCreate:
+		_, err = tx.Exec(insertIcecastMountQuery, insertIcecastMountArgs(mount)...)
+		if err != nil {
+			return err
+		}
+		// A new mount takes over the name of a renamed one
+		_, err = tx.Exec(`DELETE FROM mount_redirects WHERE from_name = $1`, mount.MountName)
+		return err
+	case MountChangeUpdate:
+		result, err = tx.Exec(updateIcecastMountQuery, updateIcecastMountArgs(mount)...)
+	case MountChangeDelete:
+		result, err = tx.Exec(`
+		UPDATE icecast_mounts
+		SET deleted_at = $1,
+		deleted_by = $2
+		WHERE mount_name = $3 AND deleted_at IS NULL AND ($4 = 0 OR version = $4)
+		`, time.Now().UTC(), change.DeletedBy, mount.MountName, mount.Version)
+	default:
+		return fmt.Errorf("unknown change %s", change.Op)
+	}
+	if err != nil {
+		return err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 && mount.Version != 0 {
+		return fmt.Errorf("%w: mount %s version %d", ErrVersionMismatch, mount.MountName, mount.Version)
+	}
+	if affectedRows != 1 {
+		return affectedRowsError(affectedRows, "mount", mount.MountName)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyIcecastMountChanges(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate, Version: 1}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+
+	public := mount
+	public.Public = 1
+	stale := mount
+	stale.Version = 5
+	changes := []MountChange{
+		{Op: MountChangeUpdate, Mount: public},
+		{Op: MountChangeCreate, Mount: IcecastMount{MountName: "pfingsten", Username: "source", Password: "secret", TemplateType: DefaultTemplate}},
+		{Op: MountChangeDelete, Mount: stale},
+	}
+
+	errs, err := storage.ApplyIcecastMountChanges(changes, true)
+	if !errors.Is(err, ErrBatchAborted) || !errors.Is(errs[2], ErrVersionMismatch) {
+		t.Fatalf("Expected the atomic batch to be aborted, got %v %v", errs, err)
+	}
+	if _, err := storage.GetIcecastMount("pfingsten"); err == nil {
+		t.Fatalf("Expected the create to be rolled back")
+	}
+
+	errs, err = storage.ApplyIcecastMountChanges(changes, false)
+	if err != nil || errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], ErrVersionMismatch) {
+		t.Fatalf("Expected only the stale delete to fail, got %v %v", errs, err)
+	}
+	stored, err := storage.GetIcecastMount("ostern")
+	if err != nil || stored.Public != 1 || stored.Version != 2 {
+		t.Fatalf("Expected the update to be stored, got %+v %v", stored, err)
+	}
+	if _, err := storage.GetIcecastMount("pfingsten"); err != nil {
+		t.Fatalf("Expected the create to be stored, got %v", err)
+	}
+}
+
+// TestApplyIcecastMountChangesPartialFailure makes the second statement of a
+// create fail and expects the insert to be rolled back as well.
+func TestApplyIcecastMountChangesPartialFailure(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	_, err = storage.db.Exec(`
+	INSERT INTO mount_redirects (from_name, mount_name, created_at) VALUES ('advent', 'weihnachten', $1);
+	CREATE TRIGGER fail_redirect_delete BEFORE DELETE ON mount_redirects
+	BEGIN
+		SELECT RAISE(ABORT, 'redirect can not be deleted');
+	END;
+	`, time.Now().UTC())
+	if err != nil {
+		t.Fatalf("Failed to prepare redirect: %v", err)
+	}
+
+	changes := []MountChange{
+		{Op: MountChangeCreate, Mount: IcecastMount{MountName: "advent", Username: "source", Password: "secret", TemplateType: DefaultTemplate}},
+		{Op: MountChangeCreate, Mount: IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate}},
+	}
+	errs, err := storage.ApplyIcecastMountChanges(changes, false)
+	if err != nil || errs[0] == nil || errs[1] != nil {
+		t.Fatalf("Expected only the create of advent to fail, got %v %v", errs, err)
+	}
+	if _, err := storage.GetIcecastMount("advent"); err == nil {
+		t.Fatalf("Expected the insert of the failed create to be rolled back")
+	}
+	if _, err := storage.GetIcecastMount("ostern"); err != nil {
+		t.Fatalf("Expected the other create to be stored, got %v", err)
+	}
+}
+
+func TestPlanBatchOperationRequiresIfMatch(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	mount.Version = 1
+	s := &ApiServer{storage: storage}
+	token, err := createToken("admin", rightsAdmin, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	r := httptest.NewRequest("POST", "/api/v1/streams:batch", nil)
+	r = r.WithContext(context.WithValue(r.Context(), authContextKey, authInfo{Username: "admin", Token: token}))
+
+	stream := json.RawMessage(`{"username":"source","password":"secret"}`)
+	for _, op := range []string{BatchOpUpdate, BatchOpPatch, BatchOpDelete} {
+		operation := BatchOperation{Op: op, MountName: "ostern", Stream: stream}
+		_, err := s.planBatchOperation(r, operation, &BatchResult{})
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.Code != ErrorCodePreconditionRequired {
+			t.Errorf("Expected %s without if_match to be rejected, got %v", op, err)
+		}
+
+		operation.IfMatch = `"2"`
+		_, err = s.planBatchOperation(r, operation, &BatchResult{})
+		if !errors.As(err, &statusErr) || statusErr.Code != ErrorCodePreconditionFailed {
+			t.Errorf("Expected %s with a stale if_match to be rejected, got %v", op, err)
+		}
+
+		operation.IfMatch = mountETag(mount)
+		_, err = s.planBatchOperation(r, operation, &BatchResult{})
+		if err != nil {
+			t.Errorf("Expected %s with if_match to be planned, got %v", op, err)
+		}
+	}
+}
+
+func TestPlanBatchOperationForbidden(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate, Version: 1}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+	token, err := createToken("editor", []string{"get_stream", "post_stream"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	r := httptest.NewRequest("POST", "/api/v1/streams:batch", nil)
+	r = r.WithContext(context.WithValue(r.Context(), authContextKey, authInfo{Username: "editor", Token: token}))
+
+	operation := BatchOperation{Op: BatchOpDelete, MountName: "ostern", IfMatch: mountETag(mount)}
+	_, err = s.planBatchOperation(r, operation, &BatchResult{})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusForbidden || statusErr.Code != ErrorCodeForbidden {
+		t.Fatalf("Expected a delete without delete_stream to be forbidden, got %v", err)
+	}
+}
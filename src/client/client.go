@@ -0,0 +1,220 @@
+// Package client is a Go client for the kulturtelefon-stream API.
+//
+//	c := client.New("https://stream.example.org")
+//	err := c.Login(ctx, "admin", "secret")
+//	stream, err := c.GetStream(ctx, "ostern")
+//
+// Errors returned by the API are *Error values, they can be compared with
+// errors.Is, e.g. errors.Is(err, client.ErrNotFound).
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix = "/api/v1/"
+
+	defaultRetries   = 3
+	defaultRetryWait = 500 * time.Millisecond
+	maxRetryWait     = 30 * time.Second
+
+	idempotencyKeyHeader = "Idempotency-Key"
+	requestIDHeader      = "X-Request-ID"
+)
+
+// Client calls the API of one server. It is safe for concurrent use once
+// configured.
+type Client struct {
+	// BaseURL is the URL of the server, e.g. https://stream.example.org.
+	BaseURL string
+	// Token is sent in the Authorization header, Login sets it.
+	Token string
+	// HTTPClient is used for the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Retries is how often a failed request is repeated, 0 disables retries.
+	// Only requests that are safe to repeat are retried: GET requests and
+	// requests with an Idempotency-Key.
+	Retries int
+	// RetryWait is the wait before the first retry, it doubles with every
+	// retry. A Retry-After header of the server takes precedence.
+	RetryWait time.Duration
+}
+
+// New returns a client for the server at baseURL with the default retries.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		Retries:   defaultRetries,
+		RetryWait: defaultRetryWait,
+	}
+}
+
+// Login gets a token for username and password and uses it for the following
+// requests.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	query := url.Values{"username": {username}, "password": {password}}
+	var response struct {
+		Token string `json:"token"`
+	}
+	_, err := c.do(ctx, request{method: http.MethodGet, path: "/user/token", query: query}, &response)
+	if err != nil {
+		return err
+	}
+	if response.Token == "" {
+		return errors.New("server returned no token")
+	}
+	c.Token = response.Token
+	return nil
+}
+
+// request describes a call, path is relative to the API prefix unless it
+// starts with a slash.
+type request struct {
+	method  string
+	path    string
+	query   url.Values
+	body    any
+	headers map[string]string
+}
+
+// do sends the request, retries it if possible and decodes the JSON response
+// into v unless v is nil. It returns the response headers.
+func (c *Client) do(ctx context.Context, req request, v any) (http.Header, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return nil, fmt.Errorf("encoding request: %w", err)
+		}
+	}
+
+	path := req.path
+	if !strings.HasPrefix(path, "/") {
+		path = apiPrefix + path
+	}
+	target := c.BaseURL + path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	retryable := req.method == http.MethodGet || req.headers[idempotencyKeyHeader] != ""
+
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		header, retryAfter, err := c.send(ctx, req, target, body, v)
+		if err == nil || !retryable || attempt >= c.Retries || !temporary(err) || ctx.Err() != nil {
+			return header, err
+		}
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		timer := time.NewTimer(min(wait, maxRetryWait))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return header, ctx.Err()
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
+
+// send makes one attempt and returns the Retry-After wait of the server.
+func (c *Client) send(ctx context.Context, req request, target string, body []byte, v any) (http.Header, time.Duration, error) {
+	httpRequest, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		httpRequest.Header.Set("Content-Type", "application/json")
+	}
+	httpRequest.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		httpRequest.Header.Set("Authorization", c.Token)
+	}
+	for name, value := range req.headers {
+		if value != "" {
+			httpRequest.Header.Set(name, value)
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return response.Header, retryAfter(response.Header), decodeError(response)
+	}
+	if v == nil || response.StatusCode == http.StatusNoContent {
+		return response.Header, 0, nil
+	}
+	err = json.NewDecoder(response.Body).Decode(v)
+	if err != nil {
+		return response.Header, 0, fmt.Errorf("decoding response: %w", err)
+	}
+	return response.Header, 0, nil
+}
+
+// temporary reports whether a failed attempt may succeed when repeated.
+func temporary(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// Network errors, the context is checked by the caller
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+func retryAfter(header http.Header) time.Duration {
+	seconds, err := strconv.Atoi(header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func decodeError(response *http.Response) error {
+	apiErr := &Error{StatusCode: response.StatusCode, RequestID: response.Header.Get(requestIDHeader)}
+	data, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(response.StatusCode)
+		}
+	}
+	return apiErr
+}
+
+// newIdempotencyKey returns a random key, so a create can be retried without
+// creating twice.
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(key)
+}
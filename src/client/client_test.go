@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		}
+		if calls.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.Write([]byte(`{"mount_name":"ostern"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	c.RetryWait = time.Millisecond
+	ctx := context.Background()
+
+	stream, err := c.GetStream(ctx, "ostern")
+	if err != nil || stream.MountName != "ostern" || calls.Load() != 2 {
+		t.Fatalf("Expected the GET to be retried, got %+v %v after %d calls", stream, err, calls.Load())
+	}
+
+	_, err = c.CreateStream(ctx, Stream{MountName: "ostern"})
+	if err != nil || len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("Expected the create to be retried with the same key, got %v %v", keys, err)
+	}
+
+	calls.Store(0)
+	_, err = c.UpdateStream(ctx, Stream{MountName: "ostern", ETag: `"1"`})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("Expected the update not to be retried, got %v after %d calls", err, calls.Load())
+	}
+
+	calls.Store(0)
+	c.RetryWait = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = c.GetStream(ctx, "ostern")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the context to end the retries, got %v", err)
+	}
+}
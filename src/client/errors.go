@@ -0,0 +1,60 @@
+package client
+
+import "fmt"
+
+// Error codes of the API, see Error.Code.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeTooLarge             = "too_large"
+	CodeValidation           = "validation"
+	CodePreconditionRequired = "precondition_required"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeBatchAborted         = "aborted"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeInternal             = "internal"
+)
+
+// Errors to compare with errors.Is, they match an *Error with the same code.
+var (
+	ErrUnauthorized         = &Error{Code: CodeUnauthorized}
+	ErrForbidden            = &Error{Code: CodeForbidden}
+	ErrNotFound             = &Error{Code: CodeNotFound}
+	ErrConflict             = &Error{Code: CodeConflict}
+	ErrPreconditionFailed   = &Error{Code: CodePreconditionFailed}
+	ErrPreconditionRequired = &Error{Code: CodePreconditionRequired}
+	ErrValidation           = &Error{Code: CodeValidation}
+)
+
+// FieldError describes an invalid field of the request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an error response of the API.
+type Error struct {
+	StatusCode int          `json:"-"`
+	Code       string       `json:"code"`
+	Message    string       `json:"error"`
+	Fields     []FieldError `json:"fields,omitempty"`
+	RequestID  string       `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is matches errors with the same code, so errors.Is(err, ErrNotFound) works
+// for every not found response.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ListenerAccount is a user who may listen to a private stream, with a
+// username and password or an access code. The server generates an access
+// code if neither is given and returns it once.
+type ListenerAccount struct {
+	ID          int64      `json:"id,omitempty"`
+	MountName   string     `json:"mount_name,omitempty"`
+	Username    string     `json:"username,omitempty"`
+	Password    string     `json:"password,omitempty"`
+	AccessCode  string     `json:"access_code,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxSessions int        `json:"max_sessions"`
+	CreatedAt   time.Time  `json:"created_at,omitzero"`
+}
+
+// ListListeners returns the listener accounts of a stream.
+func (c *Client) ListListeners(ctx context.Context, stream string) ([]ListenerAccount, error) {
+	var accounts []ListenerAccount
+	_, err := c.do(ctx, request{method: http.MethodGet, path: listenersPath(stream)}, &accounts)
+	return accounts, err
+}
+
+// GetListener returns a listener account.
+func (c *Client) GetListener(ctx context.Context, stream string, id int64) (ListenerAccount, error) {
+	var account ListenerAccount
+	_, err := c.do(ctx, request{method: http.MethodGet, path: listenerPath(stream, id)}, &account)
+	return account, err
+}
+
+// CreateListener creates a listener account for a stream.
+func (c *Client) CreateListener(ctx context.Context, stream string, account ListenerAccount) (ListenerAccount, error) {
+	var created ListenerAccount
+	_, err := c.do(ctx, request{method: http.MethodPost, path: listenersPath(stream), body: account}, &created)
+	return created, err
+}
+
+// UpdateListener changes the listener account with account.ID, an empty
+// password and access code keep the current ones.
+func (c *Client) UpdateListener(ctx context.Context, stream string, account ListenerAccount) (ListenerAccount, error) {
+	var updated ListenerAccount
+	_, err := c.do(ctx, request{method: http.MethodPost, path: listenerPath(stream, account.ID), body: account}, &updated)
+	return updated, err
+}
+
+// DeleteListener deletes a listener account.
+func (c *Client) DeleteListener(ctx context.Context, stream string, id int64) error {
+	_, err := c.do(ctx, request{method: http.MethodDelete, path: listenerPath(stream, id)}, nil)
+	return err
+}
+
+func listenersPath(stream string) string {
+	return streamPath(stream) + "/listeners"
+}
+
+func listenerPath(stream string, id int64) string {
+	return listenersPath(stream) + "/" + strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	TemplateDefault = "default"
+	TemplatePrivate = "private"
+)
+
+// Stream is an Icecast mount. ETag is the version the server returned, it is
+// sent as If-Match by UpdateStream.
+type Stream struct {
+	MountName              string `json:"mount_name"`
+	Username               string `json:"username"`
+	Password               string `json:"password"`
+	Public                 int    `json:"public"`
+	StreamName             string `json:"stream_name"`
+	StreamDescription      string `json:"stream_description"`
+	TemplateType           string `json:"template_type"`
+	Recording              bool   `json:"recording"`
+	RecordingPattern       string `json:"recording_pattern,omitempty"`
+	RecordingRetentionDays int    `json:"recording_retention_days,omitempty"`
+	Podcast                bool   `json:"podcast"`
+
+	ETag string `json:"-"`
+}
+
+// ListOptions filters and pages ListStreams, zero values are left out.
+type ListOptions struct {
+	Limit  int
+	Cursor string
+	// Sort is mount_name, stream_name or created, with a leading - descending.
+	Sort         string
+	Public       *bool
+	TemplateType string
+	// Query searches mount name, stream name and description.
+	Query string
+}
+
+// StreamPage is a page of streams. NextCursor is empty on the last page.
+type StreamPage struct {
+	Streams    []Stream
+	Total      int
+	NextCursor string
+}
+
+func (options ListOptions) values() url.Values {
+	query := url.Values{}
+	if options.Limit > 0 {
+		query.Set("limit", strconv.Itoa(options.Limit))
+	}
+	if options.Cursor != "" {
+		query.Set("cursor", options.Cursor)
+	}
+	if options.Sort != "" {
+		query.Set("sort", options.Sort)
+	}
+	if options.Public != nil {
+		public := "0"
+		if *options.Public {
+			public = "1"
+		}
+		query.Set("public", public)
+	}
+	if options.TemplateType != "" {
+		query.Set("template_type", options.TemplateType)
+	}
+	if options.Query != "" {
+		query.Set("q", options.Query)
+	}
+	return query
+}
+
+// ListStreams returns a page of streams.
+func (c *Client) ListStreams(ctx context.Context, options ListOptions) (StreamPage, error) {
+	var page StreamPage
+	header, err := c.do(ctx, request{method: http.MethodGet, path: "streams", query: options.values()}, &page.Streams)
+	if err != nil {
+		return StreamPage{}, err
+	}
+	page.Total, _ = strconv.Atoi(header.Get("X-Total-Count"))
+	page.NextCursor = header.Get("X-Next-Cursor")
+	return page, nil
+}
+
+// AllStreams follows the cursors of ListStreams and returns every stream.
+func (c *Client) AllStreams(ctx context.Context, options ListOptions) ([]Stream, error) {
+	var streams []Stream
+	for {
+		page, err := c.ListStreams(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, page.Streams...)
+		if page.NextCursor == "" {
+			return streams, nil
+		}
+		options.Cursor = page.NextCursor
+	}
+}
+
+// GetStream returns a stream with its ETag.
+func (c *Client) GetStream(ctx context.Context, name string) (Stream, error) {
+	var stream Stream
+	header, err := c.do(ctx, request{method: http.MethodGet, path: streamPath(name)}, &stream)
+	if err != nil {
+		return Stream{}, err
+	}
+	stream.ETag = header.Get("ETag")
+	return stream, nil
+}
+
+// CreateStream creates a stream. It is sent with an Idempotency-Key, so it
+// is retried like a GET without the risk of creating the stream twice.
+func (c *Client) CreateStream(ctx context.Context, stream Stream) (Stream, error) {
+	return c.writeStream(ctx, request{
+		method:  http.MethodPost,
+		path:    "streams",
+		body:    stream,
+		headers: map[string]string{idempotencyKeyHeader: newIdempotencyKey()},
+	})
+}
+
+// UpdateStream replaces the stream with stream.MountName. stream.ETag is
+// required, the update fails with ErrPreconditionFailed if the stream was
+// changed since.
+func (c *Client) UpdateStream(ctx context.Context, stream Stream) (Stream, error) {
+	return c.writeStream(ctx, request{
+		method:  http.MethodPost,
+		path:    streamPath(stream.MountName),
+		body:    stream,
+		headers: map[string]string{"If-Match": stream.ETag},
+	})
+}
+
+// PatchStream changes the given fields of a stream, see JSON Merge Patch
+// (RFC 7386). A nil value removes a field.
+func (c *Client) PatchStream(ctx context.Context, name, etag string, patch map[string]any) (Stream, error) {
+	return c.writeStream(ctx, request{
+		method:  http.MethodPatch,
+		path:    streamPath(name),
+		body:    patch,
+		headers: map[string]string{"If-Match": etag},
+	})
+}
+
+// DeleteStream moves a stream to the trash.
+func (c *Client) DeleteStream(ctx context.Context, name, etag string) error {
+	_, err := c.do(ctx, request{
+		method:  http.MethodDelete,
+		path:    streamPath(name),
+		headers: map[string]string{"If-Match": etag},
+	}, nil)
+	return err
+}
+
+func (c *Client) writeStream(ctx context.Context, req request) (Stream, error) {
+	var stream Stream
+	header, err := c.do(ctx, req, &stream)
+	if err != nil {
+		return Stream{}, err
+	}
+	stream.ETag = header.Get("ETag")
+	return stream, nil
+}
+
+func streamPath(name string) string {
+	return "streams/" + url.PathEscape(name)
+}
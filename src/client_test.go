@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/anux-linux/kulturtelefon-stream/client"
+)
+
+// TestClient runs the client package against the API server.
+func TestClient(t *testing.T) {
+	initTest(t)
+
+	dir := t.TempDir()
+	s, err := StreamAPI("", Config{
+		IcecastMountsFolder:  dir,
+		DbFile:               filepath.Join(dir, "test.db"),
+		DefaultMountTemplate: "../scripts/templates/default_mount.tmpl",
+		PrivateMountTemplate: "../scripts/templates/private_mount.tmpl",
+		AdminUsername:        "admin",
+		AdminPassword:        "secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	ctx := context.Background()
+	c := client.New(server.URL)
+
+	_, err = c.ListStreams(ctx, client.ListOptions{})
+	if !errors.Is(err, client.ErrUnauthorized) {
+		t.Fatalf("Expected listing without token to be unauthorized, got %v", err)
+	}
+	if err := c.Login(ctx, "admin", "wrong"); err == nil {
+		t.Fatalf("Expected login with a wrong password to fail")
+	}
+	if err := c.Login(ctx, "admin", "secret"); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	ostern := client.Stream{MountName: "ostern", Username: "source", Password: "secret", StreamName: "Ostern", TemplateType: client.TemplateDefault}
+	created, err := c.CreateStream(ctx, ostern)
+	if err != nil || created.MountName != "ostern" || created.ETag == "" {
+		t.Fatalf("Failed to create stream: %+v %v", created, err)
+	}
+	_, err = c.CreateStream(ctx, ostern)
+	if !errors.Is(err, client.ErrConflict) {
+		t.Fatalf("Expected creating the stream again to conflict, got %v", err)
+	}
+	_, err = c.CreateStream(ctx, client.Stream{MountName: "Ostern!"})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != client.CodeValidation || len(apiErr.Fields) == 0 {
+		t.Fatalf("Expected field errors for an invalid stream, got %v", err)
+	}
+
+	stream, err := c.GetStream(ctx, "ostern")
+	if err != nil || stream.ETag != created.ETag {
+		t.Fatalf("Failed to get stream: %+v %v", stream, err)
+	}
+	stream.StreamName = "Ostermesse"
+	updated, err := c.UpdateStream(ctx, stream)
+	if err != nil || updated.StreamName != "Ostermesse" || updated.ETag == stream.ETag {
+		t.Fatalf("Failed to update stream: %+v %v", updated, err)
+	}
+	_, err = c.UpdateStream(ctx, stream)
+	if !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Fatalf("Expected an update with a stale ETag to fail, got %v", err)
+	}
+	patched, err := c.PatchStream(ctx, "ostern", updated.ETag, map[string]any{"public": 1})
+	if err != nil || patched.Public != 1 || patched.StreamName != "Ostermesse" {
+		t.Fatalf("Failed to patch stream: %+v %v", patched, err)
+	}
+
+	private := client.Stream{MountName: "pfingsten", Username: "source", Password: "secret", TemplateType: client.TemplatePrivate}
+	if _, err := c.CreateStream(ctx, private); err != nil {
+		t.Fatalf("Failed to create private stream: %v", err)
+	}
+	page, err := c.ListStreams(ctx, client.ListOptions{Limit: 1})
+	if err != nil || len(page.Streams) != 1 || page.Total != 2 || page.NextCursor == "" {
+		t.Fatalf("Expected the first of two pages, got %+v %v", page, err)
+	}
+	streams, err := c.AllStreams(ctx, client.ListOptions{Limit: 1})
+	if err != nil || len(streams) != 2 || streams[0].MountName != "ostern" || streams[1].MountName != "pfingsten" {
+		t.Fatalf("Expected all streams, got %+v %v", streams, err)
+	}
+
+	account, err := c.CreateListener(ctx, "pfingsten", client.ListenerAccount{Username: "gemeinde", Password: "geheim", MaxSessions: 1})
+	if err != nil || account.ID == 0 {
+		t.Fatalf("Failed to create listener account: %+v %v", account, err)
+	}
+	account.MaxSessions = 2
+	account, err = c.UpdateListener(ctx, "pfingsten", account)
+	if err != nil || account.MaxSessions != 2 {
+		t.Fatalf("Failed to update listener account: %+v %v", account, err)
+	}
+	accounts, err := c.ListListeners(ctx, "pfingsten")
+	if err != nil || len(accounts) != 1 || accounts[0].Username != "gemeinde" {
+		t.Fatalf("Expected one listener account, got %+v %v", accounts, err)
+	}
+	if err := c.DeleteListener(ctx, "pfingsten", account.ID); err != nil {
+		t.Fatalf("Failed to delete listener account: %v", err)
+	}
+	_, err = c.GetListener(ctx, "pfingsten", account.ID)
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Expected the listener account to be gone, got %v", err)
+	}
+
+	err = c.DeleteStream(ctx, "ostern", "")
+	if !errors.Is(err, client.ErrPreconditionRequired) {
+		t.Fatalf("Expected deleting without ETag to fail, got %v", err)
+	}
+	if err := c.DeleteStream(ctx, "ostern", patched.ETag); err != nil {
+		t.Fatalf("Failed to delete stream: %v", err)
+	}
+	_, err = c.GetStream(ctx, "ostern")
+	if !errors.Is(err, client.ErrNotFound) || !errors.As(err, &apiErr) || apiErr.RequestID == "" {
+		t.Fatalf("Expected the stream to be gone, got %v", err)
+	}
+}
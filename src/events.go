@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventStreamCreated      = "stream.created"
+	EventStreamUpdated      = "stream.updated"
+	EventStreamDeleted      = "stream.deleted"
+	EventStreamRestored     = "stream.restored"
+	EventStreamRenamed      = "stream.renamed"
+	EventSourceConnected    = "source.connected"
+	EventSourceDisconnected = "source.disconnected"
+	EventListenersChanged   = "listeners.changed"
+	EventConfigReloaded     = "config.reloaded"
+	EventConfigFailed       = "config.failed"
+	EventAlertRaised        = "alert.raised"
+	EventAlertResolved      = "alert.resolved"
+
+	// eventHistorySize is the number of events kept for Last-Event-ID resume.
+	eventHistorySize   = 1000
+	eventBufferSize    = 64
+	eventKeepAliveTime = 30 * time.Second
+)
+
+var eventTypes = []string{
+	EventStreamCreated,
+	EventStreamUpdated,
+	EventStreamDeleted,
+	EventStreamRestored,
+	EventStreamRenamed,
+	EventSourceConnected,
+	EventSourceDisconnected,
+	EventListenersChanged,
+	EventConfigReloaded,
+	EventConfigFailed,
+	EventAlertRaised,
+	EventAlertResolved,
+}
+
+// Event is something that happened to a stream.
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	MountName string    `json:"mount_name,omitempty"`
+	Time      time.Time `json:"time"`
+	Data      any       `json:"data,omitempty"`
+}
+
+type eventSubscriber struct {
+	events chan Event
+	filter func(Event) bool
+}
+
+// EventBroker fans out events to all subscribers and keeps the latest events
+// so clients can resume after a reconnect.
+type EventBroker struct {
+	mu          sync.Mutex
+	nextID      int64
+	history     []Event
+	subscribers map[*eventSubscriber]struct{}
+	handlers    []func(Event)
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{
+		nextID:      1,
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+// Publish sends an event to all subscribers and handlers. Subscribers that can
+// not keep up miss events, handlers are called before Publish returns.
+func (b *EventBroker) Publish(eventType, mountName string, data any) {
+	b.mu.Lock()
+
+	event := Event{
+		ID:        b.nextID,
+		Type:      eventType,
+		MountName: mountName,
+		Time:      time.Now().UTC(),
+		Data:      data,
+	}
+	b.nextID++
+
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+
+	for subscriber := range b.subscribers {
+		if subscriber.filter != nil && !subscriber.filter(event) {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			logWithCaller(fmt.Sprintf("Event subscriber too slow, dropping event %d", event.ID), WarnLog)
+		}
+	}
+	handlers := b.handlers
+	b.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// Handle registers f to be called with every published event. Unlike a
+// subscriber it never misses an event, it runs in the goroutine of Publish.
+func (b *EventBroker) Handle(f func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, f)
+}
+
+// Subscribe registers a subscriber and returns the events after lastEventID
+// that are still in the history.
+func (b *EventBroker) Subscribe(lastEventID int64, filter func(Event) bool) (*eventSubscriber, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriber := &eventSubscriber{
+		events: make(chan Event, eventBufferSize),
+		filter: filter,
+	}
+	b.subscribers[subscriber] = struct{}{}
+
+	missed := []Event{}
+	if lastEventID > 0 {
+		for _, event := range b.history {
+			if event.ID > lastEventID && (filter == nil || filter(event)) {
+				missed = append(missed, event)
+			}
+		}
+	}
+	return subscriber, missed
+}
+
+func (b *EventBroker) Unsubscribe(subscriber *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, subscriber)
+}
+
+// ###########
+// Event routes
+// ###########
+
+func (s *ApiServer) addEventRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"events", makeHTTPHandleFunc(s.handleEvents))
+	addToRouteRightsMap("GET "+autherized+"events", "get_stream")
+}
+
+// getVisibleStream returns the stream a caller may see. Tokens with
+// get_all_streams may see all streams, which is reported by all.
+func (s *ApiServer) getVisibleStream(r *http.Request) (mountName string, all bool, err error) {
+	auth := getAuthInfo(r)
+	if auth.hasRight("get_all_streams") {
+		return "", true, nil
+	}
+
+	ownStream, err := s.storage.GetUserStream(auth.Username)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching stream of user %s: %s", auth.Username, err), WarnLog)
+		return "", false, databaseError(err, "stream")
+	}
+	return ownStream, false, nil
+}
+
+// getEventFilter limits the events to the streams the caller may see. Tokens
+// with get_all_streams see everything, other users only the stream they own.
+func (s *ApiServer) getEventFilter(r *http.Request) (func(Event) bool, error) {
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil || all {
+		return nil, err
+	}
+	return func(event Event) bool {
+		return ownStream != "" && event.MountName == ownStream
+	}, nil
+}
+
+// handleEvents streams events as Server-Sent Events. Clients resume with the
+// Last-Event-ID header or the last_event_id query parameter and can limit the
+// event types with a comma separated types parameter.
+func (s *ApiServer) handleEvents(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming not supported")
+	}
+
+	lastEventIDValue := r.Header.Get("Last-Event-ID")
+	if lastEventIDValue == "" {
+		lastEventIDValue = r.URL.Query().Get("last_event_id")
+	}
+	var lastEventID int64
+	if lastEventIDValue != "" {
+		var err error
+		lastEventID, err = strconv.ParseInt(lastEventIDValue, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid last event id")
+		}
+	}
+
+	userFilter, err := s.getEventFilter(r)
+	if err != nil {
+		return err
+	}
+	types := splitList(r.URL.Query().Get("types"))
+	filter := func(event Event) bool {
+		if userFilter != nil && !userFilter(event) {
+			return false
+		}
+		return len(types) == 0 || types[event.Type]
+	}
+
+	subscriber, missed := s.events.Subscribe(lastEventID, filter)
+	defer s.events.Unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range missed {
+		err = writeEvent(w, event)
+		if err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveTime)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-subscriber.events:
+			err = writeEvent(w, event)
+		}
+		if err != nil {
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// splitList turns a comma separated list into a set.
+func splitList(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
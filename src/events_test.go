@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBroker(t *testing.T) {
+	initTest(t)
+
+	events := NewEventBroker()
+	events.Publish(EventStreamCreated, "ostern", nil)
+	events.Publish(EventStreamCreated, "pfingsten", nil)
+	events.Publish(EventStreamUpdated, "ostern", nil)
+
+	tests := []struct {
+		lastEventID int64
+		filter      func(Event) bool
+		missed      []int64
+	}{
+		{0, nil, nil},
+		{1, nil, []int64{2, 3}},
+		{3, nil, nil},
+		{1, func(event Event) bool { return event.MountName == "ostern" }, []int64{3}},
+	}
+	for _, test := range tests {
+		subscriber, missed := events.Subscribe(test.lastEventID, test.filter)
+		events.Unsubscribe(subscriber)
+		ids := []int64{}
+		for _, event := range missed {
+			ids = append(ids, event.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.missed) {
+			t.Errorf("Expected the events %v after %d, got %v", test.missed, test.lastEventID, ids)
+		}
+	}
+
+	subscriber, _ := events.Subscribe(0, func(event Event) bool { return event.MountName == "ostern" })
+	defer events.Unsubscribe(subscriber)
+	handled := 0
+	events.Handle(func(Event) { handled++ })
+	for i := 0; i < eventBufferSize+10; i++ {
+		events.Publish(EventListenersChanged, "ostern", nil)
+		events.Publish(EventListenersChanged, "pfingsten", nil)
+	}
+	if len(subscriber.events) != eventBufferSize {
+		t.Fatalf("Expected a full buffer of %d events, got %d", eventBufferSize, len(subscriber.events))
+	}
+	if event := <-subscriber.events; event.ID != 4 || event.MountName != "ostern" {
+		t.Fatalf("Expected the first filtered event to be kept, got %+v", event)
+	}
+	if handled != 2*(eventBufferSize+10) {
+		t.Fatalf("Expected handlers to get every event, got %d", handled)
+	}
+}
+
+func TestHandleEventsResume(t *testing.T) {
+	initTest(t)
+
+	s := &ApiServer{events: NewEventBroker()}
+	s.events.Publish(EventStreamCreated, "ostern", nil)
+	s.events.Publish(EventStreamUpdated, "ostern", nil)
+	s.events.Publish(EventSourceConnected, "ostern", nil)
+	s.events.Publish(EventStreamUpdated, "pfingsten", nil)
+
+	token, err := createToken("admin", rightsAdmin, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), authContextKey, authInfo{Username: "admin", Token: token}))
+	// The handler writes the missed events and returns on the closed connection.
+	cancel()
+	r := httptest.NewRequest("GET", "/api/events?types=stream.updated", nil).WithContext(ctx)
+	r.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+	if err := s.handleEvents(w, r); err != nil {
+		t.Fatalf("Failed to stream events: %v", err)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "id: 2\nevent: stream.updated\n") || !strings.Contains(body, "id: 4\nevent: stream.updated\n") {
+		t.Fatalf("Expected the missed updates, got %q", body)
+	}
+	if strings.Contains(body, "id: 1\n") || strings.Contains(body, "id: 3\n") {
+		t.Fatalf("Expected older and other events to be left out, got %q", body)
+	}
+}
+
+func TestGetEventFilter(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+	for _, username := range []string{"gemeinde", "gast"} {
+		if err := storage.SaveUser(username, "hash"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	if _, err := storage.db.Exec("UPDATE users SET icecast_mount = $1 WHERE username = $2", "ostern", "gemeinde"); err != nil {
+		t.Fatalf("Failed to assign stream: %v", err)
+	}
+
+	tests := []struct {
+		username string
+		rights   []string
+		visible  map[string]bool
+	}{
+		{"admin", rightsAdmin, map[string]bool{"ostern": true, "pfingsten": true, "": true}},
+		{"gemeinde", []string{"get_stream"}, map[string]bool{"ostern": true, "pfingsten": false, "": false}},
+		{"gast", []string{"get_stream"}, map[string]bool{"ostern": false, "pfingsten": false, "": false}},
+	}
+	for _, test := range tests {
+		token, err := createToken(test.username, test.rights, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		r := httptest.NewRequest("GET", "/api/events", nil)
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey, authInfo{Username: test.username, Token: token}))
+		filter, err := s.getEventFilter(r)
+		if err != nil {
+			t.Fatalf("Failed to get the event filter of %s: %v", test.username, err)
+		}
+		for mountName, visible := range test.visible {
+			if got := filter == nil || filter(Event{Type: EventStreamUpdated, MountName: mountName}); got != visible {
+				t.Errorf("Expected %s to see events of %q %t, got %t", test.username, mountName, visible, got)
+			}
+		}
+	}
+}
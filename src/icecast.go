@@ -1,10 +1,14 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
+	"time"
 )
 
 type IcecastConfigStore struct {
@@ -13,6 +17,30 @@ type IcecastConfigStore struct {
 
 type TemplateType string
 
+// mountTemplateData is handed to the mount templates. It embeds the mount so
+// existing templates keep working and adds values derived from the config.
+type mountTemplateData struct {
+	IcecastMount
+	ListenerAuthType     string
+	ListenerHtpasswdFile string
+	ListenerAddURL       string
+	ListenerRemoveURL    string
+	DumpFile             string
+}
+
+// mountTemplateFuncs are available in the mount templates. Free text like
+// stream names may contain '<', '>' and '&', templates write it with xml.
+var mountTemplateFuncs = template.FuncMap{
+	"xml": xmlEscape,
+}
+
+// xmlEscape escapes text for XML element content and attribute values.
+func xmlEscape(text string) (string, error) {
+	var escaped strings.Builder
+	err := xml.EscapeText(&escaped, []byte(text))
+	return escaped.String(), err
+}
+
 const (
 	DefaultTemplate TemplateType = "default"
 	PrivateTemplate TemplateType = "private"
@@ -36,26 +64,19 @@ func (icConf *IcecastConfigStore) getMountConfigTemplate(templateType TemplateTy
 	}
 }
 
-func (icConf *IcecastConfigStore) SaveMountConfig(mount IcecastMount) error {
+func (icConf *IcecastConfigStore) SaveMountConfig(mount IcecastMount) (err error) {
+	defer countMountFileError("write", &err)
 
-	// Get the mount configuration template
-	templateFile := icConf.getMountConfigTemplate(mount.TemplateType)
 	mountsDirectory := icConf.config.IcecastMountsFolder
 	fielname := icConf.getMountConfigFileName(mount.MountName, mount.TemplateType)
 
-	if !checkFileExists(templateFile) {
-		logWithCaller(fmt.Sprintf("Template file does not exist: %s", templateFile), FatalLog)
-		return fmt.Errorf("femplate file does not exist: %s", templateFile)
-	}
 	if !checkDirectoryExists(mountsDirectory) {
 		logWithCaller(fmt.Sprintf("Mounts directory does not exist: %s", mountsDirectory), FatalLog)
 		return fmt.Errorf("mounts directory file does not exist: %s", mountsDirectory)
 	}
 
-	tmpl, err := template.ParseFiles(templateFile)
-
+	config, err := icConf.RenderMountConfig(mount)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Error parsing template file: %s", err), FatalLog)
 		return err
 	}
 
@@ -69,12 +90,10 @@ func (icConf *IcecastConfigStore) SaveMountConfig(mount IcecastMount) error {
 	}
 	defer file.Close()
 
-	// Get the template name (filename without extension)
-	templateName := filepath.Base(templateFile)
-	err = tmpl.ExecuteTemplate(file, templateName, mount)
+	_, err = file.WriteString(config)
 	if err != nil {
-		logWithCaller(fmt.Sprintf("Error executing template: %s", err), FatalLog)
-		return fmt.Errorf("error executing template: %s", err)
+		logWithCaller(fmt.Sprintf("Error writing mount configuration file: %s", err), FatalLog)
+		return fmt.Errorf("error writing mount configuration file: %s", err)
 	}
 	// Set the file permissions to 0644
 	err = os.Chmod(filePath, 0644)
@@ -85,12 +104,39 @@ func (icConf *IcecastConfigStore) SaveMountConfig(mount IcecastMount) error {
 	return nil
 }
 
-func (icConf *IcecastConfigStore) DeleteMountConfig(mount IcecastMount) error {
+// RenderMountConfig returns the Icecast XML of the mount as SaveMountConfig writes it.
+func (icConf *IcecastConfigStore) RenderMountConfig(mount IcecastMount) (string, error) {
+	// Get the mount configuration template
+	templateFile := icConf.getMountConfigTemplate(mount.TemplateType)
+	if !checkFileExists(templateFile) {
+		logWithCaller(fmt.Sprintf("Template file does not exist: %s", templateFile), FatalLog)
+		return "", fmt.Errorf("femplate file does not exist: %s", templateFile)
+	}
+
+	tmpl, err := template.New(filepath.Base(templateFile)).Funcs(mountTemplateFuncs).ParseFiles(templateFile)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error parsing template file: %s", err), FatalLog)
+		return "", err
+	}
+
+	// Get the template name (filename without extension)
+	templateName := filepath.Base(templateFile)
+	var config strings.Builder
+	err = tmpl.ExecuteTemplate(&config, templateName, icConf.getMountTemplateData(mount))
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing template: %s", err), FatalLog)
+		return "", fmt.Errorf("error executing template: %s", err)
+	}
+	return config.String(), nil
+}
+
+func (icConf *IcecastConfigStore) DeleteMountConfig(mount IcecastMount) (err error) {
+	defer countMountFileError("delete", &err)
 
 	mountsDirectory := icConf.config.IcecastMountsFolder
 	// Delete the mount configuration file
 	filePath := mountsDirectory + "/" + icConf.getMountConfigFileName(mount.MountName, mount.TemplateType)
-	err := os.Remove(filePath)
+	err = os.Remove(filePath)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error deleting mount configuration file:  %s", err), FatalLog)
 		return fmt.Errorf("error deleting mount configuration file: %s", err)
@@ -98,6 +144,141 @@ func (icConf *IcecastConfigStore) DeleteMountConfig(mount IcecastMount) error {
 	return nil
 }
 
+func (icConf *IcecastConfigStore) getMountTemplateData(mount IcecastMount) mountTemplateData {
+	data := mountTemplateData{
+		IcecastMount:     mount,
+		ListenerAuthType: icConf.getListenerAuthType(),
+	}
+	if mount.Recording {
+		data.DumpFile = icConf.getIcecastRecordingsFolder() + "/" + getRecordingPattern(mount)
+	}
+	if mount.TemplateType != PrivateTemplate {
+		return data
+	}
+
+	data.ListenerHtpasswdFile = icConf.getListenerHtpasswdFilePath(mount.MountName)
+	authURL := strings.TrimSuffix(icConf.config.ListenerAuthURL, "/") + "/public/listener-auth"
+	if icConf.config.ListenerAuthSecret != "" {
+		authURL += "?key=" + url.QueryEscape(icConf.config.ListenerAuthSecret)
+	}
+	data.ListenerAddURL = authURL
+	data.ListenerRemoveURL = authURL
+	return data
+}
+
+func (icConf *IcecastConfigStore) getIcecastRecordingsFolder() string {
+	if icConf.config.IcecastRecordingsFolder != "" {
+		return strings.TrimSuffix(icConf.config.IcecastRecordingsFolder, "/")
+	}
+	return strings.TrimSuffix(icConf.config.RecordingsFolder, "/")
+}
+
+func (icConf *IcecastConfigStore) getListenerAuthType() string {
+	if icConf.config.ListenerAuthType == listenerAuthURL {
+		return listenerAuthURL
+	}
+	return listenerAuthHtpasswd
+}
+
+func (icConf *IcecastConfigStore) getListenerHtpasswdFilePath(mountName string) string {
+	folder := icConf.config.ListenerHtpasswdFolder
+	if folder == "" {
+		folder = icConf.config.IcecastMountsFolder
+	}
+	return folder + "/" + mountName + ".htpasswd"
+}
+
+// SaveListenerHtpasswd writes the htpasswd file Icecast uses to authenticate
+// listeners of a private mount. Accounts expired at now and access codes are skipped,
+// access codes are only supported by url authentication.
+func (icConf *IcecastConfigStore) SaveListenerHtpasswd(mountName string, accounts []ListenerAccount, now time.Time) (err error) {
+	defer countMountFileError("htpasswd", &err)
+	if icConf.getListenerAuthType() != listenerAuthHtpasswd {
+		return nil
+	}
+	filePath := icConf.getListenerHtpasswdFilePath(mountName)
+
+	var content strings.Builder
+	for _, account := range accounts {
+		if account.Username == "" || account.isExpired(now) {
+			continue
+		}
+		content.WriteString(account.Username + ":" + account.HtpasswdHash + "\n")
+	}
+
+	err = os.WriteFile(filePath, []byte(content.String()), 0644)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error writing htpasswd file: %s", err), FatalLog)
+		return fmt.Errorf("error writing htpasswd file: %s", err)
+	}
+	return nil
+}
+
+// DeleteListenerHtpasswd removes the htpasswd file of a mount if there is one.
+func (icConf *IcecastConfigStore) DeleteListenerHtpasswd(mountName string) error {
+	filePath := icConf.getListenerHtpasswdFilePath(mountName)
+	if !checkFileExists(filePath) {
+		return nil
+	}
+	err := os.Remove(filePath)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error deleting htpasswd file: %s", err), FatalLog)
+		return fmt.Errorf("error deleting htpasswd file: %s", err)
+	}
+	return nil
+}
+
+// redirectMountTemplate is the Icecast config of a former mount name, its
+// listeners fall back to the mount it was renamed to.
+var redirectMountTemplate = template.Must(template.New("redirect").Parse(`<mount>
+    <mount-name>{{.FromName}}</mount-name>
+    <!-- Renamed, listeners are sent to the new mount -->
+    <fallback-mount>{{.MountName}}</fallback-mount>
+    <hidden>1</hidden>
+</mount>
+`))
+
+func (icConf *IcecastConfigStore) getRedirectConfigFilePath(fromName string) string {
+	return icConf.config.IcecastMountsFolder + "/" + fromName + "-redirect.xml"
+}
+
+// SaveRedirectConfig writes the config of a redirect from a former mount name.
+func (icConf *IcecastConfigStore) SaveRedirectConfig(redirect MountRedirect) (err error) {
+	defer countMountFileError("write", &err)
+	var config strings.Builder
+	err = redirectMountTemplate.Execute(&config, redirect)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing redirect template: %s", err), FatalLog)
+		return fmt.Errorf("error executing template: %s", err)
+	}
+	err = os.WriteFile(icConf.getRedirectConfigFilePath(redirect.FromName), []byte(config.String()), 0644)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error writing redirect configuration file: %s", err), FatalLog)
+		return fmt.Errorf("error writing redirect configuration file: %s", err)
+	}
+	return nil
+}
+
+// DeleteRedirectConfig removes the config of a redirect if there is one.
+func (icConf *IcecastConfigStore) DeleteRedirectConfig(fromName string) (err error) {
+	defer countMountFileError("delete", &err)
+	filePath := icConf.getRedirectConfigFilePath(fromName)
+	if !checkFileExists(filePath) {
+		return nil
+	}
+	err = os.Remove(filePath)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error deleting redirect configuration file: %s", err), FatalLog)
+		return fmt.Errorf("error deleting redirect configuration file: %s", err)
+	}
+	return nil
+}
+
+// MountConfigExists reports whether the config file of the mount is written.
+func (icConf *IcecastConfigStore) MountConfigExists(mount IcecastMount) bool {
+	return checkFileExists(icConf.config.IcecastMountsFolder + "/" + icConf.getMountConfigFileName(mount.MountName, mount.TemplateType))
+}
+
 func (icConf *IcecastConfigStore) getMountConfigFileName(mountName string, templateType TemplateType) string {
 	// Get the mount configuration file name
 	return mountName + "-" + string(templateType) + ".xml"
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// icecastMountXML is a <mount> section of an Icecast config, written by hand
+// or by one of the mount templates.
+type icecastMountXML struct {
+	MountName         string `xml:"mount-name"`
+	Username          string `xml:"username"`
+	Password          string `xml:"password"`
+	Public            string `xml:"public"`
+	StreamName        string `xml:"stream-name"`
+	StreamDescription string `xml:"stream-description"`
+	DumpFile          string `xml:"dump-file"`
+	Authentication    struct {
+		Type    string `xml:"type,attr"`
+		Options []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+			Text  string `xml:",chardata"`
+		} `xml:"option"`
+	} `xml:"authentication"`
+}
+
+// option returns an option of the authentication, Icecast reads it from the
+// value attribute, the default template writes it as text.
+func (m icecastMountXML) option(name string) (string, bool) {
+	for _, option := range m.Authentication.Options {
+		if option.Name == name {
+			if option.Value != "" {
+				return option.Value, true
+			}
+			return strings.TrimSpace(option.Text), true
+		}
+	}
+	return "", false
+}
+
+// mount converts the section. Mounts authenticating listeners by a htpasswd
+// file or URL become private mounts.
+func (m icecastMountXML) mount() IcecastMount {
+	mount := IcecastMount{
+		MountName:         strings.TrimPrefix(strings.TrimSpace(m.MountName), "/"),
+		Username:          strings.TrimSpace(m.Username),
+		Password:          strings.TrimSpace(m.Password),
+		StreamName:        strings.TrimSpace(m.StreamName),
+		StreamDescription: strings.TrimSpace(m.StreamDescription),
+		TemplateType:      DefaultTemplate,
+	}
+	if mount.Username == "" {
+		mount.Username, _ = m.option("username")
+	}
+	if mount.Password == "" {
+		mount.Password, _ = m.option("password")
+	}
+
+	switch strings.ToLower(strings.TrimSpace(m.Public)) {
+	case "1", "true", "yes":
+		mount.Public = 1
+	}
+
+	_, htpasswd := m.option("filename")
+	_, listenerURL := m.option("listener_add")
+	if htpasswd || listenerURL {
+		mount.TemplateType = PrivateTemplate
+		mount.Public = 0
+	}
+
+	if dumpFile := strings.TrimSpace(m.DumpFile); dumpFile != "" {
+		mount.Recording = true
+		if pattern := path.Base(dumpFile); pattern != getRecordingPattern(mount) {
+			mount.RecordingPattern = pattern
+		}
+	}
+	return mount
+}
+
+// parseIcecastMounts reads every <mount> section of an Icecast config file, a
+// single mount file or several mount files appended to each other.
+func parseIcecastMounts(data []byte) ([]IcecastMount, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var mounts []IcecastMount
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "mount" {
+			continue
+		}
+		var section icecastMountXML
+		err = decoder.DecodeElement(&section, &start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid XML: %w", err)
+		}
+		mounts = append(mounts, section.mount())
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no <mount> section found")
+	}
+	return mounts, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseIcecastMounts(t *testing.T) {
+	initTest(t)
+
+	config := `<icecast>
+    <limits><sources>10</sources></limits>
+    <mount type="normal">
+        <mount-name>/dom.mp3</mount-name>
+        <username>source</username>
+        <password>hackme</password>
+        <public>1</public>
+        <stream-name>Chor &amp; Orchester</stream-name>
+        <stream-description>Vesper im Dom</stream-description>
+        <dump-file>/var/recordings/dom-%Y%m%d.mp3</dump-file>
+    </mount>
+    <mount>
+        <mount-name>/st-anna</mount-name>
+        <!-- Require authentication for streaming -->
+        <authentication type="htpasswd">
+            <option name="username">anna</option>
+            <option name="password">secret</option>
+        </authentication>
+        <public>0</public>
+    </mount>
+    <mount>
+        <mount-name>/st-peter</mount-name>
+        <username>peter</username>
+        <password>secret</password>
+        <public>1</public>
+        <authentication type="htpasswd">
+            <option name="filename" value="/etc/icecast/st-peter.htpasswd"/>
+        </authentication>
+    </mount>
+</icecast>`
+
+	mounts, err := parseIcecastMounts([]byte(config))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	expected := []IcecastMount{
+		{MountName: "dom.mp3", Username: "source", Password: "hackme", Public: 1, StreamName: "Chor & Orchester", StreamDescription: "Vesper im Dom",
+			TemplateType: DefaultTemplate, Recording: true, RecordingPattern: "dom-%Y%m%d.mp3"},
+		{MountName: "st-anna", Username: "anna", Password: "secret", TemplateType: DefaultTemplate},
+		{MountName: "st-peter", Username: "peter", Password: "secret", TemplateType: PrivateTemplate},
+	}
+	if len(mounts) != len(expected) {
+		t.Fatalf("Expected %d mounts, got %+v", len(expected), mounts)
+	}
+	for i := range expected {
+		if mounts[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], mounts[i])
+		}
+		if err := mounts[i].Validate(); err != nil {
+			t.Errorf("Expected %s to be valid, got %v", mounts[i].MountName, err)
+		}
+	}
+
+	for _, invalid := range []string{"<icecast></icecast>", "<mount><mount-name>x</mount>"} {
+		if _, err := parseIcecastMounts([]byte(invalid)); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+// TestRenderMountConfigEscapesText renders mounts with XML characters and
+// expects the importer to read the same values back.
+func TestRenderMountConfigEscapesText(t *testing.T) {
+	initTest(t)
+
+	icecast := NewIcecastConfig(Config{
+		DefaultMountTemplate: "../scripts/templates/default_mount.tmpl",
+		PrivateMountTemplate: "../scripts/templates/private_mount.tmpl",
+	})
+	for _, templateType := range []TemplateType{DefaultTemplate, PrivateTemplate} {
+		mount := IcecastMount{MountName: "dom", Username: "source", Password: `se<cr>et&"'`, StreamName: "Chor & Orchester",
+			StreamDescription: "<Vesper> im Dom", TemplateType: templateType}
+		if err := mount.Validate(); err != nil {
+			t.Fatalf("Expected a valid mount, got %v", err)
+		}
+		config, err := icecast.RenderMountConfig(mount)
+		if err != nil {
+			t.Fatalf("Failed to render %s config: %v", templateType, err)
+		}
+		mounts, err := parseIcecastMounts([]byte(config))
+		if err != nil || len(mounts) != 1 {
+			t.Fatalf("Failed to parse rendered %s config: %v\n%s", templateType, err, config)
+		}
+		if mounts[0].Password != mount.Password || mounts[0].StreamName != mount.StreamName || mounts[0].StreamDescription != mount.StreamDescription {
+			t.Errorf("Expected the %s config to keep the text, got %+v", templateType, mounts[0])
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultIcecastPollInterval = 15 * time.Second
+
+// MountStatus is the live state of a mount as reported by Icecast.
+type MountStatus struct {
+	Live        bool      `json:"live"`
+	Listeners   int       `json:"listeners"`
+	Title       string    `json:"title,omitempty"`
+	StreamStart time.Time `json:"stream_start,omitempty"`
+}
+
+// icecastSource is one entry of the source list in status-json.xsl.
+type icecastSource struct {
+	ListenURL          string `json:"listenurl"`
+	Listeners          int    `json:"listeners"`
+	Title              string `json:"title"`
+	StreamStartISO8601 string `json:"stream_start_iso8601"`
+}
+
+// icecastSources accepts the source list as array or as single object,
+// Icecast uses the latter if only one source is connected.
+type icecastSources []icecastSource
+
+func (sources *icecastSources) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		var list []icecastSource
+		err := json.Unmarshal(data, &list)
+		*sources = list
+		return err
+	}
+	var single icecastSource
+	err := json.Unmarshal(data, &single)
+	*sources = icecastSources{single}
+	return err
+}
+
+type icecastStats struct {
+	Icestats struct {
+		Source icecastSources `json:"source"`
+	} `json:"icestats"`
+}
+
+// IcecastStatusPoller polls the Icecast status page and keeps the live state of
+// all mounts. Title changes are recorded in the metadata history.
+type IcecastStatusPoller struct {
+	statusURL string
+	storage   Store
+	events    *EventBroker
+	client    *http.Client
+
+	mu          sync.RWMutex
+	status      map[string]MountStatus
+	initialized bool
+}
+
+func NewIcecastStatusPoller(config Config, storage Store, events *EventBroker) *IcecastStatusPoller {
+	return &IcecastStatusPoller{
+		statusURL: config.IcecastStatusURL,
+		storage:   storage,
+		events:    events,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		status:    make(map[string]MountStatus),
+	}
+}
+
+// Run polls Icecast every interval. It never returns.
+func (p *IcecastStatusPoller) Run(interval time.Duration) {
+	if p.statusURL == "" {
+		logWithCaller("No Icecast status URL configured, status polling disabled", InfoLog)
+		return
+	}
+	logWithCaller(fmt.Sprintf("Polling Icecast status every %s", interval), InfoLog)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := p.poll(time.Now())
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error polling Icecast status: %s", err), WarnLog)
+		}
+		<-ticker.C
+	}
+}
+
+func (p *IcecastStatusPoller) poll(now time.Time) error {
+	response, err := p.client.Get(p.statusURL)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	var stats icecastStats
+	err = json.NewDecoder(response.Body).Decode(&stats)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]MountStatus)
+	for _, source := range stats.Icestats.Source {
+		listenURL, err := url.Parse(source.ListenURL)
+		if err != nil {
+			continue
+		}
+		status := MountStatus{
+			Live:      true,
+			Listeners: source.Listeners,
+			Title:     source.Title,
+		}
+		status.StreamStart, _ = time.Parse("2006-01-02T15:04:05-0700", source.StreamStartISO8601)
+		current[strings.TrimPrefix(listenURL.Path, "/")] = status
+	}
+
+	p.update(current, now)
+	return nil
+}
+
+// update replaces the known state, records title changes and publishes
+// source and listener events. The first poll only sets the state.
+func (p *IcecastStatusPoller) update(current map[string]MountStatus, now time.Time) {
+	p.mu.Lock()
+	previous := p.status
+	initialized := p.initialized
+	p.status = current
+	p.initialized = true
+	p.mu.Unlock()
+
+	for mountName, status := range current {
+		before, wasLive := previous[mountName]
+		if status.Title != "" && status.Title != before.Title {
+			err := p.storage.AddMetadataEntry(MetadataEntry{MountName: mountName, Title: status.Title, ObservedAt: now.UTC()})
+			if err != nil {
+				logWithCaller(fmt.Sprintf("Error saving metadata of mount %s: %s", mountName, err), WarnLog)
+			}
+		}
+		if !initialized {
+			continue
+		}
+		if !wasLive {
+			p.events.Publish(EventSourceConnected, mountName, status)
+		} else if status.Listeners != before.Listeners {
+			p.events.Publish(EventListenersChanged, mountName, map[string]int{"listeners": status.Listeners, "previous": before.Listeners})
+		}
+	}
+
+	if !initialized {
+		return
+	}
+	for mountName := range previous {
+		if _, live := current[mountName]; !live {
+			p.events.Publish(EventSourceDisconnected, mountName, nil)
+		}
+	}
+}
+
+// Ready reports whether Icecast was polled at least once.
+func (p *IcecastStatusPoller) Ready() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.initialized
+}
+
+// Status returns the live state of a mount. Mounts without source are offline.
+func (p *IcecastStatusPoller) Status(mountName string) MountStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.status[mountName]
+}
+
+// MetadataEntry is a stream title that was seen at a point in time.
+type MetadataEntry struct {
+	MountName  string    `json:"mount_name"`
+	Title      string    `json:"title"`
+	ObservedAt time.Time `json:"observed_at"`
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+
+	idempotencyPurgeInterval = time.Hour
+	defaultIdempotencyTTL    = 24
+	// idempotencyAbandonTimeout is how long a request may stay in progress.
+	// Older keys without response belong to a crashed request and are reused.
+	idempotencyAbandonTimeout = 2 * time.Minute
+)
+
+// IdempotencyKey is a key a client sent with a request and the response it
+// got. Status 0 means the request is still in progress.
+type IdempotencyKey struct {
+	Username    string
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	ETag        string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// responseCapture keeps a copy of the response written by a handler.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (capture *responseCapture) WriteHeader(status int) {
+	if capture.status == 0 {
+		capture.status = status
+	}
+	capture.ResponseWriter.WriteHeader(status)
+}
+
+func (capture *responseCapture) Write(data []byte) (int, error) {
+	if capture.status == 0 {
+		capture.status = http.StatusOK
+	}
+	capture.body.Write(data)
+	return capture.ResponseWriter.Write(data)
+}
+
+func (s *ApiServer) idempotencyTTL() time.Duration {
+	hours := s.config.IdempotencyKeyTTLHours
+	if hours <= 0 {
+		hours = defaultIdempotencyTTL
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// RunIdempotencyPurge deletes expired idempotency keys every interval. It
+// never returns.
+func (s *ApiServer) RunIdempotencyPurge(interval time.Duration) {
+	logWithCaller(fmt.Sprintf("Starting idempotency key purge with interval %s and TTL %s", interval, s.idempotencyTTL()), InfoLog)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := s.storage.DeleteIdempotencyKeys(time.Now().Add(-s.idempotencyTTL()))
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error purging idempotency keys: %s", err), WarnLog)
+		}
+		<-ticker.C
+	}
+}
+
+// idempotent makes a handler safe to retry. A request with an Idempotency-Key
+// header runs once per user and key; repeating it returns the stored
+// response. The key cannot be reused for a different request. Server errors
+// are not stored, so the request can be retried, and neither are requests
+// that panicked or stayed in progress longer than idempotencyAbandonTimeout.
+func (s *ApiServer) idempotent(f apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			return f(w, r)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return fmt.Errorf("%s header must not be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportBodySize)
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return jsonError(err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		entry := IdempotencyKey{
+			Username:    getAuthInfo(r).Username,
+			Key:         key,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+			CreatedAt:   time.Now(),
+		}
+
+		stored, err := s.storage.GetIdempotencyKey(entry.Username, key)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			logRequest(r, fmt.Sprintf("Database error fetching idempotency key: %s", err), WarnLog)
+			return databaseError(err, "idempotency key")
+		case stored.CreatedAt.Before(time.Now().Add(-s.idempotencyTTL())),
+			stored.Status == 0 && stored.CreatedAt.Before(time.Now().Add(-idempotencyAbandonTimeout)):
+			logRequest(r, "Reusing expired or abandoned idempotency key", InfoLog)
+			err = s.storage.DeleteIdempotencyKey(entry.Username, key)
+			if err != nil {
+				return databaseError(err, "idempotency key")
+			}
+		default:
+			return s.replay(w, r, stored, entry)
+		}
+
+		err = s.storage.CreateIdempotencyKey(entry)
+		if isUniqueViolation(err) {
+			return conflictError("a request with this %s is still in progress", idempotencyKeyHeader)
+		}
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Database error storing idempotency key: %s", err), WarnLog)
+			return databaseError(err, "idempotency key")
+		}
+
+		// A panicking handler must not leave the key in progress
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				s.storage.DeleteIdempotencyKey(entry.Username, key)
+				panic(recovered)
+			}
+		}()
+
+		capture := &responseCapture{ResponseWriter: w}
+		handlerErr := f(capture, r)
+		if handlerErr != nil {
+			status, apiErr := apiErrorResponse(handlerErr)
+			body, _ := json.Marshal(apiErr)
+			entry.Status, entry.ContentType, entry.Body = status, "application/json", append(body, '\n')
+		} else {
+			entry.Status, entry.Body = capture.status, capture.body.Bytes()
+			entry.ContentType, entry.ETag = w.Header().Get("Content-Type"), w.Header().Get("ETag")
+		}
+
+		if entry.Status >= http.StatusInternalServerError {
+			err = s.storage.DeleteIdempotencyKey(entry.Username, key)
+		} else {
+			err = s.storage.CompleteIdempotencyKey(entry)
+		}
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Database error storing idempotent response: %s", err), WarnLog)
+		}
+		return handlerErr
+	}
+}
+
+// replay writes the stored response of a repeated request.
+func (s *ApiServer) replay(w http.ResponseWriter, r *http.Request, stored, entry IdempotencyKey) error {
+	if stored.RequestHash != entry.RequestHash {
+		return &StatusError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    ErrorCodeIdempotencyKeyReused,
+			Message: fmt.Sprintf("%s was already used for a different request", idempotencyKeyHeader),
+		}
+	}
+	if stored.Status == 0 {
+		return conflictError("a request with this %s is still in progress", idempotencyKeyHeader)
+	}
+	logRequest(r, fmt.Sprintf("Replaying response %d of idempotency key", stored.Status), InfoLog)
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	if stored.ETag != "" {
+		w.Header().Set("ETag", stored.ETag)
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	_, err := w.Write(stored.Body)
+	return err
+}
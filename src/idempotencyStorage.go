@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const idempotencyKeyColumns = `username, idempotency_key, request_hash, status, content_type, etag, body, created_at`
+
+func scanIdempotencyKey(scanner interface{ Scan(...any) error }) (IdempotencyKey, error) {
+	var entry IdempotencyKey
+	err := scanner.Scan(&entry.Username, &entry.Key, &entry.RequestHash, &entry.Status, &entry.ContentType, &entry.ETag,
+		&entry.Body, &entry.CreatedAt)
+	return entry, err
+}
+
+// CreateIdempotencyKey reserves the key for a request in progress. It fails
+// with a unique violation if the user used the key already.
+func (s *SqliteStorage) CreateIdempotencyKey(entry IdempotencyKey) error {
+	defer observeQuery("CreateIdempotencyKey", time.Now())
+	logWithCaller(fmt.Sprintf("Reserving idempotency key of user: %s", entry.Username), DebugLog)
+	_, err := s.db.Exec(`
+	INSERT INTO idempotency_keys (username, idempotency_key, request_hash, created_at)
+	VALUES ($1, $2, $3, $4)
+	`, entry.Username, entry.Key, entry.RequestHash, entry.CreatedAt.UTC())
+	if err != nil && !isUniqueViolation(err) {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+	}
+	return err
+}
+
+func (s *SqliteStorage) GetIdempotencyKey(username, key string) (IdempotencyKey, error) {
+	defer observeQuery("GetIdempotencyKey", time.Now())
+	logWithCaller(fmt.Sprintf("Getting idempotency key of user: %s", username), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + idempotencyKeyColumns + `
+	FROM idempotency_keys
+	WHERE username = $1 AND idempotency_key = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return IdempotencyKey{}, err
+	}
+	defer stmt.Close()
+
+	return scanIdempotencyKey(stmt.QueryRow(username, key))
+}
+
+// CompleteIdempotencyKey stores the response of the request.
+func (s *SqliteStorage) CompleteIdempotencyKey(entry IdempotencyKey) error {
+	defer observeQuery("CompleteIdempotencyKey", time.Now())
+	logWithCaller(fmt.Sprintf("Storing response %d of idempotency key of user: %s", entry.Status, entry.Username), DebugLog)
+	result, err := s.db.Exec(`
+	UPDATE idempotency_keys
+	SET status = $1,
+	content_type = $2,
+	etag = $3,
+	body = $4
+	WHERE username = $5 AND idempotency_key = $6
+	`, entry.Status, entry.ContentType, entry.ETag, entry.Body, entry.Username, entry.Key)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for idempotency key of user: %s", affectedRows, entry.Username), FatalLog)
+		return affectedRowsError(affectedRows, "idempotency key", entry.Key)
+	}
+	return nil
+}
+
+func (s *SqliteStorage) DeleteIdempotencyKey(username, key string) error {
+	defer observeQuery("DeleteIdempotencyKey", time.Now())
+	logWithCaller(fmt.Sprintf("Deleting idempotency key of user: %s", username), DebugLog)
+	_, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE username = $1 AND idempotency_key = $2`, username, key)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+	}
+	return err
+}
+
+// DeleteIdempotencyKeys removes the keys created before the given time.
+func (s *SqliteStorage) DeleteIdempotencyKeys(before time.Time) (int64, error) {
+	defer observeQuery("DeleteIdempotencyKeys", time.Now())
+	result, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE created_at < $1`, before.UTC())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return 0, err
+	}
+	logWithCaller(fmt.Sprintf("Deleted %d expired idempotency keys", deleted), DebugLog)
+	return deleted, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotent(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+
+	calls := 0
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) error {
+		calls++
+		if calls > 1 {
+			return conflictError("stream already exists")
+		}
+		w.Header().Set("ETag", `"1"`)
+		return WriteJson(w, http.StatusCreated, map[string]string{"mount_name": "ostern"})
+	})
+	request := func(key, body string) (*httptest.ResponseRecorder, error) {
+		r := httptest.NewRequest("POST", "/api/streams", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey, authInfo{Username: "admin"}))
+		r.Header.Set(idempotencyKeyHeader, key)
+		w := httptest.NewRecorder()
+		return w, handler(w, r)
+	}
+
+	w, err := request("key-1", `{"mount_name":"ostern"}`)
+	if err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Expected the first request to create the stream, got %d %v", w.Code, err)
+	}
+
+	w, err = request("key-1", `{"mount_name":"ostern"}`)
+	if err != nil || w.Code != http.StatusCreated || w.Header().Get(idempotencyReplayedHeader) != "true" || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("Expected the response to be replayed, got %d %v %v", w.Code, w.Header(), err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected the handler to run once, ran %d times", calls)
+	}
+
+	_, err = request("key-1", `{"mount_name":"pfingsten"}`)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != ErrorCodeIdempotencyKeyReused {
+		t.Fatalf("Expected reusing the key for another body to fail, got %v", err)
+	}
+
+	// Client errors are replayed as well
+	for i := 0; i < 2; i++ {
+		w, err = request("key-2", `{"mount_name":"ostern"}`)
+	}
+	if err != nil || w.Code != http.StatusConflict || calls != 2 {
+		t.Fatalf("Expected the conflict to be replayed, got %d %v after %d calls", w.Code, err, calls)
+	}
+}
+
+func TestIdempotentAbandonedKey(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+
+	panics := true
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) error {
+		if panics {
+			panic("handler failed")
+		}
+		return WriteJson(w, http.StatusCreated, map[string]string{"mount_name": "ostern"})
+	})
+	request := func(key string) (w *httptest.ResponseRecorder, err error, panicked bool) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		r := httptest.NewRequest("POST", "/api/streams", strings.NewReader(`{"mount_name":"ostern"}`))
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey, authInfo{Username: "admin"}))
+		r.Header.Set(idempotencyKeyHeader, key)
+		w = httptest.NewRecorder()
+		return w, handler(w, r), false
+	}
+
+	if _, _, panicked := request("key-1"); !panicked {
+		t.Fatalf("Expected the handler to panic")
+	}
+	panics = false
+	if w, err, _ := request("key-1"); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Expected the key of a panicked request to be reusable, got %d %v", w.Code, err)
+	}
+
+	// Keys left in progress, e.g. by a crash
+	hash := sha256.New()
+	fmt.Fprintf(hash, "POST /api/streams\n")
+	hash.Write([]byte(`{"mount_name":"ostern"}`))
+	requestHash := hex.EncodeToString(hash.Sum(nil))
+	for _, entry := range []IdempotencyKey{
+		{Username: "admin", Key: "key-2", RequestHash: requestHash, CreatedAt: time.Now().Add(-idempotencyAbandonTimeout - time.Minute)},
+		{Username: "admin", Key: "key-3", RequestHash: requestHash, CreatedAt: time.Now()},
+	} {
+		if err := storage.CreateIdempotencyKey(entry); err != nil {
+			t.Fatalf("Failed to create idempotency key: %v", err)
+		}
+	}
+	if w, err, _ := request("key-2"); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("Expected an abandoned key to run the request again, got %d %v", w.Code, err)
+	}
+	_, err, _ = request("key-3")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusConflict {
+		t.Fatalf("Expected a key in progress to conflict, got %v", err)
+	}
+}
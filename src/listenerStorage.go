@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const listenerAccountColumns = `id, mount_name, username, password_hash, htpasswd_hash, access_code_hash, expires_at, max_sessions, created_at`
+
+func scanListenerAccount(scanner interface{ Scan(...any) error }) (ListenerAccount, error) {
+	var account ListenerAccount
+	var expiresAt sql.NullTime
+	err := scanner.Scan(&account.ID, &account.MountName, &account.Username, &account.PasswordHash, &account.HtpasswdHash,
+		&account.AccessCodeHash, &expiresAt, &account.MaxSessions, &account.CreatedAt)
+	if err != nil {
+		return ListenerAccount{}, err
+	}
+	if expiresAt.Valid {
+		account.ExpiresAt = &expiresAt.Time
+	}
+	return account, nil
+}
+
+func nullableTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func (s *SqliteStorage) CreateListenerAccount(account ListenerAccount) (ListenerAccount, error) {
+	defer observeQuery("CreateListenerAccount", time.Now())
+	logWithCaller(fmt.Sprintf("Creating listener account for mount: %s", account.MountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	INSERT INTO listener_accounts (mount_name, username, password_hash, htpasswd_hash, access_code_hash, expires_at, max_sessions)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return ListenerAccount{}, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(account.MountName, account.Username, account.PasswordHash, account.HtpasswdHash,
+		account.AccessCodeHash, nullableTime(account.ExpiresAt), account.MaxSessions)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return ListenerAccount{}, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting last insert ID: %v", err), FatalLog)
+		return ListenerAccount{}, err
+	}
+	logWithCaller(fmt.Sprintf("Inserted listener account with ID: %d", lastID), InfoLog)
+
+	return s.GetListenerAccount(account.MountName, lastID)
+}
+
+func (s *SqliteStorage) GetListenerAccount(mountName string, id int64) (ListenerAccount, error) {
+	defer observeQuery("GetListenerAccount", time.Now())
+	logWithCaller(fmt.Sprintf("Getting listener account %d for mount: %s", id, mountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + listenerAccountColumns + `
+	FROM listener_accounts
+	WHERE mount_name = $1 AND id = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return ListenerAccount{}, err
+	}
+	defer stmt.Close()
+
+	account, err := scanListenerAccount(stmt.QueryRow(mountName, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logWithCaller(fmt.Sprintf("No listener account %d for mount: %s", id, mountName), DebugLog)
+		}
+		return ListenerAccount{}, err
+	}
+	return account, nil
+}
+
+func (s *SqliteStorage) GetListenerAccounts(mountName string) ([]ListenerAccount, error) {
+	defer observeQuery("GetListenerAccounts", time.Now())
+	logWithCaller(fmt.Sprintf("Getting listener accounts for mount: %s", mountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + listenerAccountColumns + `
+	FROM listener_accounts
+	WHERE mount_name = $1
+	ORDER BY id
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []ListenerAccount{}
+	for rows.Next() {
+		account, err := scanListenerAccount(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (s *SqliteStorage) UpdateListenerAccount(account ListenerAccount) error {
+	defer observeQuery("UpdateListenerAccount", time.Now())
+	logWithCaller(fmt.Sprintf("Updating listener account %d for mount: %s", account.ID, account.MountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	UPDATE listener_accounts
+	SET username = $1,
+	password_hash = $2,
+	htpasswd_hash = $3,
+	access_code_hash = $4,
+	expires_at = $5,
+	max_sessions = $6
+	WHERE mount_name = $7 AND id = $8
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(account.Username, account.PasswordHash, account.HtpasswdHash, account.AccessCodeHash,
+		nullableTime(account.ExpiresAt), account.MaxSessions, account.MountName, account.ID)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for listener account: %d", affectedRows, account.ID), FatalLog)
+		return affectedRowsError(affectedRows, "listener account", account.ID)
+	}
+	return nil
+}
+
+func (s *SqliteStorage) DeleteListenerAccount(mountName string, id int64) error {
+	defer observeQuery("DeleteListenerAccount", time.Now())
+	logWithCaller(fmt.Sprintf("Deleting listener account %d for mount: %s", id, mountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	DELETE FROM listener_accounts
+	WHERE mount_name = $1 AND id = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(mountName, id)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for listener account: %d", affectedRows, id), FatalLog)
+		return affectedRowsError(affectedRows, "listener account", id)
+	}
+	return nil
+}
+
+// GetExpiredListenerMounts returns the mounts with listener accounts that
+// expired after from and until to.
+func (s *SqliteStorage) GetExpiredListenerMounts(from, to time.Time) ([]string, error) {
+	defer observeQuery("GetExpiredListenerMounts", time.Now())
+	stmt, err := s.db.Prepare(`
+	SELECT DISTINCT mount_name
+	FROM listener_accounts
+	WHERE expires_at > $1 AND expires_at <= $2
+	ORDER BY mount_name
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(from.UTC(), to.UTC())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	mountNames := []string{}
+	for rows.Next() {
+		var mountName string
+		err = rows.Scan(&mountName)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		mountNames = append(mountNames, mountName)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return mountNames, nil
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	listenerAuthHtpasswd = "htpasswd"
+	listenerAuthURL      = "url"
+
+	accessCodeLength = 8
+	// minAccessCodeLength keeps access codes from being guessed through the
+	// listener authentication.
+	minAccessCodeLength = 8
+
+	maxListenerAuthFailures   = 10
+	listenerAuthFailureWindow = 15 * time.Minute
+)
+
+// ListenerAccount grants a listener access to a private mount, either with
+// username and password or with a numeric access code.
+type ListenerAccount struct {
+	ID          int64      `json:"id"`
+	MountName   string     `json:"mount_name"`
+	Username    string     `json:"username,omitempty"`
+	Password    string     `json:"password,omitempty"`
+	AccessCode  string     `json:"access_code,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxSessions int        `json:"max_sessions"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	PasswordHash   string `json:"-"`
+	HtpasswdHash   string `json:"-"`
+	AccessCodeHash string `json:"-"`
+}
+
+func (account ListenerAccount) isExpired(now time.Time) bool {
+	return account.ExpiresAt != nil && account.ExpiresAt.Before(now)
+}
+
+// setPassword stores the password as bcrypt hash for url authentication and as
+// the MD5 hex digest Icecast expects in htpasswd files.
+func (account *ListenerAccount) setPassword(password string) error {
+	passwordHash, err := getHashedPassword(password)
+	if err != nil {
+		return err
+	}
+	htpasswdHash := md5.Sum([]byte(password))
+	account.PasswordHash = passwordHash
+	account.HtpasswdHash = hex.EncodeToString(htpasswdHash[:])
+	return nil
+}
+
+func (account *ListenerAccount) setAccessCode(code string) {
+	account.AccessCodeHash = getHash(code)
+}
+
+// clearSecrets removes everything that must not be returned by the API.
+func (account ListenerAccount) clearSecrets() ListenerAccount {
+	account.Password = ""
+	account.AccessCode = ""
+	return account
+}
+
+func generateAccessCode() (string, error) {
+	code := ""
+	for range accessCodeLength {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			logWithCaller("Failed to generate access code: "+err.Error(), FatalLog)
+			return "", err
+		}
+		code += digit.String()
+	}
+	return code, nil
+}
+
+func validAccessCode(code string) bool {
+	if len(code) < minAccessCodeLength {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ListenerSessions tracks the Icecast clients that were admitted through url
+// authentication, so max_sessions can be enforced per account.
+type ListenerSessions struct {
+	mu       sync.Mutex
+	sessions map[string]int64
+}
+
+func NewListenerSessions() *ListenerSessions {
+	return &ListenerSessions{
+		sessions: make(map[string]int64),
+	}
+}
+
+func (ls *ListenerSessions) sessionKey(mountName, client string) string {
+	return mountName + "|" + client
+}
+
+// tryAdd registers the client for the account unless the account already has maxSessions clients.
+func (ls *ListenerSessions) tryAdd(mountName, client string, accountID int64, maxSessions int) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if maxSessions > 0 {
+		count := 0
+		for _, id := range ls.sessions {
+			if id == accountID {
+				count++
+			}
+		}
+		if count >= maxSessions {
+			return false
+		}
+	}
+	ls.sessions[ls.sessionKey(mountName, client)] = accountID
+	return true
+}
+
+func (ls *ListenerSessions) remove(mountName, client string) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	delete(ls.sessions, ls.sessionKey(mountName, client))
+}
+
+// ListenerAuthLimiter counts failed listener logins per mount and listener
+// address. After maxListenerAuthFailures within listenerAuthFailureWindow
+// further attempts are rejected until the window is over.
+type ListenerAuthLimiter struct {
+	mu       sync.Mutex
+	failures map[string]listenerAuthFailures
+}
+
+type listenerAuthFailures struct {
+	count int
+	since time.Time
+}
+
+func NewListenerAuthLimiter() *ListenerAuthLimiter {
+	return &ListenerAuthLimiter{
+		failures: make(map[string]listenerAuthFailures),
+	}
+}
+
+func (l *ListenerAuthLimiter) blocked(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failures, ok := l.failures[key]
+	if !ok {
+		return false
+	}
+	if now.Sub(failures.since) >= listenerAuthFailureWindow {
+		delete(l.failures, key)
+		return false
+	}
+	return failures.count >= maxListenerAuthFailures
+}
+
+func (l *ListenerAuthLimiter) fail(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failures := l.failures[key]
+	if failures.count == 0 || now.Sub(failures.since) >= listenerAuthFailureWindow {
+		failures = listenerAuthFailures{since: now}
+	}
+	failures.count++
+	l.failures[key] = failures
+
+	// Forget old windows, so the map does not grow with every address
+	for otherKey, other := range l.failures {
+		if now.Sub(other.since) >= listenerAuthFailureWindow {
+			delete(l.failures, otherKey)
+		}
+	}
+}
+
+func (l *ListenerAuthLimiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// ###########
+// Listener account routes
+// ###########
+
+func (s *ApiServer) addListenerRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/listeners", makeHTTPHandleFunc(s.handleGetListenerAccounts))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/listeners", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"streams/{streamName}/listeners", makeHTTPHandleFunc(s.handleCreateListenerAccount))
+	addToRouteRightsMap("POST "+autherized+"streams/{streamName}/listeners", "post_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/listeners/{listenerID}", makeHTTPHandleFunc(s.handleGetListenerAccount))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/listeners/{listenerID}", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"streams/{streamName}/listeners/{listenerID}", makeHTTPHandleFunc(s.handleUpdateListenerAccount))
+	addToRouteRightsMap("POST "+autherized+"streams/{streamName}/listeners/{listenerID}", "post_stream")
+
+	autherizedRouter.HandleFunc("DELETE "+autherized+"streams/{streamName}/listeners/{listenerID}", makeHTTPHandleFunc(s.handleDeleteListenerAccount))
+	addToRouteRightsMap("DELETE "+autherized+"streams/{streamName}/listeners/{listenerID}", "delete_stream")
+}
+
+// getPrivateMount loads the mount of the request and makes sure it uses the private template.
+func (s *ApiServer) getPrivateMount(r *http.Request) (IcecastMount, error) {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return IcecastMount{}, err
+	}
+	if mount.TemplateType != PrivateTemplate {
+		return IcecastMount{}, fmt.Errorf("listener accounts require the %s template", PrivateTemplate)
+	}
+	return mount, nil
+}
+
+func getListenerID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("listenerID"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid listener id")
+	}
+	return id, nil
+}
+
+// refreshListenerAuth rewrites the htpasswd file of the mount after its accounts changed.
+func (s *ApiServer) refreshListenerAuth(mountName string) error {
+	accounts, err := s.storage.GetListenerAccounts(mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Database error fetching listener accounts: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "listener account")
+	}
+	err = s.icecast.SaveListenerHtpasswd(mountName, accounts, time.Now())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Config error writing listener accounts: %s %s", mountName, err), WarnLog)
+		return internalError("file error")
+	}
+	return nil
+}
+
+func (s *ApiServer) handleGetListenerAccounts(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPrivateMount(r)
+	if err != nil {
+		return err
+	}
+
+	accounts, err := s.storage.GetListenerAccounts(mount.MountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching listener accounts: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "listener account")
+	}
+	for i := range accounts {
+		accounts[i] = accounts[i].clearSecrets()
+	}
+
+	return WriteJson(w, http.StatusOK, accounts)
+}
+
+func (s *ApiServer) handleGetListenerAccount(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPrivateMount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getListenerID(r)
+	if err != nil {
+		return err
+	}
+
+	account, err := s.storage.GetListenerAccount(mount.MountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching listener account: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "listener account")
+	}
+
+	return WriteJson(w, http.StatusOK, account.clearSecrets())
+}
+
+// handleCreateListenerAccount creates a username/password account or an access code.
+// If neither username nor access code are given, a random access code is generated.
+// Password and access code are only returned in this response.
+func (s *ApiServer) handleCreateListenerAccount(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPrivateMount(r)
+	if err != nil {
+		return err
+	}
+
+	var account ListenerAccount
+	err = decodeJSON(w, r, &account)
+	if err != nil {
+		return err
+	}
+
+	account.MountName = mount.MountName
+	account.Username = strings.TrimSpace(account.Username)
+	if account.MaxSessions < 0 {
+		return validationError(fieldError("max_sessions", "must not be negative"))
+	}
+
+	switch {
+	case account.Username != "":
+		if strings.ContainsAny(account.Username, ":\n") {
+			return validationError(fieldError("username", "must not contain colons or line breaks"))
+		}
+		if account.Password == "" {
+			return validationError(fieldError("password", "is required"))
+		}
+		err = account.setPassword(account.Password)
+		if err != nil {
+			return internalError("error hashing password")
+		}
+	case account.AccessCode != "":
+		if !validAccessCode(account.AccessCode) {
+			return validationError(fieldError("access_code", "must consist of at least %d digits", minAccessCodeLength))
+		}
+		account.setAccessCode(account.AccessCode)
+	default:
+		account.AccessCode, err = generateAccessCode()
+		if err != nil {
+			return internalError("error generating access code")
+		}
+		account.setAccessCode(account.AccessCode)
+	}
+
+	created, err := s.storage.CreateListenerAccount(account)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error creating listener account: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "listener account")
+	}
+
+	err = s.refreshListenerAuth(mount.MountName)
+	if err != nil {
+		return err
+	}
+
+	created.Password = account.Password
+	created.AccessCode = account.AccessCode
+	return WriteJson(w, http.StatusCreated, created)
+}
+
+// handleUpdateListenerAccount changes password, access code, expiry and max sessions.
+// Empty password or access code keep the stored secret.
+func (s *ApiServer) handleUpdateListenerAccount(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPrivateMount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getListenerID(r)
+	if err != nil {
+		return err
+	}
+
+	account, err := s.storage.GetListenerAccount(mount.MountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching listener account: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "listener account")
+	}
+
+	var update ListenerAccount
+	err = decodeJSON(w, r, &update)
+	if err != nil {
+		return err
+	}
+
+	if update.MaxSessions < 0 {
+		return validationError(fieldError("max_sessions", "must not be negative"))
+	}
+	if update.Password != "" {
+		if account.Username == "" {
+			return validationError(fieldError("password", "is not allowed for access code accounts"))
+		}
+		err = account.setPassword(update.Password)
+		if err != nil {
+			return internalError("error hashing password")
+		}
+	}
+	if update.AccessCode != "" {
+		if account.Username != "" {
+			return validationError(fieldError("access_code", "is not allowed for password accounts"))
+		}
+		if !validAccessCode(update.AccessCode) {
+			return validationError(fieldError("access_code", "must consist of at least %d digits", minAccessCodeLength))
+		}
+		account.setAccessCode(update.AccessCode)
+	}
+	account.ExpiresAt = update.ExpiresAt
+	account.MaxSessions = update.MaxSessions
+
+	err = s.storage.UpdateListenerAccount(account)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error updating listener account: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "listener account")
+	}
+
+	err = s.refreshListenerAuth(mount.MountName)
+	if err != nil {
+		return err
+	}
+
+	return WriteJson(w, http.StatusOK, account.clearSecrets())
+}
+
+func (s *ApiServer) handleDeleteListenerAccount(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPrivateMount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getListenerID(r)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.DeleteListenerAccount(mount.MountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error deleting listener account: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "listener account")
+	}
+
+	err = s.refreshListenerAuth(mount.MountName)
+	if err != nil {
+		return err
+	}
+
+	return WriteJson(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ###########
+// Icecast url authentication
+// ###########
+
+// handleListenerAuth is called by Icecast for listener_add and listener_remove when a
+// private mount uses url authentication. Icecast grants access if the response
+// carries the icecast-auth-user header.
+func (s *ApiServer) handleListenerAuth(w http.ResponseWriter, r *http.Request) error {
+	if s.config.ListenerAuthSecret == "" {
+		logRequest(r, "Listener auth refused, listener_auth_secret is not set", WarnLog)
+		w.Header().Set("icecast-auth-message", "forbidden")
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("key")), []byte(s.config.ListenerAuthSecret)) != 1 {
+		logRequest(r, "Listener auth called with invalid key", WarnLog)
+		w.Header().Set("icecast-auth-message", "forbidden")
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return fmt.Errorf("invalid form")
+	}
+
+	action := r.PostForm.Get("action")
+	mountName := strings.TrimPrefix(r.PostForm.Get("mount"), "/")
+	client := r.PostForm.Get("client")
+
+	switch action {
+	case "listener_add":
+		// Icecast sends the address of the listener, the request comes from Icecast
+		limitKey := mountName + "|" + r.PostForm.Get("ip")
+		now := time.Now()
+		if s.listenerAuth.blocked(limitKey, now) {
+			logRequest(r, fmt.Sprintf("Listener rejected for %s: too many failed attempts from %s", mountName, r.PostForm.Get("ip")), WarnLog)
+			w.Header().Set("icecast-auth-message", "too many failed attempts")
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		account, reason := s.authenticateListener(mountName, r.PostForm.Get("user"), r.PostForm.Get("pass"))
+		if account == nil {
+			s.listenerAuth.fail(limitKey, now)
+			logRequest(r, fmt.Sprintf("Listener rejected for %s: %s", mountName, reason), InfoLog)
+			w.Header().Set("icecast-auth-message", reason)
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		s.listenerAuth.reset(limitKey)
+		if !s.listenerSessions.tryAdd(mountName, client, account.ID, account.MaxSessions) {
+			logRequest(r, fmt.Sprintf("Listener rejected for %s: too many sessions for account %d", mountName, account.ID), InfoLog)
+			w.Header().Set("icecast-auth-message", "too many sessions")
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		logRequest(r, fmt.Sprintf("Listener admitted for %s with account %d", mountName, account.ID), InfoLog)
+		w.Header().Set("icecast-auth-user", "1")
+		w.WriteHeader(http.StatusOK)
+	case "listener_remove":
+		s.listenerSessions.remove(mountName, client)
+		w.WriteHeader(http.StatusOK)
+	default:
+		return fmt.Errorf("unsupported action")
+	}
+	return nil
+}
+
+// authenticateListener returns the matching account or the reason why there is none.
+// Access codes may be entered as password or, if no password is given, as username.
+func (s *ApiServer) authenticateListener(mountName, user, pass string) (*ListenerAccount, string) {
+	accounts, err := s.storage.GetListenerAccounts(mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Database error fetching listener accounts: %s %s", mountName, err), WarnLog)
+		return nil, "internal error"
+	}
+
+	code := pass
+	if code == "" {
+		code = user
+	}
+	codeHash := getHash(code)
+	now := time.Now()
+
+	for _, account := range accounts {
+		matches := false
+		if account.Username != "" {
+			matches = account.Username == user && validPassword(pass, account.PasswordHash)
+		} else {
+			matches = code != "" && account.AccessCodeHash == codeHash
+		}
+		if !matches {
+			continue
+		}
+		if account.isExpired(now) {
+			return nil, "account expired"
+		}
+		return &account, ""
+	}
+	return nil, "invalid credentials"
+}
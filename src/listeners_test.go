@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireListenerAccounts(t *testing.T) {
+	initTest(t)
+
+	dir := t.TempDir()
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	icecast := NewIcecastConfig(Config{IcecastMountsFolder: dir})
+	scheduler := NewStreamScheduler(storage, icecast, nil)
+
+	err = storage.CreateIcecastMount(IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: PrivateTemplate})
+	if err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	now := time.Now()
+	expiresAt := now.Add(time.Minute)
+	for _, account := range []ListenerAccount{
+		{MountName: "ostern", Username: "gast", ExpiresAt: &expiresAt},
+		{MountName: "ostern", Username: "gemeinde"},
+	} {
+		if err := account.setPassword("geheim"); err != nil {
+			t.Fatalf("Failed to hash password: %v", err)
+		}
+		if _, err := storage.CreateListenerAccount(account); err != nil {
+			t.Fatalf("Failed to create listener account: %v", err)
+		}
+	}
+	accounts, _ := storage.GetListenerAccounts("ostern")
+	if err := icecast.SaveListenerHtpasswd("ostern", accounts, now); err != nil {
+		t.Fatalf("Failed to write htpasswd file: %v", err)
+	}
+
+	users := func() string {
+		data, err := os.ReadFile(icecast.getListenerHtpasswdFilePath("ostern"))
+		if err != nil {
+			t.Fatalf("Failed to read htpasswd file: %v", err)
+		}
+		var names []string
+		for _, line := range strings.Fields(string(data)) {
+			name, _, _ := strings.Cut(line, ":")
+			names = append(names, name)
+		}
+		return strings.Join(names, " ")
+	}
+
+	scheduler.expireListenerAccounts(now)
+	if got := users(); got != "gast gemeinde" {
+		t.Fatalf("Expected both accounts before the expiry, got %q", got)
+	}
+	scheduler.expireListenerAccounts(now.Add(2 * time.Minute))
+	if got := users(); got != "gemeinde" {
+		t.Fatalf("Expected the expired account to be removed, got %q", got)
+	}
+}
+
+func TestAccessCodes(t *testing.T) {
+	initTest(t)
+
+	tests := []struct {
+		code  string
+		valid bool
+	}{
+		{"12345678", true},
+		{"0123456789", true},
+		{"1234", false},
+		{"1234567", false},
+		{"1234567a", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if validAccessCode(test.code) != test.valid {
+			t.Errorf("Expected access code %q valid %t", test.code, test.valid)
+		}
+	}
+
+	code, err := generateAccessCode()
+	if err != nil || len(code) != accessCodeLength || !validAccessCode(code) {
+		t.Fatalf("Expected a valid generated access code, got %q %v", code, err)
+	}
+	var account ListenerAccount
+	account.setAccessCode(code)
+	if account.AccessCodeHash == "" || account.AccessCodeHash == code || account.AccessCodeHash != getHash(code) {
+		t.Fatalf("Expected the access code to be stored as hash, got %q", account.AccessCodeHash)
+	}
+}
+
+func TestAuthenticateListener(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+
+	expired := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	password := ListenerAccount{MountName: "ostern", Username: "gemeinde", ExpiresAt: &future}
+	if err := password.setPassword("geheim"); err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	code := ListenerAccount{MountName: "ostern"}
+	code.setAccessCode("12345678")
+	expiredCode := ListenerAccount{MountName: "ostern", ExpiresAt: &expired}
+	expiredCode.setAccessCode("87654321")
+	other := ListenerAccount{MountName: "pfingsten"}
+	other.setAccessCode("11223344")
+	for _, account := range []ListenerAccount{password, code, expiredCode, other} {
+		if _, err := storage.CreateListenerAccount(account); err != nil {
+			t.Fatalf("Failed to create listener account: %v", err)
+		}
+	}
+
+	tests := []struct {
+		user, pass string
+		username   string
+		reason     string
+	}{
+		{user: "gemeinde", pass: "geheim", username: "gemeinde"},
+		{user: "gemeinde", pass: "falsch", reason: "invalid credentials"},
+		{user: "gast", pass: "geheim", reason: "invalid credentials"},
+		{user: "", pass: "12345678"},
+		{user: "12345678", pass: ""},
+		{user: "gemeinde", pass: "12345678"},
+		{user: "", pass: "87654321", reason: "account expired"},
+		{user: "", pass: "11223344", reason: "invalid credentials"},
+		{user: "", pass: "", reason: "invalid credentials"},
+	}
+	for _, test := range tests {
+		account, reason := s.authenticateListener("ostern", test.user, test.pass)
+		if reason != test.reason {
+			t.Errorf("Expected %q/%q to give %q, got %q", test.user, test.pass, test.reason, reason)
+			continue
+		}
+		if test.reason == "" && (account == nil || account.Username != test.username) {
+			t.Errorf("Expected %q/%q to match account %q, got %+v", test.user, test.pass, test.username, account)
+		}
+	}
+}
+
+func TestListenerSessions(t *testing.T) {
+	initTest(t)
+
+	sessions := NewListenerSessions()
+	steps := []struct {
+		add       bool
+		client    string
+		accountID int64
+		admitted  bool
+	}{
+		{add: true, client: "1", accountID: 1, admitted: true},
+		{add: true, client: "2", accountID: 1, admitted: true},
+		{add: true, client: "3", accountID: 1, admitted: false},
+		{add: true, client: "4", accountID: 2, admitted: true},
+		{add: false, client: "1"},
+		{add: true, client: "3", accountID: 1, admitted: true},
+		{add: true, client: "5", accountID: 1, admitted: false},
+	}
+	for i, step := range steps {
+		if !step.add {
+			sessions.remove("ostern", step.client)
+			continue
+		}
+		if sessions.tryAdd("ostern", step.client, step.accountID, 2) != step.admitted {
+			t.Fatalf("Step %d: expected client %s admitted %t", i, step.client, step.admitted)
+		}
+	}
+	for i := range 10 {
+		if !sessions.tryAdd("pfingsten", fmt.Sprint(i), 3, 0) {
+			t.Fatalf("Expected unlimited sessions without max_sessions")
+		}
+	}
+}
+
+func TestHandleListenerAuth(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	account := ListenerAccount{MountName: "ostern", MaxSessions: 1}
+	account.setAccessCode("12345678")
+	if _, err := storage.CreateListenerAccount(account); err != nil {
+		t.Fatalf("Failed to create listener account: %v", err)
+	}
+	s := &ApiServer{
+		storage:          storage,
+		config:           Config{ListenerAuthSecret: "s3"},
+		listenerSessions: NewListenerSessions(),
+		listenerAuth:     NewListenerAuthLimiter(),
+	}
+
+	call := func(key, action, client, ip, pass string) *httptest.ResponseRecorder {
+		form := url.Values{"action": {action}, "mount": {"/ostern"}, "client": {client}, "ip": {ip}, "pass": {pass}}
+		r := httptest.NewRequest("POST", "/public/listener-auth?key="+key, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		if err := s.handleListenerAuth(w, r); err != nil {
+			t.Fatalf("Listener auth failed: %v", err)
+		}
+		return w
+	}
+	admitted := func(w *httptest.ResponseRecorder) bool {
+		return w.Code == http.StatusOK && w.Header().Get("icecast-auth-user") == "1"
+	}
+
+	if w := call("wrong", "listener_add", "1", "192.0.2.1", "12345678"); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected a wrong key to be forbidden, got %d", w.Code)
+	}
+	if !admitted(call("s3", "listener_add", "1", "192.0.2.1", "12345678")) {
+		t.Fatalf("Expected the listener to be admitted")
+	}
+	if admitted(call("s3", "listener_add", "2", "192.0.2.1", "12345678")) {
+		t.Fatalf("Expected max_sessions to reject a second listener")
+	}
+	call("s3", "listener_remove", "1", "192.0.2.1", "")
+	if !admitted(call("s3", "listener_add", "2", "192.0.2.1", "12345678")) {
+		t.Fatalf("Expected the listener to be admitted after the first one left")
+	}
+	call("s3", "listener_remove", "2", "192.0.2.1", "")
+
+	for i := range maxListenerAuthFailures {
+		if admitted(call("s3", "listener_add", fmt.Sprint(10+i), "192.0.2.66", fmt.Sprintf("%08d", i))) {
+			t.Fatalf("Expected a wrong code to be rejected")
+		}
+	}
+	w := call("s3", "listener_add", "30", "192.0.2.66", "12345678")
+	if admitted(w) || w.Header().Get("icecast-auth-message") != "too many failed attempts" {
+		t.Fatalf("Expected the address to be blocked after %d failures, got %v", maxListenerAuthFailures, w.Header())
+	}
+	if !admitted(call("s3", "listener_add", "31", "192.0.2.1", "12345678")) {
+		t.Fatalf("Expected other addresses not to be blocked")
+	}
+	if s.listenerAuth.blocked("ostern|192.0.2.66", time.Now().Add(listenerAuthFailureWindow)) {
+		t.Fatalf("Expected the block to end with the window")
+	}
+
+	s.config.ListenerAuthSecret = ""
+	if w := call("", "listener_add", "40", "192.0.2.1", "12345678"); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected listener auth without secret to be refused, got %d", w.Code)
+	}
+}
+
+func TestListenerAccountHandlersRejectUnknownFields(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: PrivateTemplate}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	account, err := storage.CreateListenerAccount(ListenerAccount{MountName: "ostern", Username: "hase"})
+	if err != nil {
+		t.Fatalf("Failed to create listener account: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+
+	body := `{"username":"hase","password":"secret","pin":"1234"}`
+	for _, handler := range []apiFunc{s.handleCreateListenerAccount, s.handleUpdateListenerAccount} {
+		r := httptest.NewRequest("POST", "/api/streams/ostern/listeners", strings.NewReader(body))
+		r.SetPathValue("streamName", "ostern")
+		r.SetPathValue("listenerID", fmt.Sprint(account.ID))
+		status, apiErr := apiErrorResponse(handler(httptest.NewRecorder(), r))
+		if status != http.StatusUnprocessableEntity || len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "pin" {
+			t.Errorf("Expected the unknown field to be rejected, got %d %+v", status, apiErr)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxLogLevelDuration limits how long a runtime log level change lasts.
+const maxLogLevelDuration = 24 * time.Hour
+
+// logLevelController holds the current log level and the level configured at
+// startup. Runtime changes can reset to the configured level after a while.
+type logLevelController struct {
+	sync.Mutex
+	configured LogType
+	current    LogType
+	resetTimer *time.Timer
+	resetAt    time.Time
+}
+
+var logLevelState = newLogLevelController(DebugLog)
+
+func newLogLevelController(level LogType) *logLevelController {
+	controller := &logLevelController{configured: level}
+	controller.applyLocked(level, 0)
+	return controller
+}
+
+// LogLevelStatus is returned by the log level endpoint.
+type LogLevelStatus struct {
+	Level           LogType    `json:"level"`
+	ConfiguredLevel LogType    `json:"configured_level"`
+	ResetAt         *time.Time `json:"reset_at,omitempty"`
+}
+
+// LogLevelRequest changes the log level, for a while if Duration is set (e.g. "15m").
+type LogLevelRequest struct {
+	Level    string `json:"level"`
+	Duration string `json:"duration"`
+}
+
+// applyLocked sets the level and, with a duration, schedules the reset to
+// the configured level. The caller holds the lock.
+func (c *logLevelController) applyLocked(level LogType, duration time.Duration) {
+	if c.resetTimer != nil {
+		c.resetTimer.Stop()
+		c.resetTimer = nil
+		c.resetAt = time.Time{}
+	}
+	c.current = level
+	logLevelVar.Set(level.level())
+	if duration > 0 {
+		c.resetAt = time.Now().Add(duration)
+		var timer *time.Timer
+		timer = time.AfterFunc(duration, func() {
+			c.Lock()
+			defer c.Unlock()
+			// A later change replaced this timer but it fired before it was stopped
+			if c.resetTimer != timer {
+				return
+			}
+			c.resetLocked()
+		})
+		c.resetTimer = timer
+	}
+}
+
+func (c *logLevelController) status() LogLevelStatus {
+	c.Lock()
+	defer c.Unlock()
+	status := LogLevelStatus{Level: c.current, ConfiguredLevel: c.configured}
+	if !c.resetAt.IsZero() {
+		resetAt := c.resetAt
+		status.ResetAt = &resetAt
+	}
+	return status
+}
+
+// changeLogLevel changes the log level at runtime. With a duration the
+// configured level is restored afterwards.
+func changeLogLevel(level LogType, duration time.Duration) {
+	logLevelState.Lock()
+	defer logLevelState.Unlock()
+	logLevelState.applyLocked(level, duration)
+	logWithCaller(fmt.Sprintf("Changed log level to %s", level), WarnLog)
+}
+
+func (c *logLevelController) resetLocked() {
+	c.applyLocked(c.configured, 0)
+	logWithCaller(fmt.Sprintf("Reset log level to %s", c.configured), WarnLog)
+}
+
+// resetLogLevel restores the configured log level.
+func resetLogLevel() {
+	logLevelState.Lock()
+	defer logLevelState.Unlock()
+	logLevelState.resetLocked()
+}
+
+func (s *ApiServer) addLogLevelRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"admin/loglevel", makeHTTPHandleFunc(s.handleGetLogLevel))
+	addToRouteRightsMap("GET "+autherized+"admin/loglevel", "change_log_level")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"admin/loglevel", makeHTTPHandleFunc(s.handleChangeLogLevel))
+	addToRouteRightsMap("POST "+autherized+"admin/loglevel", "change_log_level")
+}
+
+func (s *ApiServer) handleGetLogLevel(w http.ResponseWriter, r *http.Request) error {
+	return WriteJson(w, http.StatusOK, logLevelState.status())
+}
+
+func (s *ApiServer) handleChangeLogLevel(w http.ResponseWriter, r *http.Request) error {
+	var request LogLevelRequest
+	if err := decodeJSON(w, r, &request); err != nil {
+		return err
+	}
+
+	level, err := parseLogLevel(request.Level)
+	if err != nil {
+		return err
+	}
+
+	var duration time.Duration
+	if request.Duration != "" {
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 || duration > maxLogLevelDuration {
+			return fmt.Errorf("invalid duration, expected e.g. 15m up to %s", maxLogLevelDuration)
+		}
+	}
+
+	logRequest(r, fmt.Sprintf("Changing log level to %s for %s", level, duration), WarnLog)
+	changeLogLevel(level, duration)
+	return WriteJson(w, http.StatusOK, logLevelState.status())
+}
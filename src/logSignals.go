@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchLogLevelSignals switches to debug logging on SIGUSR1 and back to the
+// configured log level on SIGUSR2.
+func watchLogLevelSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGUSR1:
+			changeLogLevel(DebugLog, 0)
+		case syscall.SIGUSR2:
+			resetLogLevel()
+		}
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// watchLogLevelSignals does nothing, SIGUSR1 and SIGUSR2 only exist on unix.
+func watchLogLevelSignals() {}
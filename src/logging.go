@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 64
+
+	requestContextKey contextKey = "request"
+)
+
+var (
+	logLevelVar = new(slog.LevelVar)
+	logger      = newLogger(os.Stderr, LogFormatText)
+)
+
+// sensitiveLogKeys are attribute keys whose values never reach the log.
+var sensitiveLogKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"token_hash":    true,
+	"secret":        true,
+	"secret_key":    true,
+	"authorization": true,
+}
+
+// sensitiveLogPatterns are redacted from log messages.
+var sensitiveLogPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(regexp.QuoteMeta(tokenPrefix) + `[A-Za-z0-9+/=_-]+`), tokenPrefix + redactedValue},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{64}\b`), redactedValue},
+	{regexp.MustCompile(`(?i)\b(password|secret|token|key)=\S+`), "${1}=" + redactedValue},
+}
+
+func (logtype LogType) level() slog.Level {
+	switch logtype {
+	case DebugLog:
+		return slog.LevelDebug
+	case InfoLog:
+		return slog.LevelInfo
+	case WarnLog:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+// newLogger creates a logger writing text or JSON lines. Unknown formats fall back to text.
+func newLogger(w io.Writer, format string) *slog.Logger {
+	options := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       logLevelVar,
+		ReplaceAttr: redactLogAttr,
+	}
+	var handler slog.Handler
+	if format == LogFormatJSON {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(requestLogHandler{handler})
+}
+
+// setLogFormat switches the log output to text or JSON.
+func setLogFormat(format string) error {
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	logger = newLogger(os.Stderr, format)
+	slog.SetDefault(logger)
+	return nil
+}
+
+func redactLogMessage(message string) string {
+	for _, sensitive := range sensitiveLogPatterns {
+		message = sensitive.pattern.ReplaceAllString(message, sensitive.replacement)
+	}
+	return message
+}
+
+// redactLogAttr removes secrets from the message and the attributes and
+// shortens the source to the file name.
+func redactLogAttr(groups []string, a slog.Attr) slog.Attr {
+	switch {
+	case a.Key == slog.SourceKey:
+		if source, ok := a.Value.Any().(*slog.Source); ok {
+			source.File = filepath.Base(source.File)
+		}
+	case a.Key == slog.MessageKey:
+		return slog.String(a.Key, redactLogMessage(a.Value.String()))
+	case sensitiveLogKeys[strings.ToLower(a.Key)]:
+		return slog.String(a.Key, redactedValue)
+	case a.Value.Kind() == slog.KindString:
+		return slog.String(a.Key, redactLogMessage(a.Value.String()))
+	}
+	return a
+}
+
+// requestLogHandler adds the request ID, user and route of the request in
+// the context to every log line.
+type requestLogHandler struct {
+	slog.Handler
+}
+
+func (h requestLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info := getRequestInfo(ctx); info != nil {
+		record.AddAttrs(slog.String("request_id", info.id))
+		if info.user != "" {
+			record.AddAttrs(slog.String("user", info.user))
+		}
+		if info.route != unmatchedRoute {
+			record.AddAttrs(slog.String("route", info.route))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestLogHandler) WithGroup(name string) slog.Handler {
+	return requestLogHandler{h.Handler.WithGroup(name)}
+}
+
+// logRecord writes a log line attributed to the caller skip frames up.
+func logRecord(ctx context.Context, skip int, message string, logtype LogType, attrs ...slog.Attr) {
+	mu.Lock()
+	current := logger
+	mu.Unlock()
+
+	level := logtype.level()
+	if !current.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(skip, pcs[:])
+	record := slog.NewRecord(time.Now(), level, message, pcs[0])
+	record.AddAttrs(attrs...)
+	_ = current.Handler().Handle(ctx, record)
+}
+
+// logRequest logs a message with the request ID, user and route of the request.
+func logRequest(r *http.Request, message string, logtype LogType, attrs ...slog.Attr) {
+	logRecord(r.Context(), 3, message, logtype, attrs...)
+}
+
+// requestInfo identifies a request in the logs and metrics. It is filled in
+// while the request passes the router and the authorization.
+type requestInfo struct {
+	id    string
+	route string
+	user  string
+}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestContextKey).(*requestInfo)
+	return info
+}
+
+// setRequestUser records the authenticated user of the request.
+func setRequestUser(r *http.Request, username string) {
+	if info := getRequestInfo(r.Context()); info != nil {
+		info.user = username
+	}
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// validRequestID accepts request IDs sent by a proxy if they are short and
+// cannot break the log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware assigns every request an ID, taken from the
+// X-Request-ID header if present, and returns it in the response.
+func requestIDMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		info := &requestInfo{id: id, route: unmatchedRoute}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestContextKey, info)))
+	}
+}
+
+func requestLoggerMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logRequest(r, "Request", InfoLog,
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactLogMessage(t *testing.T) {
+	initTest(t)
+
+	cases := map[string]string{
+		"Checking token k_token:abc+/def== of admin":                                     "Checking token k_token:[redacted] of admin",
+		"Inserted hash 03def589620c813f198fd03d7967e292b163ef0435ebf43071ce0e9519763cb7": "Inserted hash [redacted]",
+		"Calling /listener/auth?mount=live&password=hunter2 now":                         "Calling /listener/auth?mount=live&password=[redacted] now",
+		"Getting mount from Database: live":                                              "Getting mount from Database: live",
+	}
+	for message, expected := range cases {
+		if got := redactLogMessage(message); got != expected {
+			t.Fatalf("Expected %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestJSONLogWithRequest(t *testing.T) {
+	initTest(t)
+
+	var buffer bytes.Buffer
+	current := newLogger(&buffer, LogFormatJSON)
+	ctx := context.WithValue(context.Background(), requestContextKey, &requestInfo{id: "req-1", route: "GET /api/streams", user: "admin"})
+	current.InfoContext(ctx, "Created stream", slog.String("password", "hunter2"))
+
+	var line map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &line); err != nil {
+		t.Fatalf("Invalid JSON log line %q: %v", buffer.String(), err)
+	}
+	expected := map[string]string{"msg": "Created stream", "request_id": "req-1", "user": "admin", "route": "GET /api/streams", "password": redactedValue}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Fatalf("Expected %s=%q in %s", key, value, strings.TrimSpace(buffer.String()))
+		}
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	initTest(t)
+
+	if !validRequestID("3f2a-b1_c.9") {
+		t.Fatalf("Expected request ID to be accepted")
+	}
+	for _, id := range []string{"", "a b", "id\nforged=1", strings.Repeat("a", maxRequestIDLength+1)} {
+		if validRequestID(id) {
+			t.Fatalf("Expected request ID %q to be rejected", id)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	initTest(t)
+
+	level, err := parseLogLevel(" WARN ")
+	if err != nil || level != WarnLog {
+		t.Fatalf("Expected warn, got %q %v", level, err)
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Fatalf("Expected unknown log level to be rejected")
+	}
+}
+
+func TestChangeLogLevelResets(t *testing.T) {
+	initTest(t)
+	setLogLevel(InfoLog)
+	defer setLogLevel(DebugLog)
+
+	changeLogLevel(DebugLog, 20*time.Millisecond)
+	if status := logLevelState.status(); status.Level != DebugLog || status.ResetAt == nil {
+		t.Fatalf("Unexpected log level after change: %+v", status)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if status := logLevelState.status(); status.Level != InfoLog || status.ResetAt != nil {
+		t.Fatalf("Expected log level to reset to info: %+v", status)
+	}
+	if logLevelVar.Level() != slog.LevelInfo {
+		t.Fatalf("Expected logger level info, got %s", logLevelVar.Level())
+	}
+}
@@ -12,7 +12,7 @@ import (
 func ReadConfigFile(filePath string) (*Config, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Printf("Error reading config file: %v", err)
+		logWithCaller(fmt.Sprintf("Error reading config file: %v", err), FatalLog)
 		return nil, err
 	}
 	var config Config
@@ -26,11 +26,21 @@ func ReadConfigFile(filePath string) (*Config, error) {
 
 func main() {
 	configFileLocation := flag.String("config", "stream.config", "Location of config (e.g., stream.config)")
-	logLevel := *flag.String("loglevel", "debug", "Log level (debug, info, warn, fatal)")
+	logLevelFlag := flag.String("loglevel", string(DebugLog), "Log level (debug, info, warn, fatal)")
+	logFormat := flag.String("logformat", LogFormatText, "Log format (text, json)")
 	flag.Parse()
 
-	setLogLevel(LogType(logLevel))
+	if err := setLogFormat(*logFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	logLevel, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setLogLevel(logLevel)
 	logWithCaller(fmt.Sprintf("Set Loglevel to %s", logLevel), InfoLog)
+	go watchLogLevelSignals()
 
 	config, err := ReadConfigFile(*configFileLocation)
 	if err != nil {
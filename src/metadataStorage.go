@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func (s *SqliteStorage) AddMetadataEntry(entry MetadataEntry) error {
+	defer observeQuery("AddMetadataEntry", time.Now())
+	logWithCaller(fmt.Sprintf("Saving metadata for mount %s: %s", entry.MountName, entry.Title), DebugLog)
+	stmt, err := s.db.Prepare(`
+	INSERT INTO metadata_history (mount_name, title, observed_at)
+	VALUES ($1, $2, $3)
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(entry.MountName, entry.Title, entry.ObservedAt)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return nil
+}
+
+// GetMetadataHistory returns the titles of a mount seen between from and to, oldest first.
+func (s *SqliteStorage) GetMetadataHistory(mountName string, from, to time.Time) ([]MetadataEntry, error) {
+	defer observeQuery("GetMetadataHistory", time.Now())
+	logWithCaller(fmt.Sprintf("Getting metadata history for mount: %s", mountName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT mount_name, title, observed_at
+	FROM metadata_history
+	WHERE mount_name = $1 AND observed_at >= $2 AND observed_at <= $3
+	ORDER BY observed_at
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(mountName, from.UTC(), to.UTC())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []MetadataEntry{}
+	for rows.Next() {
+		var entry MetadataEntry
+		err = rows.Scan(&entry.MountName, &entry.Title, &entry.ObservedAt)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics are written in the Prometheus text exposition format. The few
+// metric types the API needs are implemented here to avoid the dependency.
+
+const (
+	metricsPrefix    = "streamapi_"
+	metricsAuthRealm = `Bearer realm="metrics"`
+	unmatchedRoute   = "unmatched"
+)
+
+var (
+	httpDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	dbDurationBuckets   = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+type metric interface {
+	write(w io.Writer)
+}
+
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+var defaultMetrics = &metricsRegistry{}
+
+func (registry *metricsRegistry) register(m metric) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+func (registry *metricsRegistry) write(w io.Writer) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, m := range registry.metrics {
+		m.write(w)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {name="value",...}, extra is appended as is.
+func formatLabels(names, values []string, extra string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func writeMetricHeader(w io.Writer, name, help, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// counterVec is a counter with labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	series map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	counter := &counterVec{
+		name:   metricsPrefix + name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		series: make(map[string][]string),
+	}
+	defaultMetrics.register(counter)
+	return counter
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := seriesKey(labelValues)
+	c.values[key]++
+	c.series[key] = labelValues
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeMetricHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.series[key], ""), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram with labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	histogram := &histogramVec{
+		name:    metricsPrefix + name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	defaultMetrics.register(histogram)
+	return histogram
+}
+
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := seriesKey(labelValues)
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		series := h.series[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, `le="`+formatFloat(bound)+`"`), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labelValues, `le="+Inf"`), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, series.labelValues, ""), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.labelValues, ""), series.count)
+	}
+}
+
+// gaugeSample is one value of a gauge that is computed when the metrics are scraped.
+type gaugeSample struct {
+	labelValues []string
+	value       float64
+}
+
+func writeGauge(w io.Writer, name, help string, labels []string, samples []gaugeSample) {
+	name = metricsPrefix + name
+	writeMetricHeader(w, name, help, "gauge")
+	slices.SortFunc(samples, func(a, b gaugeSample) int {
+		return strings.Compare(seriesKey(a.labelValues), seriesKey(b.labelValues))
+	})
+	for _, sample := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, sample.labelValues, ""), formatFloat(sample.value))
+	}
+}
+
+var (
+	httpRequests    = newCounterVec("http_requests_total", "HTTP requests by route pattern and status.", "method", "route", "status")
+	httpDuration    = newHistogramVec("http_request_duration_seconds", "Duration of HTTP requests by route pattern.", httpDurationBuckets, "method", "route")
+	authFailures    = newCounterVec("auth_failures_total", "Rejected API requests by reason.", "reason")
+	dbQueryDuration = newHistogramVec("db_query_duration_seconds", "Duration of database queries by storage method.", dbDurationBuckets, "query")
+	mountFileErrors = newCounterVec("mount_file_errors_total", "Errors writing or deleting Icecast mount files.", "operation")
+)
+
+// observeQuery records the duration of a storage method, use it with defer.
+func observeQuery(query string, start time.Time) {
+	dbQueryDuration.Observe(time.Since(start).Seconds(), query)
+}
+
+// countMountFileError counts the error a mount file operation returns, use it with defer.
+func countMountFileError(operation string, err *error) {
+	if *err != nil {
+		mountFileErrors.Inc(operation)
+	}
+}
+
+// setRoutePattern labels the request metrics and logs with the matched route pattern.
+func setRoutePattern(r *http.Request, pattern string) {
+	if info := getRequestInfo(r.Context()); info != nil && pattern != "" {
+		info.route = pattern
+	}
+}
+
+// statusRecorder remembers the status code written by the handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+// Flush keeps Server-Sent Events working through the recorder.
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+func metricsMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := getRequestInfo(r.Context())
+		if info == nil {
+			info = &requestInfo{route: unmatchedRoute}
+			r = r.WithContext(context.WithValue(r.Context(), requestContextKey, info))
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.Inc(r.Method, info.route, strconv.Itoa(status))
+		httpDuration.Observe(time.Since(start).Seconds(), r.Method, info.route)
+	}
+}
+
+// handleMetrics serves the metrics for Prometheus. If metrics_token is set,
+// it has to be sent as bearer token. Without token the metrics are public, so
+// the per-mount series only cover public mounts.
+func (s *ApiServer) handleMetrics(w http.ResponseWriter, r *http.Request) error {
+	if s.config.MetricsToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.MetricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", metricsAuthRealm)
+			return WriteJson(w, http.StatusUnauthorized, ApiError{Error: "Unauthorized", Code: ErrorCodeUnauthorized})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	defaultMetrics.write(w)
+	s.writeStateMetrics(w, s.config.MetricsToken != "")
+	return nil
+}
+
+// writeStateMetrics writes the gauges that are computed on every scrape. The
+// per-mount gauges leave out private and non-public mounts unless all is set.
+func (s *ApiServer) writeStateMetrics(w io.Writer, all bool) {
+	mounts, err := s.storage.GetIcecastMounts()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Database error fetching icecast mounts for metrics: %s", err), WarnLog)
+		return
+	}
+	writeGauge(w, "mounts_configured", "Number of configured mounts.", nil, []gaugeSample{{value: float64(len(mounts))}})
+
+	listeners := []gaugeSample{}
+	live := []gaugeSample{}
+	for _, mount := range mounts {
+		if !all && !isPublicMount(mount) {
+			continue
+		}
+		status := s.icecastStatus.Status(mount.MountName)
+		listeners = append(listeners, gaugeSample{labelValues: []string{mount.MountName}, value: float64(status.Listeners)})
+		liveValue := 0.0
+		if status.Live {
+			liveValue = 1
+		}
+		live = append(live, gaugeSample{labelValues: []string{mount.MountName}, value: liveValue})
+	}
+	writeGauge(w, "mount_listeners", "Current listeners per mount as reported by Icecast.", []string{"mount"}, listeners)
+	writeGauge(w, "mount_source_live", "Whether a source is connected to the mount.", []string{"mount"}, live)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parseMetrics reads the samples of the text format into a map from the
+// series, name and labels as written, to the value.
+func parseMetrics(t *testing.T, text string) map[string]string {
+	t.Helper()
+	samples := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		index := strings.LastIndex(line, " ")
+		if index < 0 {
+			t.Fatalf("Invalid sample line %q", line)
+		}
+		samples[line[:index]] = line[index+1:]
+	}
+	return samples
+}
+
+func TestMetricsTextFormat(t *testing.T) {
+	counter := &counterVec{name: "test_total", help: "Test counter.", labels: []string{"route"}, values: map[string]float64{}, series: map[string][]string{}}
+	counter.Inc("/api/streams")
+	counter.Inc("/api/streams")
+	counter.Inc(`say "hi"` + "\n")
+
+	histogram := &histogramVec{name: "test_seconds", help: "Test histogram.", labels: []string{"query"}, buckets: []float64{0.1, 1}, series: map[string]*histogramSeries{}}
+	histogram.Observe(0.05, "GetIcecastMount")
+	histogram.Observe(0.5, "GetIcecastMount")
+	histogram.Observe(5, "GetIcecastMount")
+
+	var output strings.Builder
+	counter.write(&output)
+	histogram.write(&output)
+	text := output.String()
+
+	for _, header := range []string{"# TYPE test_total counter\n", "# HELP test_seconds Test histogram.\n", "# TYPE test_seconds histogram\n"} {
+		if !strings.Contains(text, header) {
+			t.Errorf("Expected header %q in %q", header, text)
+		}
+	}
+	expected := map[string]string{
+		`test_total{route="/api/streams"}`:                       "2",
+		`test_total{route="say \"hi\"\n"}`:                       "1",
+		`test_seconds_bucket{query="GetIcecastMount",le="0.1"}`:  "1",
+		`test_seconds_bucket{query="GetIcecastMount",le="1"}`:    "2",
+		`test_seconds_bucket{query="GetIcecastMount",le="+Inf"}`: "3",
+		`test_seconds_sum{query="GetIcecastMount"}`:              "5.55",
+		`test_seconds_count{query="GetIcecastMount"}`:            "3",
+	}
+	samples := parseMetrics(t, text)
+	if len(samples) != len(expected) {
+		t.Errorf("Expected %d samples, got %v", len(expected), samples)
+	}
+	for series, value := range expected {
+		if samples[series] != value {
+			t.Errorf("Expected %s to be %s, got %q", series, value, samples[series])
+		}
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, mount := range []IcecastMount{
+		{MountName: "ostern", TemplateType: DefaultTemplate},
+		{MountName: "pfingsten", Public: 1, TemplateType: DefaultTemplate},
+	} {
+		mount.Username, mount.Password = "source", "secret"
+		if err := storage.CreateIcecastMount(mount); err != nil {
+			t.Fatalf("Failed to create mount: %v", err)
+		}
+	}
+
+	tests := []struct {
+		token         string
+		authorization string
+		status        int
+	}{
+		{"", "", http.StatusOK},
+		{"scrape", "Bearer scrape", http.StatusOK},
+		{"scrape", "", http.StatusUnauthorized},
+		{"scrape", "Bearer wrong", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		config := Config{MetricsToken: test.token}
+		s := &ApiServer{storage: storage, config: config, icecastStatus: NewIcecastStatusPoller(config, storage, NewEventBroker())}
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		if err := s.handleMetrics(w, r); err != nil {
+			t.Fatalf("Failed to serve metrics: %v", err)
+		}
+		if w.Code != test.status {
+			t.Errorf("Expected %d for token %q and %q, got %d", test.status, test.token, test.authorization, w.Code)
+			continue
+		}
+		if w.Code == http.StatusUnauthorized {
+			if w.Header().Get("WWW-Authenticate") != metricsAuthRealm {
+				t.Errorf("Expected a WWW-Authenticate header, got %q", w.Header().Get("WWW-Authenticate"))
+			}
+			continue
+		}
+		samples := parseMetrics(t, w.Body.String())
+		if samples["streamapi_mounts_configured"] != "2" || samples[`streamapi_mount_source_live{mount="pfingsten"}`] != "0" {
+			t.Errorf("Expected the state metrics of the offline mounts, got %v", samples)
+		}
+		// Without token the non-public mount is left out
+		if _, listed := samples[`streamapi_mount_listeners{mount="ostern"}`]; listed != (test.token != "") {
+			t.Errorf("Expected the non-public mount listed %t for token %q, got %v", test.token != "", test.token, samples)
+		}
+	}
+}
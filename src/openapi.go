@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	apiPrefix   = "/api/"
+	apiV1Prefix = "/api/v1/"
+
+	openAPIVersion = "3.0.3"
+)
+
+// apiPath returns the path below the API prefix, for the versioned as well as
+// for the unversioned paths.
+func apiPath(path string) string {
+	if strings.HasPrefix(path, apiV1Prefix) {
+		return strings.TrimPrefix(path, apiV1Prefix)
+	}
+	return strings.TrimPrefix(path, apiPrefix)
+}
+
+// apiOperation documents a route. Paths of authorized routes are relative to
+// the API prefix, the permission is taken from routeRightsMap. Request and
+// response are values of the Go types written as JSON.
+type apiOperation struct {
+	method      string
+	path        string
+	tag         string
+	summary     string
+	query       []string
+	headers     []string
+	request     any
+	status      int
+	response    any
+	contentType string
+}
+
+type statusResponse map[string]string
+
+// authorizedOperations are the routes below /api/v1/.
+var authorizedOperations = []apiOperation{
+	{method: "GET", path: "streams", tag: "streams", summary: "List streams, paginated with limit and cursor",
+		query: []string{"limit", "cursor", "sort", "public", "template_type", "q"}, response: []IcecastMount{}},
+	{method: "POST", path: "streams", tag: "streams", summary: "Create a stream",
+		headers: []string{idempotencyKeyHeader}, request: IcecastMount{}, status: http.StatusCreated, response: IcecastMount{}},
+	{method: "GET", path: "streams/{streamName}", tag: "streams", summary: "Get a stream and its ETag",
+		headers: []string{"If-None-Match"}, response: IcecastMount{}},
+	{method: "POST", path: "streams/{streamName}", tag: "streams", summary: "Replace a stream",
+		headers: []string{"If-Match"}, request: IcecastMount{}, response: IcecastMount{}},
+	{method: "PATCH", path: "streams/{streamName}", tag: "streams", summary: "Change fields of a stream with a JSON Merge Patch",
+		headers: []string{"If-Match"}, request: map[string]any{}, response: IcecastMount{}},
+	{method: "DELETE", path: "streams/{streamName}", tag: "streams", summary: "Move a stream to the trash",
+		headers: []string{"If-Match"}, response: statusResponse{}},
+	{method: "POST", path: "streams/{streamName}/rename", tag: "streams", summary: "Rename a stream",
+		headers: []string{"If-Match"}, request: RenameRequest{}, response: IcecastMount{}},
+	{method: "GET", path: "streams/{streamName}/redirects", tag: "streams", summary: "List the old names redirecting to a stream",
+		response: []MountRedirect{}},
+	{method: "DELETE", path: "streams/{streamName}/redirects/{fromName}", tag: "streams", summary: "Remove a redirect",
+		response: statusResponse{}},
+	{method: "GET", path: "streams/export", tag: "streams", summary: "Export all streams as JSON or YAML",
+		query: []string{"format"}, response: []IcecastMount{}},
+	{method: "POST", path: "streams/import", tag: "streams", summary: "Import streams from JSON, YAML or Icecast XML",
+		query: []string{"on_conflict", "dry_run"}, headers: []string{idempotencyKeyHeader}, request: []IcecastMount{}, response: ImportReport{}},
+	{method: "POST", path: "streams:batch", tag: "streams", summary: "Run many stream operations in one transaction",
+		headers: []string{idempotencyKeyHeader}, request: BatchRequest{}, response: BatchResponse{}},
+
+	{method: "GET", path: "streams/{streamName}/listeners", tag: "listeners", summary: "List the listener accounts of a private stream",
+		response: []ListenerAccount{}},
+	{method: "POST", path: "streams/{streamName}/listeners", tag: "listeners", summary: "Create a listener account",
+		request: ListenerAccount{}, status: http.StatusCreated, response: ListenerAccount{}},
+	{method: "GET", path: "streams/{streamName}/listeners/{listenerID}", tag: "listeners", summary: "Get a listener account",
+		response: ListenerAccount{}},
+	{method: "POST", path: "streams/{streamName}/listeners/{listenerID}", tag: "listeners", summary: "Update a listener account",
+		request: ListenerAccount{}, response: ListenerAccount{}},
+	{method: "DELETE", path: "streams/{streamName}/listeners/{listenerID}", tag: "listeners", summary: "Delete a listener account",
+		response: statusResponse{}},
+
+	{method: "GET", path: "schedule", tag: "schedules", summary: "List the scheduled broadcasts of all streams",
+		query: []string{"from", "to"}, response: []ScheduleOccurrence{}},
+	{method: "GET", path: "streams/{streamName}/schedules", tag: "schedules", summary: "List the schedules of a stream",
+		response: []StreamSchedule{}},
+	{method: "POST", path: "streams/{streamName}/schedules", tag: "schedules", summary: "Create a schedule",
+		request: StreamSchedule{}, status: http.StatusCreated, response: StreamSchedule{}},
+	{method: "GET", path: "streams/{streamName}/schedules/{scheduleID}", tag: "schedules", summary: "Get a schedule",
+		response: StreamSchedule{}},
+	{method: "POST", path: "streams/{streamName}/schedules/{scheduleID}", tag: "schedules", summary: "Update a schedule",
+		request: StreamSchedule{}, response: StreamSchedule{}},
+	{method: "DELETE", path: "streams/{streamName}/schedules/{scheduleID}", tag: "schedules", summary: "Delete a schedule",
+		response: statusResponse{}},
+	{method: "GET", path: "streams/{streamName}/schedule.ics", tag: "schedules", summary: "Get the schedule of a stream as iCalendar",
+		contentType: "text/calendar"},
+
+	{method: "GET", path: "streams/{streamName}/recordings", tag: "recordings", summary: "List the recordings of a stream",
+		response: []Recording{}},
+	{method: "GET", path: "streams/{streamName}/recordings/{recordingID}", tag: "recordings", summary: "Download a recording",
+		contentType: "audio/mpeg"},
+	{method: "DELETE", path: "streams/{streamName}/recordings/{recordingID}", tag: "recordings", summary: "Delete a recording",
+		response: statusResponse{}},
+
+	{method: "GET", path: "streams/{streamName}/revisions", tag: "revisions", summary: "List the revisions of a stream",
+		response: []MountRevision{}},
+	{method: "GET", path: "streams/{streamName}/revisions/{revisionID}", tag: "revisions", summary: "Get a revision",
+		response: MountRevision{}},
+	{method: "GET", path: "streams/{streamName}/revisions/{revisionID}/diff", tag: "revisions", summary: "Compare a revision with the stream or another revision",
+		query: []string{"to"}, response: RevisionDiff{}},
+	{method: "POST", path: "streams/{streamName}/revisions/{revisionID}/restore", tag: "revisions", summary: "Restore a revision",
+		headers: []string{"If-Match"}, response: IcecastMount{}},
+
+	{method: "GET", path: "trash/streams", tag: "trash", summary: "List the streams in the trash",
+		response: []TrashedMount{}},
+	{method: "POST", path: "trash/streams/{streamName}/restore", tag: "trash", summary: "Restore a stream from the trash",
+		response: IcecastMount{}},
+	{method: "DELETE", path: "trash/streams/{streamName}", tag: "trash", summary: "Purge a stream from the trash",
+		response: statusResponse{}},
+
+	{method: "GET", path: "events", tag: "events", summary: "Subscribe to events as Server-Sent Events",
+		query: []string{"types", "last_event_id"}, headers: []string{"Last-Event-ID"}, contentType: "text/event-stream"},
+
+	{method: "GET", path: "webhooks", tag: "webhooks", summary: "List webhooks",
+		response: []Webhook{}},
+	{method: "POST", path: "webhooks", tag: "webhooks", summary: "Create a webhook",
+		request: webhookRequest{}, status: http.StatusCreated, response: Webhook{}},
+	{method: "GET", path: "webhooks/{webhookID}", tag: "webhooks", summary: "Get a webhook",
+		response: Webhook{}},
+	{method: "POST", path: "webhooks/{webhookID}", tag: "webhooks", summary: "Update a webhook",
+		request: webhookRequest{}, response: Webhook{}},
+	{method: "DELETE", path: "webhooks/{webhookID}", tag: "webhooks", summary: "Delete a webhook",
+		response: statusResponse{}},
+	{method: "GET", path: "webhooks/{webhookID}/deliveries", tag: "webhooks", summary: "List the deliveries of a webhook",
+		query: []string{"limit"}, response: []WebhookDelivery{}},
+
+	{method: "GET", path: "alerts", tag: "alerts", summary: "List alerts",
+		query: []string{"state"}, response: []Alert{}},
+	{method: "GET", path: "alerts/{alertID}", tag: "alerts", summary: "Get an alert",
+		response: Alert{}},
+	{method: "POST", path: "alerts/{alertID}/ack", tag: "alerts", summary: "Acknowledge an alert",
+		response: Alert{}},
+	{method: "GET", path: "alerts/silences", tag: "alerts", summary: "List alert silences",
+		response: []AlertSilence{}},
+	{method: "POST", path: "alerts/silences", tag: "alerts", summary: "Silence alerts",
+		request: AlertSilence{}, status: http.StatusCreated, response: AlertSilence{}},
+	{method: "DELETE", path: "alerts/silences/{silenceID}", tag: "alerts", summary: "Delete an alert silence",
+		response: statusResponse{}},
+
+	{method: "GET", path: "audit", tag: "audit", summary: "Read the audit log",
+		query: []string{"actor", "action", "target", "since", "until", "limit", "cursor"}, response: AuditPage{}},
+	{method: "GET", path: "audit/export", tag: "audit", summary: "Export the audit log as NDJSON",
+		query: []string{"actor", "action", "target", "since", "until"}, contentType: "application/x-ndjson"},
+
+	{method: "GET", path: "admin/loglevel", tag: "admin", summary: "Get the log level",
+		response: LogLevelStatus{}},
+	{method: "POST", path: "admin/loglevel", tag: "admin", summary: "Change the log level",
+		request: LogLevelRequest{}, response: LogLevelStatus{}},
+}
+
+// publicOperations are the routes that need no token.
+var publicOperations = []apiOperation{
+	{method: "GET", path: "/user/token", tag: "user", summary: "Get a token for username and password",
+		query: []string{"username", "password"}, response: map[string]string{}},
+	{method: "GET", path: "/public/health", tag: "public", summary: "Health check", response: statusResponse{}},
+	{method: "GET", path: "/public/version", tag: "public", summary: "Version of the API", response: map[string]string{}},
+	{method: "GET", path: "/public/openapi.json", tag: "public", summary: "This OpenAPI document", response: map[string]any{}},
+	{method: "POST", path: "/public/listener-auth", tag: "public", summary: "Listener authentication callback of Icecast",
+		query: []string{"key"}},
+	{method: "GET", path: "/public/streams", tag: "public", summary: "Directory of the public streams", response: []PublicStream{}},
+	{method: "GET", path: "/public/streams/{playlist}", tag: "public", summary: "Playlist of a stream as M3U or PLS",
+		contentType: "audio/x-mpegurl"},
+	{method: "GET", path: "/public/streams/{streamName}/status", tag: "public", summary: "Status of a public stream",
+		response: PublicStream{}},
+	{method: "GET", path: "/public/streams/{streamName}/schedule.ics", tag: "public", summary: "Schedule of a public stream as iCalendar",
+		contentType: "text/calendar"},
+	{method: "GET", path: "/public/streams/{streamName}/podcast.xml", tag: "public", summary: "Podcast feed of a stream",
+		contentType: "application/rss+xml"},
+	{method: "GET", path: "/public/streams/{streamName}/episodes/{recordingID}/{fileName}", tag: "public", summary: "Podcast episode",
+		contentType: "audio/mpeg"},
+	{method: "GET", path: "/public/player/{streamName}", tag: "public", summary: "Web player of a stream",
+		contentType: "text/html"},
+	{method: "GET", path: "/public/player/{streamName}/embed", tag: "public", summary: "Embed code of the web player",
+		response: map[string]any{}},
+}
+
+var (
+	openAPIOnce     sync.Once
+	openAPIDocument []byte
+)
+
+func (s *ApiServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	openAPIOnce.Do(func() {
+		var err error
+		openAPIDocument, err = json.MarshalIndent(buildOpenAPI(), "", "  ")
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error encoding OpenAPI document: %s", err), FatalLog)
+		}
+	})
+	if openAPIDocument == nil {
+		return internalError("encoding error")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(openAPIDocument)
+	return err
+}
+
+var pathParameterPattern = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+// buildOpenAPI generates the OpenAPI document from the operation tables.
+// The schemas are derived from the Go types, so they follow the JSON tags.
+func buildOpenAPI() map[string]any {
+	schemas := openAPISchemas{}
+	paths := map[string]map[string]any{}
+	add := func(path string, operation apiOperation, security []map[string][]string, description string) {
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(operation.method)] = schemas.operation(path, operation, security, description)
+	}
+
+	for _, operation := range authorizedOperations {
+		right := routeRightsMap[operation.method+" "+apiV1Prefix+operation.path]
+		description := fmt.Sprintf("Needs the `%s` permission.", right)
+		add(apiV1Prefix+operation.path, operation, []map[string][]string{{"token": {}}}, description)
+	}
+	for _, operation := range publicOperations {
+		add(operation.path, operation, []map[string][]string{}, "")
+	}
+
+	schemas.schemaOf(reflect.TypeOf(ApiError{}))
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "kulturtelefon-stream API",
+			"version": "1",
+			"description": "Manages Icecast streams. The paths below /api/v1/ are also served below /api/ " +
+				"for existing clients.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "apiKey", "in": "header", "name": "Authorization"},
+			},
+		},
+	}
+}
+
+// openAPISchemas collects the named schemas of the document.
+type openAPISchemas map[string]any
+
+func (schemas openAPISchemas) operation(path string, operation apiOperation, security []map[string][]string, description string) map[string]any {
+	parameters := []map[string]any{}
+	for _, match := range pathParameterPattern.FindAllStringSubmatch(path, -1) {
+		parameters = append(parameters, map[string]any{"name": match[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+	}
+	for _, name := range operation.query {
+		parameters = append(parameters, map[string]any{"name": name, "in": "query", "schema": map[string]any{"type": "string"}})
+	}
+	for _, name := range operation.headers {
+		parameters = append(parameters, map[string]any{"name": name, "in": "header", "schema": map[string]any{"type": "string"}})
+	}
+
+	status := operation.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case operation.response != nil:
+		response["content"] = map[string]any{"application/json": map[string]any{"schema": schemas.schemaOf(reflect.TypeOf(operation.response))}}
+	case operation.contentType != "":
+		response["content"] = map[string]any{operation.contentType: map[string]any{}}
+	}
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content":     map[string]any{"application/json": map[string]any{"schema": schemas.schemaOf(reflect.TypeOf(ApiError{}))}},
+	}
+
+	result := map[string]any{
+		"tags":        []string{operation.tag},
+		"summary":     operation.summary,
+		"operationId": operationID(operation.method, path),
+		"parameters":  parameters,
+		"security":    security,
+		"responses": map[string]any{
+			fmt.Sprint(status): response,
+			"default":          errorResponse,
+		},
+	}
+	if description != "" {
+		result["description"] = description
+	}
+	if operation.request != nil {
+		result["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": schemas.schemaOf(reflect.TypeOf(operation.request))}},
+		}
+	}
+	return result
+}
+
+// operationID turns "GET /api/v1/streams/{streamName}" into "getStreamsStreamName".
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	path = strings.TrimPrefix(strings.TrimPrefix(path, apiV1Prefix), "/")
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// schemaOf returns the schema of a type as encoding/json writes it. Named
+// structs become components referenced by name.
+func (schemas openAPISchemas) schemaOf(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemas.schemaOf(t.Elem())
+		if _, ref := schema["$ref"]; !ref {
+			schema["nullable"] = true
+		}
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemas.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemas.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return schemas.structSchema(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = map[string]any{}
+			schemas[name] = schemas.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func (schemas openAPISchemas) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	jsonFields(t, func(name string, field reflect.StructField) {
+		properties[name] = schemas.schemaOf(field.Type)
+	})
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// jsonFields calls f for every field encoding/json writes, fields of embedded
+// structs are flattened.
+func jsonFields(t reflect.Type, f func(name string, field reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			jsonFields(field.Type, f)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		f(name, field)
+	}
+}
+
+// openAPIPaths returns the documented routes as "METHOD path", sorted.
+func openAPIPaths() []string {
+	var routes []string
+	for _, operation := range authorizedOperations {
+		routes = append(routes, operation.method+" "+apiV1Prefix+operation.path)
+	}
+	for _, operation := range publicOperations {
+		routes = append(routes, operation.method+" "+operation.path)
+	}
+	sort.Strings(routes)
+	return routes
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	initTest(t)
+
+	s := &ApiServer{}
+	router := http.NewServeMux()
+	s.addPublicRoutes(router)
+	s.addAuthorizedRoutes(router)
+	s.addUserRoutes(router)
+
+	documented := map[string]bool{}
+	for _, route := range openAPIPaths() {
+		documented[route] = true
+	}
+
+	var registered []string
+	for route := range routeRightsMap {
+		method, path, _ := strings.Cut(route, " ")
+		if strings.HasPrefix(path, apiV1Prefix) {
+			registered = append(registered, route)
+			if !documented[route] {
+				t.Errorf("Route %s is not documented", route)
+			}
+			alias := method + " " + apiPrefix + strings.TrimPrefix(path, apiV1Prefix)
+			if _, ok := routeRightsMap[alias]; !ok {
+				t.Errorf("Route %s has no unversioned alias", route)
+			}
+		}
+	}
+	sort.Strings(registered)
+
+	for route := range documented {
+		method, path, _ := strings.Cut(route, " ")
+		if strings.HasPrefix(path, apiV1Prefix) {
+			if _, ok := routeRightsMap[route]; !ok {
+				t.Errorf("Documented route %s is not registered", route)
+			}
+			continue
+		}
+		r := httptest.NewRequest(method, pathParameterPattern.ReplaceAllString(path, "x"), nil)
+		handler, pattern := router.Handler(r)
+		if mux, ok := handler.(*http.ServeMux); ok {
+			_, pattern = mux.Handler(r)
+		}
+		if pattern != route {
+			t.Errorf("Documented route %s is served by %q", route, pattern)
+		}
+	}
+	if len(registered) == 0 {
+		t.Fatal("Expected routes below /api/v1/")
+	}
+}
+
+func TestOpenAPIMountSchema(t *testing.T) {
+	initTest(t)
+
+	document := buildOpenAPI()
+	schemas := document["components"].(map[string]any)["schemas"].(openAPISchemas)
+	properties := schemas["IcecastMount"].(map[string]any)["properties"].(map[string]any)
+
+	mount := IcecastMount{
+		MountName: "ostern", Username: "source", Password: "secret", Public: 1,
+		StreamName: "Ostern", StreamDescription: "Ostermesse", TemplateType: "default",
+		Recording: true, RecordingPattern: "%Y.mp3", RecordingRetentionDays: 7, Podcast: true, Version: 3,
+	}
+	data, err := json.Marshal(mount)
+	if err != nil {
+		t.Fatalf("Failed to encode mount: %v", err)
+	}
+	var fields map[string]any
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		t.Fatalf("Failed to decode mount: %v", err)
+	}
+
+	if len(fields) != len(properties) {
+		t.Errorf("Expected %d properties, got %d: %v", len(fields), len(properties), properties)
+	}
+	for name, value := range fields {
+		property, ok := properties[name].(map[string]any)
+		if !ok {
+			t.Errorf("Field %s is missing in the schema", name)
+			continue
+		}
+		kind := jsonKind(value)
+		if property["type"] != kind && !(kind == "number" && property["type"] == "integer") {
+			t.Errorf("Field %s is %s, the schema says %v", name, kind, property["type"])
+		}
+	}
+
+	_, err = json.Marshal(document)
+	if err != nil {
+		t.Fatalf("Failed to encode document: %v", err)
+	}
+}
+
+// jsonKind returns the OpenAPI type of a decoded JSON value.
+func jsonKind(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "unknown"
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// mountETag returns the ETag of the version of the mount.
+func mountETag(mount IcecastMount) string {
+	return fmt.Sprintf(`"%d"`, mount.Version)
+}
+
+func setMountETag(w http.ResponseWriter, mount IcecastMount) {
+	w.Header().Set("ETag", mountETag(mount))
+}
+
+// matchesETag reports whether an If-Match or If-None-Match header names the
+// version of the mount. "*" matches every version.
+func matchesETag(header string, mount IcecastMount) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == mountETag(mount) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch makes sure the caller changes the version of the mount it
+// fetched last, so two editors do not overwrite each other.
+func checkIfMatch(r *http.Request, mount IcecastMount) error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return &StatusError{
+			Status:  http.StatusPreconditionRequired,
+			Code:    ErrorCodePreconditionRequired,
+			Message: "If-Match header with the ETag of the stream is required",
+		}
+	}
+	if !matchesETag(ifMatch, mount) {
+		return preconditionFailedError("stream was changed by someone else, fetch it again")
+	}
+	return nil
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7386) to target: objects are
+// merged, null removes a member and every other value replaces the target.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// patchMount applies a merge patch to the JSON of the mount. Removed fields
+// get their zero value.
+func patchMount(mount IcecastMount, patch map[string]any) (IcecastMount, error) {
+	data, err := json.Marshal(mergePatch(auditFields(mount), patch))
+	if err != nil {
+		return IcecastMount{}, err
+	}
+
+	var patched IcecastMount
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&patched)
+	if err != nil {
+		return IcecastMount{}, jsonError(err)
+	}
+	patched.Version = mount.Version
+	return patched, nil
+}
+
+// handlePatchStream changes the fields of the stream in the JSON Merge Patch
+// body and keeps all others.
+func (s *ApiServer) handlePatchStream(w http.ResponseWriter, r *http.Request) error {
+	before, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	logRequest(r, fmt.Sprintf("Patching stream %s", before.MountName), InfoLog)
+
+	err = checkIfMatch(r, before)
+	if err != nil {
+		return err
+	}
+
+	var patch map[string]any
+	err = decodeJSON(w, r, &patch)
+	if err != nil {
+		return err
+	}
+	if name, ok := patch["mount_name"]; ok && name != before.MountName {
+		return validationError(fieldError("mount_name", "cannot be changed"))
+	}
+
+	mount, err := patchMount(before, patch)
+	if err != nil {
+		return err
+	}
+	err = mount.Validate()
+	if err != nil {
+		return err
+	}
+
+	mount, err = s.saveStream(r, before, mount, RevisionReasonUpdate)
+	if err != nil {
+		return err
+	}
+
+	setMountETag(w, mount)
+	return WriteJson(w, http.StatusOK, mount)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	target := map[string]any{"a": "b", "c": map[string]any{"d": "e", "f": "g"}}
+	patch := map[string]any{"a": "z", "c": map[string]any{"f": nil}}
+
+	merged := mergePatch(target, patch)
+	expected := map[string]any{"a": "z", "c": map[string]any{"d": "e"}}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("Expected %v, got %v", expected, merged)
+	}
+
+	if merged := mergePatch(map[string]any{"a": "b"}, []any{"c"}); !reflect.DeepEqual(merged, []any{"c"}) {
+		t.Fatalf("Expected a non-object patch to replace the target, got %v", merged)
+	}
+}
+
+func TestPatchMount(t *testing.T) {
+	initTest(t)
+
+	mount := IcecastMount{MountName: "christmas", Username: "source", Password: "secret", Public: 1, StreamName: "Christmas", Version: 3}
+	patched, err := patchMount(mount, map[string]any{"stream_name": "Xmas", "public": nil})
+	if err != nil {
+		t.Fatalf("Failed to patch mount: %v", err)
+	}
+	if patched.StreamName != "Xmas" || patched.Public != 0 || patched.Password != "secret" || patched.Version != 3 {
+		t.Fatalf("Unexpected patched mount: %+v", patched)
+	}
+
+	_, err = patchMount(mount, map[string]any{"colour": "red"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != ErrorCodeValidation {
+		t.Fatalf("Expected a validation error for an unknown field, got %v", err)
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	mount := IcecastMount{MountName: "christmas", Version: 2}
+	tests := []struct {
+		ifMatch string
+		code    string
+	}{
+		{"", ErrorCodePreconditionRequired},
+		{`"1"`, ErrorCodePreconditionFailed},
+		{`"1", "2"`, ""},
+		{"*", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/api/streams/christmas", nil)
+		if test.ifMatch != "" {
+			r.Header.Set("If-Match", test.ifMatch)
+		}
+		err := checkIfMatch(r, mount)
+		var statusErr *StatusError
+		if test.code == "" && err != nil || test.code != "" && (!errors.As(err, &statusErr) || statusErr.Code != test.code) {
+			t.Fatalf("If-Match %q: expected %q, got %v", test.ifMatch, test.code, err)
+		}
+	}
+}
+
+func TestUpdateIcecastMountVersion(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "christmas", Username: "source", Password: "secret", TemplateType: DefaultTemplate}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+
+	mount.Version = 1
+	if err := storage.UpdateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to update mount: %v", err)
+	}
+	if err := storage.UpdateIcecastMount(mount); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("Expected a version mismatch updating a stale mount, got %v", err)
+	}
+
+	stored, err := storage.GetIcecastMount("christmas")
+	if err != nil || stored.Version != 2 {
+		t.Fatalf("Expected version 2, got %d %v", stored.Version, err)
+	}
+}
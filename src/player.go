@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	playerPollSeconds  = 15
+	playerEmbedWidth   = 400
+	playerEmbedHeight  = 160
+	playerTemplateName = "player"
+)
+
+var playerTemplate = template.Must(template.New(playerTemplateName).Parse(`<!DOCTYPE html>
+<html lang="de">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Stream.StreamName}}</title>
+<style>
+  body { font-family: sans-serif; margin: 0; padding: 12px; background: #fff; color: #222; }
+  .name { font-size: 1.2em; font-weight: bold; margin: 0 0 4px; }
+  .description { margin: 0 0 8px; color: #555; }
+  .state { display: inline-block; padding: 2px 8px; border-radius: 4px; font-size: 0.8em; color: #fff; background: #888; }
+  .state.live { background: #c0392b; }
+  .title { margin: 8px 0; min-height: 1.2em; }
+  audio { width: 100%; }
+</style>
+</head>
+<body>
+  <p class="name">{{.Stream.StreamName}}</p>
+  <p class="description">{{.Stream.Description}}</p>
+  <span id="state" class="state{{if .Stream.Live}} live{{end}}">{{if .Stream.Live}}Live{{else}}Offline{{end}}</span>
+  <p id="title" class="title">{{.Stream.Title}}</p>
+  <audio id="audio" controls preload="none" src="{{.Stream.ListenURL}}"></audio>
+<script>
+  (function () {
+    var statusURL = {{.StatusURL}};
+    var state = document.getElementById("state");
+    var title = document.getElementById("title");
+    function update() {
+      fetch(statusURL, { cache: "no-store" })
+        .then(function (response) { return response.json(); })
+        .then(function (stream) {
+          state.textContent = stream.live ? "Live" : "Offline";
+          state.className = stream.live ? "state live" : "state";
+          title.textContent = stream.title || "";
+        })
+        .catch(function () {});
+    }
+    setInterval(update, {{.PollSeconds}} * 1000);
+  })();
+</script>
+</body>
+</html>
+`))
+
+type playerPage struct {
+	Stream      PublicStream
+	StatusURL   string
+	PollSeconds int
+}
+
+// getPublicMount loads the mount of the request if it may be shown without
+// authentication and is not hidden by its schedule.
+func (s *ApiServer) getPublicMount(r *http.Request) (IcecastMount, error) {
+	mount, err := s.getRequestMount(r)
+	if err != nil || !s.isListedMount(mount) {
+		return IcecastMount{}, notFoundError("stream not found")
+	}
+	return mount, nil
+}
+
+// handleGetPublicStream returns the public information and live state of one stream.
+func (s *ApiServer) handleGetPublicStream(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPublicMount(r)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, s.toPublicStream(mount))
+}
+
+// handlePlayer serves a small HTML5 player that can be embedded with an iframe.
+func (s *ApiServer) handlePlayer(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPublicMount(r)
+	if err != nil {
+		return err
+	}
+
+	page := playerPage{
+		Stream:      s.toPublicStream(mount),
+		StatusURL:   "/public/streams/" + url.PathEscape(mount.MountName) + "/status",
+		PollSeconds: playerPollSeconds,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	return playerTemplate.Execute(w, page)
+}
+
+// handlePlayerEmbed returns the iframe snippet to embed the player on another site.
+func (s *ApiServer) handlePlayerEmbed(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPublicMount(r)
+	if err != nil {
+		return err
+	}
+
+	playerURL := strings.TrimSuffix(s.config.PublicBaseURL, "/") + "/public/player/" + url.PathEscape(mount.MountName)
+	snippet := fmt.Sprintf(`<iframe src="%s" width="%d" height="%d" frameborder="0" allow="autoplay" title="%s"></iframe>`,
+		html.EscapeString(playerURL), playerEmbedWidth, playerEmbedHeight, html.EscapeString(mount.StreamName))
+
+	return WriteJson(w, http.StatusOK, map[string]any{
+		"url":    playerURL,
+		"html":   snippet,
+		"width":  playerEmbedWidth,
+		"height": playerEmbedHeight,
+	})
+}
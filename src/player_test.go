@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlayer(t *testing.T) {
+	initTest(t)
+
+	dir := t.TempDir()
+	s, err := StreamAPI("", Config{
+		IcecastMountsFolder: dir,
+		DbFile:              filepath.Join(dir, "test.db"),
+		PublicBaseURL:       "https://api.example.org/",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	streamName := `<script>alert("Ostern")</script> & Co`
+	mounts := []IcecastMount{
+		{MountName: "ostern", Public: 1, TemplateType: DefaultTemplate, StreamName: streamName},
+		{MountName: "pfingsten", Public: 0, TemplateType: DefaultTemplate},
+		{MountName: "advent", Public: 1, TemplateType: PrivateTemplate},
+	}
+	for _, mount := range mounts {
+		mount.Username, mount.Password = "source", "secret"
+		if err := s.storage.CreateIcecastMount(mount); err != nil {
+			t.Fatalf("Failed to create mount: %v", err)
+		}
+	}
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	for _, path := range []string{
+		"/public/player/pfingsten",
+		"/public/player/advent",
+		"/public/player/unbekannt",
+		"/public/player/pfingsten/embed",
+		"/public/player/advent/embed",
+		"/public/player/unbekannt/embed",
+	} {
+		if status, _ := get(path); status != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", path, status)
+		}
+	}
+
+	status, page := get("/public/player/ostern")
+	if status != http.StatusOK {
+		t.Fatalf("Expected the player of a public stream, got %d", status)
+	}
+	if strings.Contains(page, streamName) || !strings.Contains(page, "&lt;script&gt;alert(&#34;Ostern&#34;)&lt;/script&gt; &amp; Co") {
+		t.Fatalf("Expected the stream name to be escaped, got %s", page)
+	}
+
+	status, body := get("/public/player/ostern/embed")
+	var embed struct {
+		URL  string `json:"url"`
+		HTML string `json:"html"`
+	}
+	if err := json.Unmarshal([]byte(body), &embed); err != nil || status != http.StatusOK {
+		t.Fatalf("Failed to get the embed snippet: %d %v", status, err)
+	}
+	if embed.URL != "https://api.example.org/public/player/ostern" {
+		t.Fatalf("Unexpected player URL %s", embed.URL)
+	}
+	if strings.Contains(embed.HTML, streamName) || !strings.Contains(embed.HTML, `title="&lt;script&gt;alert(&#34;Ostern&#34;)&lt;/script&gt; &amp; Co"`) {
+		t.Fatalf("Expected the stream name to be escaped, got %s", embed.HTML)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metadataLookbehind also takes the title into account that was set shortly
+// before a recording started.
+const metadataLookbehind = 2 * time.Minute
+
+type podcastRSS struct {
+	XMLName  xml.Name       `xml:"rss"`
+	Version  string         `xml:"version,attr"`
+	ItunesNS string         `xml:"xmlns:itunes,attr"`
+	AtomNS   string         `xml:"xmlns:atom,attr"`
+	Channel  podcastChannel `xml:"channel"`
+}
+
+type podcastChannel struct {
+	Title          string          `xml:"title"`
+	Link           string          `xml:"link"`
+	AtomLink       podcastAtomLink `xml:"atom:link"`
+	Description    string          `xml:"description"`
+	Language       string          `xml:"language,omitempty"`
+	Generator      string          `xml:"generator"`
+	LastBuildDate  string          `xml:"lastBuildDate"`
+	ItunesAuthor   string          `xml:"itunes:author"`
+	ItunesSummary  string          `xml:"itunes:summary"`
+	ItunesExplicit string          `xml:"itunes:explicit"`
+	ItunesImage    *podcastImage   `xml:"itunes:image,omitempty"`
+	Items          []podcastItem   `xml:"item"`
+}
+
+type podcastAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type podcastImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type podcastItem struct {
+	Title          string           `xml:"title"`
+	Description    string           `xml:"description"`
+	PubDate        string           `xml:"pubDate"`
+	GUID           podcastGUID      `xml:"guid"`
+	Enclosure      podcastEnclosure `xml:"enclosure"`
+	ItunesDuration string           `xml:"itunes:duration,omitempty"`
+	ItunesSummary  string           `xml:"itunes:summary"`
+}
+
+type podcastGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type podcastEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func formatPodcastDuration(seconds int) string {
+	if seconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+}
+
+func getRecordingContentType(fileName string) string {
+	contentType := mime.TypeByExtension(filepath.Ext(fileName))
+	if contentType == "" {
+		return "audio/mpeg"
+	}
+	return contentType
+}
+
+// episodeTitles returns the titles that were played during the recording.
+func episodeTitles(recording Recording, history []MetadataEntry) []string {
+	titles := []string{}
+	for i, entry := range history {
+		// Of the titles before the start only the last one was still playing.
+		if entry.ObservedAt.Before(recording.StartedAt) && i+1 < len(history) && !history[i+1].ObservedAt.After(recording.StartedAt) {
+			continue
+		}
+		if len(titles) > 0 && titles[len(titles)-1] == entry.Title {
+			continue
+		}
+		titles = append(titles, entry.Title)
+	}
+	return titles
+}
+
+// buildPodcastFeed renders the recordings of a mount as RSS 2.0 feed with iTunes tags.
+func (s *ApiServer) buildPodcastFeed(mount IcecastMount, recordings []Recording) ([]byte, error) {
+	baseURL := strings.TrimSuffix(s.config.PublicBaseURL, "/")
+	streamURL := baseURL + "/public/streams/" + url.PathEscape(mount.MountName)
+	author := s.config.PodcastAuthor
+	if author == "" {
+		author = mount.StreamName
+	}
+
+	loc, err := time.LoadLocation(s.config.DefaultTimezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	channel := podcastChannel{
+		Title:          mount.StreamName,
+		Link:           streamURL,
+		AtomLink:       podcastAtomLink{Href: streamURL + "/podcast.xml", Rel: "self", Type: "application/rss+xml"},
+		Description:    mount.StreamDescription,
+		Generator:      getApplicationName() + " " + getVersion(),
+		LastBuildDate:  time.Now().Format(time.RFC1123Z),
+		ItunesAuthor:   author,
+		ItunesSummary:  mount.StreamDescription,
+		ItunesExplicit: "false",
+		Items:          []podcastItem{},
+	}
+	if s.config.PodcastImageURL != "" {
+		channel.ItunesImage = &podcastImage{Href: s.config.PodcastImageURL}
+	}
+
+	for _, recording := range recordings {
+		history, err := s.storage.GetMetadataHistory(mount.MountName, recording.StartedAt.Add(-metadataLookbehind), recording.EndedAt)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Database error fetching metadata history: %s %s", mount.MountName, err), WarnLog)
+			return nil, databaseError(err, "metadata")
+		}
+		titles := episodeTitles(recording, history)
+
+		title := mount.StreamName + " " + recording.StartedAt.In(loc).Format("02.01.2006 15:04")
+		if len(titles) > 0 {
+			title = titles[0]
+		}
+		description := mount.StreamDescription
+		if len(titles) > 1 {
+			description = strings.Join(titles, "\n")
+		}
+
+		channel.Items = append(channel.Items, podcastItem{
+			Title:       title,
+			Description: description,
+			PubDate:     recording.StartedAt.In(loc).Format(time.RFC1123Z),
+			GUID:        podcastGUID{IsPermaLink: "false", Value: mount.MountName + "/" + recording.FileName},
+			Enclosure: podcastEnclosure{
+				URL:    fmt.Sprintf("%s/episodes/%d/%s", streamURL, recording.ID, url.PathEscape(recording.FileName)),
+				Length: recording.Size,
+				Type:   getRecordingContentType(recording.FileName),
+			},
+			ItunesDuration: formatPodcastDuration(recording.DurationSeconds),
+			ItunesSummary:  description,
+		})
+	}
+
+	feed := podcastRSS{
+		Version:  "2.0",
+		ItunesNS: "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		AtomNS:   "http://www.w3.org/2005/Atom",
+		Channel:  channel,
+	}
+	output, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
+
+// getPodcastMount loads the mount of the request if it is public and
+// publishes a podcast. Private mounts are not found, like in the directory.
+func (s *ApiServer) getPodcastMount(r *http.Request) (IcecastMount, error) {
+	mount, err := s.getRequestMount(r)
+	if err != nil || !mount.Podcast || !isPublicMount(mount) {
+		return IcecastMount{}, notFoundError("podcast not found")
+	}
+	return mount, nil
+}
+
+func (s *ApiServer) handlePodcastFeed(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPodcastMount(r)
+	if err != nil {
+		return err
+	}
+
+	// The feed is public, it is served from the index the indexer keeps up to date.
+	recordings, err := s.storage.GetRecordings(mount.MountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching recordings: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "recording")
+	}
+
+	feed, err := s.buildPodcastFeed(mount, recordings)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(feed)
+	return err
+}
+
+// handlePodcastEpisode serves the audio file of an episode with range support.
+func (s *ApiServer) handlePodcastEpisode(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getPodcastMount(r)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(r.PathValue("recordingID"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid episode id")
+	}
+	recording, err := s.storage.GetRecording(mount.MountName, id)
+	if err != nil {
+		return notFoundError("episode not found")
+	}
+
+	file, err := os.Open(s.recordings.filePath(recording))
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Error opening recording file: %s %s", recording.FileName, err), WarnLog)
+		return internalError("file error")
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", getRecordingContentType(recording.FileName))
+	http.ServeContent(w, r, recording.FileName, recording.EndedAt, file)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetPodcastMount(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+
+	mounts := []IcecastMount{
+		{MountName: "ostern", Public: 1, TemplateType: DefaultTemplate, Podcast: true},
+		{MountName: "pfingsten", Public: 0, TemplateType: DefaultTemplate, Podcast: true},
+		{MountName: "advent", Public: 1, TemplateType: PrivateTemplate, Podcast: true},
+		{MountName: "weihnachten", Public: 1, TemplateType: DefaultTemplate},
+	}
+	for _, mount := range mounts {
+		mount.Username, mount.Password = "source", "secret"
+		if err := storage.CreateIcecastMount(mount); err != nil {
+			t.Fatalf("Failed to create mount: %v", err)
+		}
+	}
+
+	tests := []struct {
+		mountName string
+		found     bool
+	}{
+		{"ostern", true},
+		{"pfingsten", false},
+		{"advent", false},
+		{"weihnachten", false},
+		{"unbekannt", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/public/streams/"+test.mountName+"/podcast.xml", nil)
+		r.SetPathValue("streamName", test.mountName)
+		_, err := s.getPodcastMount(r)
+		if test.found != (err == nil) {
+			t.Errorf("Expected podcast of %s found %t, got %v", test.mountName, test.found, err)
+		}
+		if !test.found {
+			status, _ := apiErrorResponse(err)
+			if status != http.StatusNotFound {
+				t.Errorf("Expected 404 for %s, got %d", test.mountName, status)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PublicStream is the information about a public mount that anyone may see.
+type PublicStream struct {
+	MountName   string `json:"mount_name"`
+	StreamName  string `json:"stream_name"`
+	Description string `json:"stream_description"`
+	ListenURL   string `json:"listen_url"`
+	Live        bool   `json:"live"`
+	Listeners   int    `json:"listeners"`
+	Title       string `json:"title,omitempty"`
+}
+
+type playlistFormat struct {
+	contentType string
+	render      func(stream PublicStream) ([]byte, error)
+}
+
+var playlistFormats = map[string]playlistFormat{
+	".m3u":  {contentType: "audio/x-mpegurl", render: renderM3U},
+	".pls":  {contentType: "audio/x-scpls", render: renderPLS},
+	".xspf": {contentType: "application/xspf+xml", render: renderXSPF},
+}
+
+func renderM3U(stream PublicStream) ([]byte, error) {
+	return []byte(fmt.Sprintf("#EXTM3U\n#EXTINF:-1,%s\n%s\n", stream.StreamName, stream.ListenURL)), nil
+}
+
+func renderPLS(stream PublicStream) ([]byte, error) {
+	return []byte(fmt.Sprintf("[playlist]\nNumberOfEntries=1\nFile1=%s\nTitle1=%s\nLength1=-1\nVersion=2\n", stream.ListenURL, stream.StreamName)), nil
+}
+
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"playlist"`
+	Version   string      `xml:"version,attr"`
+	Namespace string      `xml:"xmlns,attr"`
+	Title     string      `xml:"title"`
+	Tracks    []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Title      string `xml:"title"`
+	Annotation string `xml:"annotation,omitempty"`
+}
+
+func renderXSPF(stream PublicStream) ([]byte, error) {
+	playlist := xspfPlaylist{
+		Version:   "1",
+		Namespace: "http://xspf.org/ns/0/",
+		Title:     stream.StreamName,
+		Tracks: []xspfTrack{
+			{Location: stream.ListenURL, Title: stream.StreamName, Annotation: stream.Description},
+		},
+	}
+	output, err := xml.MarshalIndent(playlist, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
+
+// isPublicMount reports whether a mount may be shown without authentication.
+func isPublicMount(mount IcecastMount) bool {
+	return mount.Public == 1 && mount.TemplateType != PrivateTemplate
+}
+
+// isListedMount reports whether a public mount is listed right now. Mounts
+// whose schedule hides or removes them are left out until the next window.
+func (s *ApiServer) isListedMount(mount IcecastMount) bool {
+	return isPublicMount(mount) && s.scheduler.IsLive(mount.MountName)
+}
+
+func (s *ApiServer) getListenURL(mountName string) string {
+	return strings.TrimSuffix(s.config.IcecastPublicURL, "/") + "/" + url.PathEscape(mountName)
+}
+
+func (s *ApiServer) toPublicStream(mount IcecastMount) PublicStream {
+	status := s.icecastStatus.Status(mount.MountName)
+	return PublicStream{
+		MountName:   mount.MountName,
+		StreamName:  mount.StreamName,
+		Description: mount.StreamDescription,
+		ListenURL:   s.getListenURL(mount.MountName),
+		Live:        status.Live,
+		Listeners:   status.Listeners,
+		Title:       status.Title,
+	}
+}
+
+// handleGetPublicStreams lists all public mounts with their live state.
+func (s *ApiServer) handleGetPublicStreams(w http.ResponseWriter, r *http.Request) error {
+	mounts, err := s.storage.GetIcecastMounts()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching icecast mounts: %s", err), WarnLog)
+		return databaseError(err, "stream")
+	}
+
+	streams := []PublicStream{}
+	for _, mount := range mounts {
+		if !s.isListedMount(mount) {
+			continue
+		}
+		streams = append(streams, s.toPublicStream(mount))
+	}
+
+	return WriteJson(w, http.StatusOK, streams)
+}
+
+// handleGetPlaylist serves /public/streams/{name}.m3u, .pls and .xspf.
+func (s *ApiServer) handleGetPlaylist(w http.ResponseWriter, r *http.Request) error {
+	fileName := r.PathValue("playlist")
+	for ext, format := range playlistFormats {
+		if !strings.HasSuffix(fileName, ext) {
+			continue
+		}
+		mountName := strings.TrimSuffix(fileName, ext)
+		mount, err := s.storage.GetIcecastMount(mountName)
+		if err != nil || !s.isListedMount(mount) {
+			return notFoundError("stream not found")
+		}
+
+		playlist, err := format.render(s.toPublicStream(mount))
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", fileName))
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(playlist)
+		return err
+	}
+	return fmt.Errorf("unsupported playlist format")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsPublicMount(t *testing.T) {
+	tests := []struct {
+		mount  IcecastMount
+		public bool
+	}{
+		{IcecastMount{Public: 1, TemplateType: DefaultTemplate}, true},
+		{IcecastMount{Public: 0, TemplateType: DefaultTemplate}, false},
+		{IcecastMount{Public: 1, TemplateType: PrivateTemplate}, false},
+		{IcecastMount{Public: 0, TemplateType: PrivateTemplate}, false},
+	}
+	for _, test := range tests {
+		if public := isPublicMount(test.mount); public != test.public {
+			t.Errorf("Expected public %t for %+v, got %t", test.public, test.mount, public)
+		}
+	}
+}
+
+func TestRenderPlaylists(t *testing.T) {
+	stream := PublicStream{MountName: "ostern", StreamName: "Ostern", ListenURL: "https://radio.example.org/ostern"}
+	tests := []struct {
+		render   func(PublicStream) ([]byte, error)
+		expected string
+	}{
+		{renderM3U, "#EXTM3U\n#EXTINF:-1,Ostern\nhttps://radio.example.org/ostern\n"},
+		{renderPLS, "[playlist]\nNumberOfEntries=1\nFile1=https://radio.example.org/ostern\nTitle1=Ostern\nLength1=-1\nVersion=2\n"},
+	}
+	for _, test := range tests {
+		playlist, err := test.render(stream)
+		if err != nil || string(playlist) != test.expected {
+			t.Errorf("Expected playlist %q, got %q %v", test.expected, playlist, err)
+		}
+	}
+}
+
+func TestPublicStreams(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	config := Config{IcecastPublicURL: "https://radio.example.org/"}
+	events := NewEventBroker()
+	s := &ApiServer{
+		storage:       storage,
+		config:        config,
+		icecastStatus: NewIcecastStatusPoller(config, storage, events),
+		scheduler:     NewStreamScheduler(storage, NewIcecastConfig(config), events),
+	}
+
+	mounts := []IcecastMount{
+		{MountName: "ostern", Public: 1, TemplateType: DefaultTemplate, StreamName: "Ostern"},
+		{MountName: "pfingsten", Public: 0, TemplateType: DefaultTemplate},
+		{MountName: "advent", Public: 1, TemplateType: PrivateTemplate},
+		{MountName: "weihnachten", Public: 1, TemplateType: DefaultTemplate},
+	}
+	for _, mount := range mounts {
+		mount.Username, mount.Password = "source", "secret"
+		if err := storage.CreateIcecastMount(mount); err != nil {
+			t.Fatalf("Failed to create mount: %v", err)
+		}
+	}
+
+	// weihnachten is outside the window of its schedule
+	s.scheduler.applied["weihnachten"] = false
+
+	w := httptest.NewRecorder()
+	if err := s.handleGetPublicStreams(w, httptest.NewRequest("GET", "/public/streams", nil)); err != nil {
+		t.Fatalf("Failed to list public streams: %v", err)
+	}
+	var streams []PublicStream
+	if err := json.Unmarshal(w.Body.Bytes(), &streams); err != nil {
+		t.Fatalf("Failed to decode public streams: %v", err)
+	}
+	if len(streams) != 1 || streams[0].MountName != "ostern" || streams[0].ListenURL != "https://radio.example.org/ostern" {
+		t.Fatalf("Expected only the public stream, got %+v", streams)
+	}
+
+	tests := []struct {
+		playlist string
+		status   int
+	}{
+		{"ostern.m3u", http.StatusOK},
+		{"ostern.pls", http.StatusOK},
+		{"pfingsten.m3u", http.StatusNotFound},
+		{"advent.pls", http.StatusNotFound},
+		{"weihnachten.m3u", http.StatusNotFound},
+		{"unbekannt.m3u", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/public/streams/"+test.playlist, nil)
+		r.SetPathValue("playlist", test.playlist)
+		status := http.StatusOK
+		if err := s.handleGetPlaylist(httptest.NewRecorder(), r); err != nil {
+			status, _ = apiErrorResponse(err)
+		}
+		if status != test.status {
+			t.Errorf("Expected %d for %s, got %d", test.status, test.playlist, status)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const recordingColumns = `id, mount_name, file_name, size, started_at, ended_at, duration_seconds`
+
+func scanRecording(scanner interface{ Scan(...any) error }) (Recording, error) {
+	var recording Recording
+	err := scanner.Scan(&recording.ID, &recording.MountName, &recording.FileName, &recording.Size,
+		&recording.StartedAt, &recording.EndedAt, &recording.DurationSeconds)
+	return recording, err
+}
+
+// SaveRecording inserts a recording or updates the entry of the same file.
+func (s *SqliteStorage) SaveRecording(recording Recording) error {
+	defer observeQuery("SaveRecording", time.Now())
+	logWithCaller(fmt.Sprintf("Saving recording %s for mount: %s", recording.FileName, recording.MountName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	INSERT INTO recordings (mount_name, file_name, size, started_at, ended_at, duration_seconds)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT(file_name) DO UPDATE SET
+		mount_name = excluded.mount_name,
+		size = excluded.size,
+		started_at = excluded.started_at,
+		ended_at = excluded.ended_at,
+		duration_seconds = excluded.duration_seconds
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(recording.MountName, recording.FileName, recording.Size, recording.StartedAt, recording.EndedAt, recording.DurationSeconds)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return nil
+}
+
+func (s *SqliteStorage) GetRecording(mountName string, id int64) (Recording, error) {
+	defer observeQuery("GetRecording", time.Now())
+	logWithCaller(fmt.Sprintf("Getting recording %d for mount: %s", id, mountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + recordingColumns + `
+	FROM recordings
+	WHERE mount_name = $1 AND id = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return Recording{}, err
+	}
+	defer stmt.Close()
+
+	recording, err := scanRecording(stmt.QueryRow(mountName, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logWithCaller(fmt.Sprintf("No recording %d for mount: %s", id, mountName), DebugLog)
+		}
+		return Recording{}, err
+	}
+	return recording, nil
+}
+
+// GetRecordings returns the recordings of a mount, newest first.
+func (s *SqliteStorage) GetRecordings(mountName string) ([]Recording, error) {
+	defer observeQuery("GetRecordings", time.Now())
+	logWithCaller(fmt.Sprintf("Getting recordings for mount: %s", mountName), InfoLog)
+	return s.queryRecordings(`
+	SELECT `+recordingColumns+`
+	FROM recordings
+	WHERE mount_name = $1
+	ORDER BY started_at DESC
+	`, mountName)
+}
+
+func (s *SqliteStorage) GetAllRecordings() ([]Recording, error) {
+	defer observeQuery("GetAllRecordings", time.Now())
+	logWithCaller("Getting all recordings", DebugLog)
+	return s.queryRecordings(`
+	SELECT ` + recordingColumns + `
+	FROM recordings
+	`)
+}
+
+func (s *SqliteStorage) queryRecordings(query string, args ...any) ([]Recording, error) {
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	recordings := []Recording{}
+	for rows.Next() {
+		recording, err := scanRecording(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		recordings = append(recordings, recording)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return recordings, nil
+}
+
+func (s *SqliteStorage) DeleteRecording(id int64) error {
+	defer observeQuery("DeleteRecording", time.Now())
+	logWithCaller(fmt.Sprintf("Deleting recording %d", id), InfoLog)
+	stmt, err := s.db.Prepare(`
+	DELETE FROM recordings
+	WHERE id = $1
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(id)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return nil
+}
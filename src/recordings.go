@@ -0,0 +1,480 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordingIndexInterval = 5 * time.Minute
+	recordingRefreshMinAge = time.Minute
+	defaultRecordingExt    = ".mp3"
+	defaultRecordingSuffix = "-%Y%m%d-%H%M%S"
+)
+
+// Recording is a dump file of a mount found in the recordings folder.
+type Recording struct {
+	ID              int64     `json:"id"`
+	MountName       string    `json:"mount_name"`
+	FileName        string    `json:"file_name"`
+	Size            int64     `json:"size"`
+	StartedAt       time.Time `json:"started_at"`
+	EndedAt         time.Time `json:"ended_at"`
+	DurationSeconds int       `json:"duration_seconds"`
+}
+
+// getRecordingPattern returns the dump file name of the mount. Without pattern
+// the mount name and the start time are used.
+func getRecordingPattern(mount IcecastMount) string {
+	if mount.RecordingPattern != "" {
+		return mount.RecordingPattern
+	}
+	ext := filepath.Ext(mount.MountName)
+	if ext == "" {
+		ext = defaultRecordingExt
+	}
+	return strings.TrimSuffix(mount.MountName, ext) + defaultRecordingSuffix + ext
+}
+
+// validRecordingPattern makes sure recordings stay inside the recordings folder.
+func validRecordingPattern(pattern string) bool {
+	return !strings.ContainsAny(pattern, "/\\\n<>&") && !strings.Contains(pattern, "..")
+}
+
+// strftimeDirectives maps the supported strftime directives to regular expressions.
+var strftimeDirectives = map[byte]string{
+	'Y': `(?P<Y>\d{4})`,
+	'y': `(?P<y>\d{2})`,
+	'm': `(?P<m>\d{2})`,
+	'd': `(?P<d>\d{2})`,
+	'H': `(?P<H>\d{2})`,
+	'M': `(?P<M>\d{2})`,
+	'S': `(?P<S>\d{2})`,
+	'j': `\d{3}`,
+	's': `\d+`,
+}
+
+// recordingMatcher recognizes the dump files of one mount and reads the start
+// time from the file name.
+type recordingMatcher struct {
+	mountName string
+	regex     *regexp.Regexp
+}
+
+func newRecordingMatcher(mount IcecastMount) (*recordingMatcher, error) {
+	pattern := getRecordingPattern(mount)
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			expr.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			continue
+		}
+		i++
+		if pattern[i] == '%' {
+			expr.WriteString("%")
+			continue
+		}
+		directive, ok := strftimeDirectives[pattern[i]]
+		if !ok {
+			directive = `.+?`
+		}
+		if strings.Contains(expr.String(), "(?P<"+string(pattern[i])+">") {
+			// Repeated directives must not reuse the group name.
+			directive = `\d+`
+		}
+		expr.WriteString(directive)
+	}
+	expr.WriteString("$")
+
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	return &recordingMatcher{mountName: mount.MountName, regex: regex}, nil
+}
+
+// match reports whether the file belongs to the mount and returns the start time if the name contains one.
+func (m *recordingMatcher) match(fileName string, loc *time.Location) (bool, time.Time) {
+	groups := m.regex.FindStringSubmatch(fileName)
+	if groups == nil {
+		return false, time.Time{}
+	}
+
+	values := map[string]int{}
+	for i, name := range m.regex.SubexpNames() {
+		if name == "" || groups[i] == "" {
+			continue
+		}
+		value, err := strconv.Atoi(groups[i])
+		if err == nil {
+			values[name] = value
+		}
+	}
+	year, ok := values["Y"]
+	if !ok {
+		if short, found := values["y"]; found {
+			year, ok = 2000+short, true
+		}
+	}
+	if !ok || values["m"] == 0 || values["d"] == 0 {
+		return true, time.Time{}
+	}
+	return true, time.Date(year, time.Month(values["m"]), values["d"], values["H"], values["M"], values["S"], 0, loc)
+}
+
+// mp3Bitrates are the Layer III bitrates in kbit/s for MPEG 1 and MPEG 2/2.5.
+var mp3Bitrates = [2][15]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// estimateDuration estimates the duration of a constant bitrate MP3 file from
+// its first frame header. Other formats return 0.
+func estimateDuration(filePath string, size int64) int {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	header := make([]byte, 64*1024)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0
+	}
+	header = header[:n]
+
+	for i := 0; i+3 < len(header); i++ {
+		if header[i] != 0xFF || header[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+		version := (header[i+1] >> 3) & 0x03
+		layer := (header[i+1] >> 1) & 0x03
+		bitrateIndex := header[i+2] >> 4
+		if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 {
+			continue
+		}
+		table := 1
+		if version == 3 {
+			table = 0
+		}
+		bitrate := mp3Bitrates[table][bitrateIndex]
+		return int(size * 8 / int64(bitrate*1000))
+	}
+	return 0
+}
+
+// RecordingIndexer keeps the recordings table in sync with the recordings
+// folder and deletes recordings after their retention.
+type RecordingIndexer struct {
+	storage Store
+	config  Config
+	mu      sync.Mutex
+	// refreshedAt is the end of the last successful refresh.
+	refreshedAt time.Time
+}
+
+func NewRecordingIndexer(storage Store, config Config) *RecordingIndexer {
+	return &RecordingIndexer{
+		storage: storage,
+		config:  config,
+	}
+}
+
+// Run refreshes the index and applies the retention every interval. It never returns.
+func (ri *RecordingIndexer) Run(interval time.Duration) {
+	if ri.config.RecordingsFolder == "" {
+		logWithCaller("No recordings folder configured, recording index disabled", InfoLog)
+		return
+	}
+	logWithCaller(fmt.Sprintf("Starting recording indexer with interval %s", interval), InfoLog)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := ri.Refresh()
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error indexing recordings: %s", err), WarnLog)
+		}
+		err = ri.ApplyRetention(time.Now())
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error applying recording retention: %s", err), WarnLog)
+		}
+		<-ticker.C
+	}
+}
+
+// Refresh indexes new and changed files and drops files that are gone.
+func (ri *RecordingIndexer) Refresh() error {
+	if ri.config.RecordingsFolder == "" {
+		return nil
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	return ri.refresh()
+}
+
+// RefreshIfStale refreshes the index unless the last refresh was less than
+// recordingRefreshMinAge ago, so requests can not keep the indexer busy.
+func (ri *RecordingIndexer) RefreshIfStale() error {
+	if ri.config.RecordingsFolder == "" {
+		return nil
+	}
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	if time.Since(ri.refreshedAt) < recordingRefreshMinAge {
+		return nil
+	}
+	return ri.refresh()
+}
+
+func (ri *RecordingIndexer) refresh() error {
+	entries, err := os.ReadDir(ri.config.RecordingsFolder)
+	if err != nil {
+		return err
+	}
+	mounts, err := ri.storage.GetIcecastMounts()
+	if err != nil {
+		return err
+	}
+	known, err := ri.storage.GetAllRecordings()
+	if err != nil {
+		return err
+	}
+
+	matchers := []*recordingMatcher{}
+	for _, mount := range mounts {
+		matcher, err := newRecordingMatcher(mount)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Invalid recording pattern for mount %s: %s", mount.MountName, err), WarnLog)
+			continue
+		}
+		matchers = append(matchers, matcher)
+	}
+	loc, err := time.LoadLocation(ri.config.DefaultTimezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	knownByFile := make(map[string]Recording)
+	for _, recording := range known {
+		knownByFile[recording.FileName] = recording
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		seen[entry.Name()] = true
+
+		existing, isKnown := knownByFile[entry.Name()]
+		if isKnown && existing.Size == info.Size() && existing.EndedAt.Equal(info.ModTime().UTC()) {
+			continue
+		}
+
+		for _, matcher := range matchers {
+			matches, startedAt := matcher.match(entry.Name(), loc)
+			if !matches {
+				continue
+			}
+			filePath := filepath.Join(ri.config.RecordingsFolder, entry.Name())
+			recording := Recording{
+				MountName:       matcher.mountName,
+				FileName:        entry.Name(),
+				Size:            info.Size(),
+				EndedAt:         info.ModTime().UTC(),
+				DurationSeconds: estimateDuration(filePath, info.Size()),
+			}
+			if startedAt.IsZero() {
+				startedAt = recording.EndedAt.Add(-time.Duration(recording.DurationSeconds) * time.Second)
+			}
+			recording.StartedAt = startedAt.UTC()
+
+			err = ri.storage.SaveRecording(recording)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	for _, recording := range known {
+		if seen[recording.FileName] {
+			continue
+		}
+		logWithCaller(fmt.Sprintf("Recording %s is gone, removing it from the index", recording.FileName), InfoLog)
+		err = ri.storage.DeleteRecording(recording.ID)
+		if err != nil {
+			return err
+		}
+	}
+	ri.refreshedAt = time.Now()
+	return nil
+}
+
+// ApplyRetention deletes recordings that ended longer ago than the retention
+// of their mount allows.
+func (ri *RecordingIndexer) ApplyRetention(now time.Time) error {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	mounts, err := ri.storage.GetIcecastMounts()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		retentionDays := mount.RecordingRetentionDays
+		if retentionDays == 0 {
+			retentionDays = ri.config.RecordingRetentionDays
+		}
+		if retentionDays <= 0 {
+			continue
+		}
+
+		recordings, err := ri.storage.GetRecordings(mount.MountName)
+		if err != nil {
+			return err
+		}
+		cutoff := now.AddDate(0, 0, -retentionDays)
+		for _, recording := range recordings {
+			if recording.EndedAt.After(cutoff) {
+				continue
+			}
+			logWithCaller(fmt.Sprintf("Recording %s is older than %d days, deleting it", recording.FileName, retentionDays), InfoLog)
+			err = ri.delete(recording)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ri *RecordingIndexer) filePath(recording Recording) string {
+	return filepath.Join(ri.config.RecordingsFolder, filepath.Base(recording.FileName))
+}
+
+// Delete removes the file and the index entry of a recording. It holds the
+// lock so a concurrent refresh can not index the file again.
+func (ri *RecordingIndexer) Delete(recording Recording) error {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	return ri.delete(recording)
+}
+
+func (ri *RecordingIndexer) delete(recording Recording) error {
+	err := os.Remove(ri.filePath(recording))
+	if err != nil && !os.IsNotExist(err) {
+		logWithCaller(fmt.Sprintf("Error deleting recording file: %s", err), FatalLog)
+		return fmt.Errorf("error deleting recording file: %s", err)
+	}
+	return ri.storage.DeleteRecording(recording.ID)
+}
+
+// ###########
+// Recording routes
+// ###########
+
+func (s *ApiServer) addRecordingRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/recordings", makeHTTPHandleFunc(s.handleGetRecordings))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/recordings", "get_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/recordings/{recordingID}", makeHTTPHandleFunc(s.handleDownloadRecording))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/recordings/{recordingID}", "get_stream")
+
+	autherizedRouter.HandleFunc("DELETE "+autherized+"streams/{streamName}/recordings/{recordingID}", makeHTTPHandleFunc(s.handleDeleteRecording))
+	addToRouteRightsMap("DELETE "+autherized+"streams/{streamName}/recordings/{recordingID}", "delete_stream")
+}
+
+func (s *ApiServer) getRequestRecording(r *http.Request) (Recording, error) {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return Recording{}, err
+	}
+	id, err := strconv.ParseInt(r.PathValue("recordingID"), 10, 64)
+	if err != nil {
+		return Recording{}, fmt.Errorf("invalid recording id")
+	}
+
+	recording, err := s.storage.GetRecording(mount.MountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching recording: %s %d %s", mount.MountName, id, err), WarnLog)
+		return Recording{}, databaseError(err, "recording")
+	}
+	return recording, nil
+}
+
+func (s *ApiServer) handleGetRecordings(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+
+	err = s.recordings.RefreshIfStale()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Error indexing recordings: %s", err), WarnLog)
+		return internalError("file error")
+	}
+
+	recordings, err := s.storage.GetRecordings(mount.MountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching recordings: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "recording")
+	}
+
+	return WriteJson(w, http.StatusOK, recordings)
+}
+
+// handleDownloadRecording serves the file of a recording. Range requests are
+// supported, so players can seek and downloads can be resumed.
+func (s *ApiServer) handleDownloadRecording(w http.ResponseWriter, r *http.Request) error {
+	recording, err := s.getRequestRecording(r)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.recordings.filePath(recording))
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Error opening recording file: %s %s", recording.FileName, err), WarnLog)
+		return internalError("file error")
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return internalError("file error")
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", recording.FileName))
+	http.ServeContent(w, r, recording.FileName, info.ModTime(), file)
+	return nil
+}
+
+func (s *ApiServer) handleDeleteRecording(w http.ResponseWriter, r *http.Request) error {
+	recording, err := s.getRequestRecording(r)
+	if err != nil {
+		return err
+	}
+
+	err = s.recordings.Delete(recording)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Error deleting recording: %s %s", recording.FileName, err), WarnLog)
+		return internalError("file error")
+	}
+
+	return WriteJson(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
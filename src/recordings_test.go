@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordingMatcher(t *testing.T) {
+	tests := []struct {
+		mount     IcecastMount
+		fileName  string
+		matches   bool
+		startedAt time.Time
+	}{
+		{IcecastMount{MountName: "ostern"}, "ostern-20260405-100000.mp3", true, time.Date(2026, 4, 5, 10, 0, 0, 0, time.UTC)},
+		{IcecastMount{MountName: "ostern.ogg"}, "ostern-20260405-103015.ogg", true, time.Date(2026, 4, 5, 10, 30, 15, 0, time.UTC)},
+		{IcecastMount{MountName: "ostern"}, "ostern-20260405-100000.ogg", false, time.Time{}},
+		{IcecastMount{MountName: "ostern"}, "pfingsten-20260524-100000.mp3", false, time.Time{}},
+		{IcecastMount{MountName: "ostern", RecordingPattern: "messe-%y.%m.%d_%H%M.mp3"}, "messe-26.04.05_0930.mp3", true, time.Date(2026, 4, 5, 9, 30, 0, 0, time.UTC)},
+		{IcecastMount{MountName: "ostern", RecordingPattern: "messe-%s.mp3"}, "messe-1775383200.mp3", true, time.Time{}},
+		{IcecastMount{MountName: "ostern", RecordingPattern: "messe-%Y-%Y.mp3"}, "messe-2026-2026.mp3", true, time.Time{}},
+		{IcecastMount{MountName: "ostern", RecordingPattern: "messe (%Y).mp3"}, "messe (2026).mp3", true, time.Time{}},
+		{IcecastMount{MountName: "ostern", RecordingPattern: "messe (%Y).mp3"}, "messe 2026.mp3", false, time.Time{}},
+	}
+	for _, test := range tests {
+		matcher, err := newRecordingMatcher(test.mount)
+		if err != nil {
+			t.Fatalf("Failed to create matcher for %s: %v", getRecordingPattern(test.mount), err)
+		}
+		matches, startedAt := matcher.match(test.fileName, time.UTC)
+		if matches != test.matches || !startedAt.Equal(test.startedAt) {
+			t.Errorf("Expected %s to match %s %t at %s, got %t at %s", test.fileName, getRecordingPattern(test.mount), test.matches, test.startedAt, matches, startedAt)
+		}
+	}
+}
+
+func TestEstimateDuration(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		header   []byte
+		size     int64
+		duration int
+	}{
+		// MPEG 1 Layer III, 128 kbit/s
+		{"mpeg1.mp3", []byte{0xFF, 0xFB, 0x90, 0x00}, 1600000, 100},
+		// MPEG 2 Layer III, 64 kbit/s, after an ID3 tag
+		{"mpeg2.mp3", []byte{'I', 'D', '3', 0x00, 0xFF, 0xF3, 0x80, 0x00}, 800000, 100},
+		{"audio.ogg", []byte("OggS\x00\x02"), 800000, 0},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, test.header, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", test.name, err)
+		}
+		if duration := estimateDuration(path, test.size); duration != test.duration {
+			t.Errorf("Expected %s to last %d seconds, got %d", test.name, test.duration, duration)
+		}
+	}
+	if duration := estimateDuration(filepath.Join(dir, "missing.mp3"), 1000); duration != 0 {
+		t.Errorf("Expected a missing file to last 0 seconds, got %d", duration)
+	}
+}
+
+func TestRecordingIndexer(t *testing.T) {
+	initTest(t)
+
+	dir := t.TempDir()
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	folder := filepath.Join(dir, "recordings")
+	if err := os.Mkdir(folder, 0755); err != nil {
+		t.Fatalf("Failed to create recordings folder: %v", err)
+	}
+	mounts := []IcecastMount{
+		{MountName: "ostern", Recording: true},
+		{MountName: "pfingsten", Recording: true, RecordingRetentionDays: 30},
+	}
+	for _, mount := range mounts {
+		mount.Username, mount.Password, mount.TemplateType = "source", "secret", DefaultTemplate
+		if err := storage.CreateIcecastMount(mount); err != nil {
+			t.Fatalf("Failed to create mount: %v", err)
+		}
+	}
+
+	now := time.Now()
+	files := map[string]time.Time{
+		"ostern-20260405-100000.mp3":    now.AddDate(0, 0, -10),
+		"ostern-20260406-100000.mp3":    now.AddDate(0, 0, -1),
+		"pfingsten-20260524-100000.mp3": now.AddDate(0, 0, -10),
+		"notes.txt":                     now,
+	}
+	for name, modTime := range files {
+		path := filepath.Join(folder, name)
+		if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set the time of %s: %v", name, err)
+		}
+	}
+
+	indexer := NewRecordingIndexer(storage, Config{RecordingsFolder: folder, RecordingRetentionDays: 7})
+	if err := indexer.Refresh(); err != nil {
+		t.Fatalf("Failed to refresh recordings: %v", err)
+	}
+	recordings, err := storage.GetAllRecordings()
+	if err != nil || len(recordings) != 3 {
+		t.Fatalf("Expected 3 recordings, got %+v %v", recordings, err)
+	}
+	for _, recording := range recordings {
+		if recording.FileName == "ostern-20260405-100000.mp3" && !recording.StartedAt.Equal(time.Date(2026, 4, 5, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("Expected the start time from the file name, got %s", recording.StartedAt)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(folder, "ostern-20260406-100000.mp3")); err != nil {
+		t.Fatalf("Failed to remove recording: %v", err)
+	}
+	if err := indexer.Refresh(); err != nil {
+		t.Fatalf("Failed to refresh recordings: %v", err)
+	}
+	if recordings, err := storage.GetRecordings("ostern"); err != nil || len(recordings) != 1 {
+		t.Fatalf("Expected the removed file to leave the index, got %+v %v", recordings, err)
+	}
+
+	// ostern uses the default retention of 7 days, pfingsten keeps 30 days.
+	if err := indexer.ApplyRetention(now); err != nil {
+		t.Fatalf("Failed to apply retention: %v", err)
+	}
+	if recordings, err := storage.GetRecordings("ostern"); err != nil || len(recordings) != 0 {
+		t.Fatalf("Expected the old recording to be deleted, got %+v %v", recordings, err)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "ostern-20260405-100000.mp3")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the old recording file to be deleted, got %v", err)
+	}
+	if recordings, err := storage.GetRecordings("pfingsten"); err != nil || len(recordings) != 1 {
+		t.Fatalf("Expected the recording within its retention to stay, got %+v %v", recordings, err)
+	}
+}
+
+func TestRecordingIndexerRefreshIfStale(t *testing.T) {
+	initTest(t)
+
+	dir := t.TempDir()
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate, Recording: true}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+
+	indexer := NewRecordingIndexer(storage, Config{RecordingsFolder: dir})
+	if err := indexer.Refresh(); err != nil {
+		t.Fatalf("Failed to refresh recordings: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ostern-20260405-100000.mp3"), []byte("audio"), 0644); err != nil {
+		t.Fatalf("Failed to write recording: %v", err)
+	}
+
+	if err := indexer.RefreshIfStale(); err != nil {
+		t.Fatalf("Failed to refresh recordings: %v", err)
+	}
+	if recordings, err := storage.GetRecordings("ostern"); err != nil || len(recordings) != 0 {
+		t.Fatalf("Expected no refresh within a minute of the last one, got %+v %v", recordings, err)
+	}
+
+	indexer.refreshedAt = time.Now().Add(-recordingRefreshMinAge)
+	if err := indexer.RefreshIfStale(); err != nil {
+		t.Fatalf("Failed to refresh recordings: %v", err)
+	}
+	if recordings, err := storage.GetRecordings("ostern"); err != nil || len(recordings) != 1 {
+		t.Fatalf("Expected a stale index to be refreshed, got %+v %v", recordings, err)
+	}
+}
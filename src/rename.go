@@ -0,0 +1,231 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MountRedirect sends listeners of a former mount name to the mount it was
+// renamed to.
+type MountRedirect struct {
+	FromName  string    `json:"from_name"`
+	MountName string    `json:"mount_name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RenameRequest renames a stream. With Redirect the old name stays as Icecast
+// mount that sends its listeners to the new name.
+type RenameRequest struct {
+	NewName  string `json:"new_name"`
+	Redirect bool   `json:"redirect"`
+}
+
+func (s *ApiServer) addRenameRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("POST "+autherized+"streams/{streamName}/rename", makeHTTPHandleFunc(s.handleRenameStream))
+	addToRouteRightsMap("POST "+autherized+"streams/{streamName}/rename", "post_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/redirects", makeHTTPHandleFunc(s.handleGetRedirects))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/redirects", "get_stream")
+
+	autherizedRouter.HandleFunc("DELETE "+autherized+"streams/{streamName}/redirects/{fromName}", makeHTTPHandleFunc(s.handleDeleteRedirect))
+	addToRouteRightsMap("DELETE "+autherized+"streams/{streamName}/redirects/{fromName}", "post_stream")
+}
+
+// handleRenameStream renames a stream together with its config files, owner,
+// schedules, recordings and other references. The If-Match header must name
+// the current version.
+func (s *ApiServer) handleRenameStream(w http.ResponseWriter, r *http.Request) error {
+	before, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	err = checkIfMatch(r, before)
+	if err != nil {
+		return err
+	}
+
+	var request RenameRequest
+	err = decodeJSON(w, r, &request)
+	if err != nil {
+		return err
+	}
+	if fields := validateName("new_name", request.NewName, maxMountNameLength); len(fields) > 0 {
+		return validationError(fields...)
+	}
+	if request.NewName == before.MountName {
+		return validationError(fieldError("new_name", "must differ from the current name"))
+	}
+	_, err = s.storage.GetTrashedIcecastMount(request.NewName)
+	if err == nil {
+		return conflictError("stream %s is in the trash, restore or purge it first", request.NewName)
+	}
+	logRequest(r, fmt.Sprintf("Renaming stream %s to %s", before.MountName, request.NewName), InfoLog)
+
+	// The rename takes over a redirect of the new name, a rollback restores it
+	replaced, err := s.storage.GetMountRedirect(request.NewName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logRequest(r, fmt.Sprintf("Database error fetching redirect: %s %s", request.NewName, err), WarnLog)
+		return databaseError(err, "redirect")
+	}
+
+	mount, err := s.storage.RenameIcecastMount(before, request.NewName, request.Redirect)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error renaming icecast mount: %s %s", before.MountName, err), WarnLog)
+		return databaseError(err, "stream")
+	}
+
+	err = s.moveMountConfig(before, mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error renaming icecast mount: %s %s", before.MountName, err), WarnLog)
+		err = s.rollbackRename(before, mount, replaced)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Error rolling back rename of icecast mount: %s %s", before.MountName, err), FatalLog)
+			return internalError("file error, rolling back the rename failed")
+		}
+		return internalError("file error, the rename was rolled back")
+	}
+
+	// The rename is done, a revision that can not be saved is only logged
+	_ = s.saveStreamRevision(r, mount.MountName, before, RevisionReasonRename)
+	if mount.TemplateType == PrivateTemplate {
+		err = s.refreshListenerAuth(mount.MountName)
+		if err != nil {
+			return err
+		}
+	}
+
+	setAuditChange(r, before, mount)
+	s.events.Publish(EventStreamRenamed, mount.MountName, map[string]any{
+		"old_name": before.MountName,
+		"new_name": mount.MountName,
+		"redirect": request.Redirect,
+	})
+
+	setMountETag(w, mount)
+	return WriteJson(w, http.StatusOK, mount)
+}
+
+// moveMountConfig replaces the config files of the old name with the ones of
+// the new name and points the redirects to the new name.
+func (s *ApiServer) moveMountConfig(before, mount IcecastMount) error {
+	s.scheduler.Forget(before.MountName)
+	if s.icecast.MountConfigExists(before) {
+		err := s.icecast.DeleteMountConfig(before)
+		if err != nil {
+			return err
+		}
+	}
+	err := s.icecast.DeleteListenerHtpasswd(before.MountName)
+	if err != nil {
+		return err
+	}
+	err = s.icecast.DeleteRedirectConfig(mount.MountName)
+	if err != nil {
+		return err
+	}
+
+	err = s.scheduler.ApplyMountConfig(mount)
+	if err != nil {
+		return err
+	}
+	redirects, err := s.storage.GetMountRedirects(mount.MountName)
+	if err != nil {
+		return err
+	}
+	for _, redirect := range redirects {
+		err = s.icecast.SaveRedirectConfig(redirect)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackRename gives the stream its old name back after its config files
+// could not be moved and restores the redirect the new name replaced.
+func (s *ApiServer) rollbackRename(before, mount IcecastMount, replaced MountRedirect) error {
+	restored, err := s.storage.RenameIcecastMount(mount, before.MountName, false)
+	if err != nil {
+		return err
+	}
+	err = s.moveMountConfig(mount, restored)
+	if err != nil {
+		return err
+	}
+	if replaced.FromName == "" {
+		return nil
+	}
+	err = s.storage.CreateMountRedirect(replaced)
+	if err != nil {
+		return err
+	}
+	return s.icecast.SaveRedirectConfig(replaced)
+}
+
+// removeRedirect deletes a redirect and its config, e.g. because a new
+// stream takes over the name.
+func (s *ApiServer) removeRedirect(fromName string) error {
+	err := s.icecast.DeleteRedirectConfig(fromName)
+	if err != nil {
+		return err
+	}
+	return s.storage.DeleteMountRedirect(fromName)
+}
+
+// removeRedirects deletes the redirects to a stream that is purged.
+func (s *ApiServer) removeRedirects(mountName string) error {
+	redirects, err := s.storage.GetMountRedirects(mountName)
+	if err != nil {
+		return err
+	}
+	for _, redirect := range redirects {
+		err = s.removeRedirect(redirect.FromName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ApiServer) handleGetRedirects(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+
+	redirects, err := s.storage.GetMountRedirects(mount.MountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching redirects: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "redirect")
+	}
+	return WriteJson(w, http.StatusOK, redirects)
+}
+
+func (s *ApiServer) handleDeleteRedirect(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	fromName := r.PathValue("fromName")
+
+	redirect, err := s.storage.GetMountRedirect(fromName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching redirect: %s %s", fromName, err), WarnLog)
+		return databaseError(err, "redirect")
+	}
+	if redirect.MountName != mount.MountName {
+		return notFoundError("redirect not found")
+	}
+
+	err = s.removeRedirect(fromName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Error deleting redirect: %s %s", fromName, err), WarnLog)
+		return databaseError(err, "redirect")
+	}
+
+	setAuditChange(r, redirect, nil)
+	return WriteJson(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
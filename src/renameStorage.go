@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// mountReferences are the columns that refer to a mount by its name. The
+// audit log is left alone, it records the names used at the time.
+var mountReferences = []struct {
+	table  string
+	column string
+}{
+	{"users", "icecast_mount"},
+	{"listener_accounts", "mount_name"},
+	{"schedules", "mount_name"},
+	{"recordings", "mount_name"},
+	{"metadata_history", "mount_name"},
+	{"webhooks", "mount_name"},
+	{"alerts", "mount_name"},
+	{"alert_silences", "mount_name"},
+	{"mount_revisions", "mount_name"},
+}
+
+const mountRedirectColumns = `from_name, mount_name, created_at`
+
+func scanMountRedirect(scanner interface{ Scan(...any) error }) (MountRedirect, error) {
+	var redirect MountRedirect
+	err := scanner.Scan(&redirect.FromName, &redirect.MountName, &redirect.CreatedAt)
+	return redirect, err
+}
+
+// RenameIcecastMount renames the mount and every reference to it, if the
+// mount still has the version of mount. Redirects to the mount follow it,
+// with redirect the old name redirects to the new one as well.
+func (s *SqliteStorage) RenameIcecastMount(mount IcecastMount, newName string, redirect bool) (IcecastMount, error) {
+	defer observeQuery("RenameIcecastMount", time.Now())
+	oldName := mount.MountName
+	logWithCaller(fmt.Sprintf("Renaming mount %s to %s", oldName, newName), InfoLog)
+	tx, err := s.db.Begin()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error starting transaction: %v", err), FatalLog)
+		return IcecastMount{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	UPDATE icecast_mounts
+	SET mount_name = $1,
+	version = version + 1
+	WHERE mount_name = $2 AND deleted_at IS NULL AND version = $3
+	`, newName, oldName, mount.Version)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return IcecastMount{}, err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return IcecastMount{}, err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Mount %s changed since version %d", oldName, mount.Version), WarnLog)
+		return IcecastMount{}, fmt.Errorf("%w: mount %s version %d", ErrVersionMismatch, oldName, mount.Version)
+	}
+
+	for _, reference := range mountReferences {
+		_, err = tx.Exec(`UPDATE `+reference.table+` SET `+reference.column+` = $1 WHERE `+reference.column+` = $2`, newName, oldName)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error renaming mount in %s: %v", reference.table, err), FatalLog)
+			return IcecastMount{}, err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM mount_redirects WHERE from_name = $1`, newName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return IcecastMount{}, err
+	}
+	_, err = tx.Exec(`UPDATE mount_redirects SET mount_name = $1 WHERE mount_name = $2`, newName, oldName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return IcecastMount{}, err
+	}
+	if redirect {
+		_, err = tx.Exec(`INSERT INTO mount_redirects (`+mountRedirectColumns+`) VALUES ($1, $2, $3)`,
+			oldName, newName, time.Now().UTC())
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+			return IcecastMount{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error committing transaction: %v", err), FatalLog)
+		return IcecastMount{}, err
+	}
+
+	mount.MountName = newName
+	mount.Version++
+	return mount, nil
+}
+
+// CreateMountRedirect stores a redirect, e.g. again after a failed rename took over its name.
+func (s *SqliteStorage) CreateMountRedirect(redirect MountRedirect) error {
+	defer observeQuery("CreateMountRedirect", time.Now())
+	logWithCaller(fmt.Sprintf("Creating redirect of %s to %s", redirect.FromName, redirect.MountName), InfoLog)
+	_, err := s.db.Exec(`INSERT INTO mount_redirects (`+mountRedirectColumns+`) VALUES ($1, $2, $3)`,
+		redirect.FromName, redirect.MountName, redirect.CreatedAt.UTC())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return nil
+}
+
+func (s *SqliteStorage) GetMountRedirect(fromName string) (MountRedirect, error) {
+	defer observeQuery("GetMountRedirect", time.Now())
+	logWithCaller(fmt.Sprintf("Getting redirect of: %s", fromName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + mountRedirectColumns + `
+	FROM mount_redirects
+	WHERE from_name = $1
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return MountRedirect{}, err
+	}
+	defer stmt.Close()
+
+	return scanMountRedirect(stmt.QueryRow(fromName))
+}
+
+// GetMountRedirects returns the old names that redirect to the mount.
+func (s *SqliteStorage) GetMountRedirects(mountName string) ([]MountRedirect, error) {
+	defer observeQuery("GetMountRedirects", time.Now())
+	logWithCaller(fmt.Sprintf("Getting redirects to mount: %s", mountName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + mountRedirectColumns + `
+	FROM mount_redirects
+	WHERE mount_name = $1
+	ORDER BY created_at
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	redirects := []MountRedirect{}
+	for rows.Next() {
+		redirect, err := scanMountRedirect(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		redirects = append(redirects, redirect)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return redirects, nil
+}
+
+func (s *SqliteStorage) DeleteMountRedirect(fromName string) error {
+	defer observeQuery("DeleteMountRedirect", time.Now())
+	logWithCaller(fmt.Sprintf("Deleting redirect of: %s", fromName), InfoLog)
+	result, err := s.db.Exec(`DELETE FROM mount_redirects WHERE from_name = $1`, fromName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for redirect: %s", affectedRows, fromName), FatalLog)
+		return affectedRowsError(affectedRows, "redirect", fromName)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenameIcecastMount(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "gottesdienst.mp3", Username: "source", Password: "secret", TemplateType: PrivateTemplate, Version: 1}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	_, err = storage.CreateListenerAccount(ListenerAccount{MountName: mount.MountName, Username: "listener"})
+	if err != nil {
+		t.Fatalf("Failed to create listener account: %v", err)
+	}
+
+	renamed, err := storage.RenameIcecastMount(mount, "sonntag.mp3", true)
+	if err != nil {
+		t.Fatalf("Failed to rename mount: %v", err)
+	}
+	if renamed.MountName != "sonntag.mp3" || renamed.Version != 2 {
+		t.Fatalf("Unexpected renamed mount: %+v", renamed)
+	}
+	if _, err := storage.GetIcecastMount("gottesdienst.mp3"); err == nil {
+		t.Fatalf("Expected the old name to be gone")
+	}
+	accounts, err := storage.GetListenerAccounts("sonntag.mp3")
+	if err != nil || len(accounts) != 1 {
+		t.Fatalf("Expected the listener account to move, got %v %v", accounts, err)
+	}
+
+	// A second rename moves the redirect along
+	renamed, err = storage.RenameIcecastMount(renamed, "sonntag-gottesdienst.mp3", false)
+	if err != nil {
+		t.Fatalf("Failed to rename mount again: %v", err)
+	}
+	redirects, err := storage.GetMountRedirects("sonntag-gottesdienst.mp3")
+	if err != nil || len(redirects) != 1 || redirects[0].FromName != "gottesdienst.mp3" {
+		t.Fatalf("Expected the redirect of the first name, got %+v %v", redirects, err)
+	}
+
+	_, err = storage.RenameIcecastMount(mount, "ostern.mp3", false)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("Expected a version mismatch renaming a stale mount, got %v", err)
+	}
+}
+
+// TestHandleRenameStreamRollsBack blocks the config file of the new name and
+// expects the rename to be rolled back without a revision.
+func TestHandleRenameStreamRollsBack(t *testing.T) {
+	initTest(t)
+
+	dir := t.TempDir()
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(dir, "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	config := Config{IcecastMountsFolder: dir, DefaultMountTemplate: "../scripts/templates/default_mount.tmpl"}
+	icecast := NewIcecastConfig(config)
+	events := NewEventBroker()
+	s := &ApiServer{config: config, storage: storage, icecast: icecast, scheduler: NewStreamScheduler(storage, icecast, events), events: events}
+
+	if err := storage.CreateIcecastMount(IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate}); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	redirect := MountRedirect{FromName: "pfingsten", MountName: "ostern", CreatedAt: time.Now()}
+	if err := storage.CreateMountRedirect(redirect); err != nil {
+		t.Fatalf("Failed to create redirect: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "pfingsten-default.xml"), 0755); err != nil {
+		t.Fatalf("Failed to block config file: %v", err)
+	}
+
+	rename := func() int {
+		mount, err := storage.GetIcecastMount("ostern")
+		if err != nil {
+			t.Fatalf("Failed to get mount: %v", err)
+		}
+		r := httptest.NewRequest("POST", "/api/v1/streams/ostern/rename", strings.NewReader(`{"new_name":"pfingsten","redirect":true}`))
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey, authInfo{Username: "admin"}))
+		r.SetPathValue("streamName", "ostern")
+		r.Header.Set("If-Match", mountETag(mount))
+		w := httptest.NewRecorder()
+		if err := s.handleRenameStream(w, r); err != nil {
+			status, _ := apiErrorResponse(err)
+			return status
+		}
+		return w.Code
+	}
+
+	if status := rename(); status != http.StatusInternalServerError {
+		t.Fatalf("Expected the blocked rename to fail, got %d", status)
+	}
+	if _, err := storage.GetIcecastMount("ostern"); err != nil {
+		t.Fatalf("Expected the old name to be restored, got %v", err)
+	}
+	if stored, err := storage.GetMountRedirect("pfingsten"); err != nil || stored.MountName != "ostern" {
+		t.Fatalf("Expected the replaced redirect to be restored, got %+v %v", stored, err)
+	}
+	if _, err := storage.GetMountRedirect("ostern"); err == nil {
+		t.Fatalf("Expected the redirect of the rolled back rename to be removed")
+	}
+	if !checkFileExists(filepath.Join(dir, "ostern-default.xml")) || !checkFileExists(filepath.Join(dir, "pfingsten-redirect.xml")) {
+		t.Fatalf("Expected the config files of the old name to be restored")
+	}
+	if revisions, err := storage.GetMountRevisions("ostern"); err != nil || len(revisions) != 0 {
+		t.Fatalf("Expected no revision of the failed rename, got %+v %v", revisions, err)
+	}
+
+	if status := rename(); status != http.StatusOK {
+		t.Fatalf("Expected the rename to succeed, got %d", status)
+	}
+	revisions, err := storage.GetMountRevisions("pfingsten")
+	if err != nil || len(revisions) != 1 || revisions[0].Mount.MountName != "ostern" {
+		t.Fatalf("Expected one revision of the old name, got %+v %v", revisions, err)
+	}
+}
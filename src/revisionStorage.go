@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const mountRevisionColumns = `id, mount_name, mount, config_xml, reason, created_by, created_at`
+
+func scanMountRevision(scanner interface{ Scan(...any) error }) (MountRevision, error) {
+	var revision MountRevision
+	var mount string
+	err := scanner.Scan(&revision.ID, &revision.MountName, &mount, &revision.ConfigXML, &revision.Reason,
+		&revision.CreatedBy, &revision.CreatedAt)
+	if err != nil {
+		return MountRevision{}, err
+	}
+	err = json.Unmarshal([]byte(mount), &revision.Mount)
+	if err != nil {
+		return MountRevision{}, fmt.Errorf("invalid mount of revision %d: %w", revision.ID, err)
+	}
+	return revision, nil
+}
+
+func (s *SqliteStorage) CreateMountRevision(revision MountRevision) (MountRevision, error) {
+	defer observeQuery("CreateMountRevision", time.Now())
+	logWithCaller(fmt.Sprintf("Saving %s revision of mount: %s", revision.Reason, revision.MountName), InfoLog)
+	mount, err := json.Marshal(revision.Mount)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error encoding mount: %v", err), FatalLog)
+		return MountRevision{}, err
+	}
+
+	stmt, err := s.db.Prepare(`
+	INSERT INTO mount_revisions (mount_name, mount, config_xml, reason, created_by, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING ` + mountRevisionColumns)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return MountRevision{}, err
+	}
+	defer stmt.Close()
+
+	created, err := scanMountRevision(stmt.QueryRow(revision.MountName, string(mount), revision.ConfigXML, revision.Reason,
+		revision.CreatedBy, time.Now().UTC()))
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return MountRevision{}, err
+	}
+	return created, nil
+}
+
+func (s *SqliteStorage) GetMountRevision(mountName string, id int64) (MountRevision, error) {
+	defer observeQuery("GetMountRevision", time.Now())
+	logWithCaller(fmt.Sprintf("Getting revision %d of mount: %s", id, mountName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + mountRevisionColumns + `
+	FROM mount_revisions
+	WHERE mount_name = $1 AND id = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return MountRevision{}, err
+	}
+	defer stmt.Close()
+
+	return scanMountRevision(stmt.QueryRow(mountName, id))
+}
+
+// GetMountRevisions returns the revisions of a mount, newest first.
+func (s *SqliteStorage) GetMountRevisions(mountName string) ([]MountRevision, error) {
+	defer observeQuery("GetMountRevisions", time.Now())
+	logWithCaller(fmt.Sprintf("Getting revisions of mount: %s", mountName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + mountRevisionColumns + `
+	FROM mount_revisions
+	WHERE mount_name = $1
+	ORDER BY id DESC
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []MountRevision{}
+	for rows.Next() {
+		revision, err := scanMountRevision(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return revisions, nil
+}
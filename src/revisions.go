@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RevisionReasonUpdate  = "update"
+	RevisionReasonRestore = "restore"
+	RevisionReasonDelete  = "delete"
+	RevisionReasonRename  = "rename"
+	RevisionReasonImport  = "import"
+
+	currentRevision = "current"
+)
+
+// MountRevision is a previous version of a mount together with the Icecast
+// config that was rendered for it. A revision is kept whenever a mount is
+// changed, restored or deleted.
+type MountRevision struct {
+	ID        int64        `json:"id"`
+	MountName string       `json:"mount_name"`
+	Mount     IcecastMount `json:"mount"`
+	ConfigXML string       `json:"config_xml,omitempty"`
+	Reason    string       `json:"reason"`
+	CreatedBy string       `json:"created_by"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// redacted returns the revision without the source password, also in the config.
+func (revision MountRevision) redacted() MountRevision {
+	if revision.Mount.Password != "" {
+		revision.ConfigXML = strings.ReplaceAll(revision.ConfigXML, revision.Mount.Password, redactedValue)
+	}
+	revision.Mount = revision.Mount.redacted()
+	return revision
+}
+
+// RevisionDiff compares two versions of a mount.
+type RevisionDiff struct {
+	From       string                 `json:"from"`
+	To         string                 `json:"to"`
+	Changes    map[string]AuditChange `json:"changes"`
+	ConfigDiff string                 `json:"config_diff"`
+}
+
+// diffLines returns a line diff of a and b, lines prefixed with "-", "+" or " ".
+func diffLines(a, b string) string {
+	oldLines := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	newLines := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of oldLines[i:] and newLines[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff strings.Builder
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			diff.WriteString(" " + oldLines[i] + "\n")
+			i++
+			j++
+		case i < len(oldLines) && (j == len(newLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff.WriteString("-" + oldLines[i] + "\n")
+			i++
+		default:
+			diff.WriteString("+" + newLines[j] + "\n")
+			j++
+		}
+	}
+	return diff.String()
+}
+
+// saveRevision keeps the mount as it is before a change.
+func (s *ApiServer) saveRevision(r *http.Request, mount IcecastMount, reason string) error {
+	return s.saveStreamRevision(r, mount.MountName, mount, reason)
+}
+
+// saveStreamRevision keeps the mount as revision of the stream mountName,
+// which differs from the name of the mount after a rename.
+func (s *ApiServer) saveStreamRevision(r *http.Request, mountName string, mount IcecastMount, reason string) error {
+	config, err := s.icecast.RenderMountConfig(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error rendering revision of icecast mount: %s %s", mount.MountName, err), WarnLog)
+	}
+
+	_, err = s.storage.CreateMountRevision(MountRevision{
+		MountName: mountName,
+		Mount:     mount,
+		ConfigXML: config,
+		Reason:    reason,
+		CreatedBy: getAuthInfo(r).Username,
+	})
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error saving revision of icecast mount: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "revision")
+	}
+	return nil
+}
+
+func (s *ApiServer) addRevisionRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/revisions", makeHTTPHandleFunc(s.handleGetRevisions))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/revisions", "get_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/revisions/{revisionID}", makeHTTPHandleFunc(s.handleGetRevision))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/revisions/{revisionID}", "get_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/revisions/{revisionID}/diff", makeHTTPHandleFunc(s.handleGetRevisionDiff))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/revisions/{revisionID}/diff", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"streams/{streamName}/revisions/{revisionID}/restore", makeHTTPHandleFunc(s.handleRestoreRevision))
+	addToRouteRightsMap("POST "+autherized+"streams/{streamName}/revisions/{revisionID}/restore", "post_stream")
+}
+
+func (s *ApiServer) getRevision(r *http.Request, value string) (MountRevision, error) {
+	mountName := r.PathValue("streamName")
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return MountRevision{}, fmt.Errorf("invalid revision id")
+	}
+
+	revision, err := s.storage.GetMountRevision(mountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching revision: %s %d %s", mountName, id, err), WarnLog)
+		return MountRevision{}, databaseError(err, "revision")
+	}
+	return revision, nil
+}
+
+// handleGetRevisions lists the revisions of a stream, newest first, without the config.
+func (s *ApiServer) handleGetRevisions(w http.ResponseWriter, r *http.Request) error {
+	mountName := r.PathValue("streamName")
+	if mountName == "" {
+		return fmt.Errorf("missing stream name")
+	}
+
+	revisions, err := s.storage.GetMountRevisions(mountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching revisions: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "revision")
+	}
+	for i := range revisions {
+		revisions[i] = revisions[i].redacted()
+		revisions[i].ConfigXML = ""
+	}
+
+	return WriteJson(w, http.StatusOK, revisions)
+}
+
+func (s *ApiServer) handleGetRevision(w http.ResponseWriter, r *http.Request) error {
+	revision, err := s.getRevision(r, r.PathValue("revisionID"))
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, revision.redacted())
+}
+
+// handleGetRevisionDiff compares a revision with the revision in the to
+// parameter or, by default, with the current version of the stream.
+func (s *ApiServer) handleGetRevisionDiff(w http.ResponseWriter, r *http.Request) error {
+	from, err := s.getRevision(r, r.PathValue("revisionID"))
+	if err != nil {
+		return err
+	}
+
+	to := MountRevision{}
+	toName := r.URL.Query().Get("to")
+	if toName == "" || toName == currentRevision {
+		toName = currentRevision
+		current, err := s.getRequestMount(r)
+		if err != nil {
+			return err
+		}
+		to.Mount = current
+		to.ConfigXML, err = s.icecast.RenderMountConfig(current)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Config error rendering icecast mount: %s %s", current.MountName, err), WarnLog)
+			return internalError("file error")
+		}
+	} else {
+		to, err = s.getRevision(r, toName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The changes are redacted by field, so a changed password still shows up
+	changes := auditChanges(from.Mount, to.Mount)
+	from = from.redacted()
+	to = to.redacted()
+	return WriteJson(w, http.StatusOK, RevisionDiff{
+		From:       strconv.FormatInt(from.ID, 10),
+		To:         toName,
+		Changes:    changes,
+		ConfigDiff: diffLines(from.ConfigXML, to.ConfigXML),
+	})
+}
+
+// handleRestoreRevision saves the mount of a revision like an update, so the
+// replaced version is kept as revision as well. The If-Match header is
+// required like on update.
+func (s *ApiServer) handleRestoreRevision(w http.ResponseWriter, r *http.Request) error {
+	revision, err := s.getRevision(r, r.PathValue("revisionID"))
+	if err != nil {
+		return err
+	}
+	logRequest(r, fmt.Sprintf("Restoring revision %d of stream %s", revision.ID, revision.MountName), InfoLog)
+
+	before, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	err = checkIfMatch(r, before)
+	if err != nil {
+		return err
+	}
+
+	// The stream may have been renamed since
+	mount := revision.Mount
+	mount.MountName = before.MountName
+	err = mount.Validate()
+	if err != nil {
+		return err
+	}
+
+	mount, err = s.saveStream(r, before, mount, RevisionReasonRestore)
+	if err != nil {
+		return err
+	}
+
+	setMountETag(w, mount)
+	return WriteJson(w, http.StatusOK, mount)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	initTest(t)
+
+	from := "<mount>\n<public>1</public>\n<password>a</password>\n</mount>\n"
+	to := "<mount>\n<public>0</public>\n<password>a</password>\n<hidden>1</hidden>\n</mount>\n"
+	expected := " <mount>\n-<public>1</public>\n+<public>0</public>\n <password>a</password>\n+<hidden>1</hidden>\n </mount>\n"
+	if diff := diffLines(from, to); diff != expected {
+		t.Fatalf("Unexpected diff:\n%s", diff)
+	}
+}
+
+func TestMountRevisionRedacted(t *testing.T) {
+	initTest(t)
+
+	revision := MountRevision{
+		Mount:     IcecastMount{MountName: "live", Password: "s3cret"},
+		ConfigXML: "<password>s3cret</password>",
+	}
+	redacted := revision.redacted()
+	if redacted.Mount.Password != redactedValue || redacted.ConfigXML != "<password>"+redactedValue+"</password>" {
+		t.Fatalf("Revision is not redacted: %+v", redacted)
+	}
+	if revision.Mount.Password != "s3cret" {
+		t.Fatalf("Redacting changed the revision")
+	}
+}
+
+func TestRestoreRevisionRequiresIfMatch(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	revision, err := storage.CreateMountRevision(MountRevision{MountName: "ostern", Mount: mount, Reason: RevisionReasonUpdate})
+	if err != nil {
+		t.Fatalf("Failed to create revision: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+
+	tests := []struct {
+		ifMatch string
+		code    string
+	}{
+		{"", ErrorCodePreconditionRequired},
+		{`"2"`, ErrorCodePreconditionFailed},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/streams/ostern/revisions/%d/restore", revision.ID), nil)
+		r.SetPathValue("streamName", "ostern")
+		r.SetPathValue("revisionID", fmt.Sprint(revision.ID))
+		if test.ifMatch != "" {
+			r.Header.Set("If-Match", test.ifMatch)
+		}
+		err := s.handleRestoreRevision(httptest.NewRecorder(), r)
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.Code != test.code {
+			t.Errorf("Expected restore with If-Match %q to fail with %s, got %v", test.ifMatch, test.code, err)
+		}
+	}
+}
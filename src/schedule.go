@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+const (
+	scheduleStartLayout = "2006-01-02T15:04"
+
+	recurrenceDaily   = "DAILY"
+	recurrenceWeekly  = "WEEKLY"
+	recurrenceMonthly = "MONTHLY"
+
+	// maxRecurrenceIterations stops the expansion of rules without end.
+	maxRecurrenceIterations = 100000
+
+	OffActionHide   = "hide"
+	OffActionRemove = "remove"
+)
+
+// StreamSchedule is a one-off or recurring window in which a mount is live.
+// Start is the local wall clock time in Timezone, recurrence follows a subset of
+// RFC 5545 RRULE (FREQ, INTERVAL, BYDAY, COUNT, UNTIL).
+type StreamSchedule struct {
+	ID              int64     `json:"id"`
+	MountName       string    `json:"mount_name"`
+	Title           string    `json:"title"`
+	Start           string    `json:"start"`
+	Timezone        string    `json:"timezone"`
+	DurationMinutes int       `json:"duration_minutes"`
+	RRule           string    `json:"rrule,omitempty"`
+	LeadMinutes     int       `json:"lead_minutes"`
+	OffAction       string    `json:"off_action"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ScheduleOccurrence is a single window of a schedule.
+type ScheduleOccurrence struct {
+	ScheduleID int64     `json:"schedule_id"`
+	MountName  string    `json:"mount_name"`
+	Title      string    `json:"title"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+type recurrenceRule struct {
+	Freq     string
+	Interval int
+	ByDay    []time.Weekday
+	Count    int
+	Until    time.Time
+}
+
+var icalWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// parseRRule parses the supported RRULE subset. An empty rule is a one-off event.
+func parseRRule(rule string, loc *time.Location) (recurrenceRule, error) {
+	result := recurrenceRule{Interval: 1}
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+	if rule == "" {
+		return result, nil
+	}
+
+	for _, part := range strings.Split(rule, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return result, fmt.Errorf("invalid rrule part: %s", part)
+		}
+		switch key {
+		case "FREQ":
+			if value != recurrenceDaily && value != recurrenceWeekly && value != recurrenceMonthly {
+				return result, fmt.Errorf("unsupported rrule frequency: %s", value)
+			}
+			result.Freq = value
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return result, fmt.Errorf("invalid rrule interval: %s", value)
+			}
+			result.Interval = interval
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := icalWeekdays[day]
+				if !ok {
+					return result, fmt.Errorf("unsupported rrule day: %s", day)
+				}
+				result.ByDay = append(result.ByDay, weekday)
+			}
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return result, fmt.Errorf("invalid rrule count: %s", value)
+			}
+			result.Count = count
+		case "UNTIL":
+			until, err := parseICalTime(value, loc)
+			if err != nil {
+				return result, fmt.Errorf("invalid rrule until: %s", value)
+			}
+			result.Until = until
+		default:
+			return result, fmt.Errorf("unsupported rrule part: %s", key)
+		}
+	}
+
+	if result.Freq == "" {
+		return result, fmt.Errorf("rrule without frequency")
+	}
+	if len(result.ByDay) > 0 && result.Freq != recurrenceWeekly {
+		return result, fmt.Errorf("BYDAY is only supported for weekly rules")
+	}
+	return result, nil
+}
+
+func parseICalTime(value string, loc *time.Location) (time.Time, error) {
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse("20060102T150405Z", value)
+	}
+	if len(value) == len("20060102") {
+		return time.ParseInLocation("20060102", value, loc)
+	}
+	return time.ParseInLocation("20060102T150405", value, loc)
+}
+
+func (schedule StreamSchedule) location() (*time.Location, error) {
+	if schedule.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(schedule.Timezone)
+}
+
+// Validate checks the schedule and normalizes the rule.
+func (schedule *StreamSchedule) Validate() error {
+	loc, err := schedule.location()
+	if err != nil {
+		return validationError(fieldError("timezone", "is unknown: %s", schedule.Timezone))
+	}
+	_, err = time.ParseInLocation(scheduleStartLayout, schedule.Start, loc)
+	if err != nil {
+		return validationError(fieldError("start", "must have the format YYYY-MM-DDTHH:MM"))
+	}
+	if schedule.DurationMinutes <= 0 {
+		return validationError(fieldError("duration_minutes", "must be positive"))
+	}
+	if schedule.LeadMinutes < 0 {
+		return validationError(fieldError("lead_minutes", "must not be negative"))
+	}
+	if schedule.OffAction == "" {
+		schedule.OffAction = OffActionHide
+	}
+	if schedule.OffAction != OffActionHide && schedule.OffAction != OffActionRemove {
+		return validationError(fieldError("off_action", "must be %s or %s", OffActionHide, OffActionRemove))
+	}
+	schedule.RRule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(schedule.RRule)), "RRULE:")
+	_, err = parseRRule(schedule.RRule, loc)
+	if err != nil {
+		return validationError(fieldError("rrule", "is invalid: %s", err))
+	}
+	return nil
+}
+
+// Occurrences returns all windows of the schedule that overlap [from, to).
+func (schedule StreamSchedule) Occurrences(from, to time.Time) ([]ScheduleOccurrence, error) {
+	loc, err := schedule.location()
+	if err != nil {
+		return nil, err
+	}
+	start, err := time.ParseInLocation(scheduleStartLayout, schedule.Start, loc)
+	if err != nil {
+		return nil, err
+	}
+	rule, err := parseRRule(schedule.RRule, loc)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Duration(schedule.DurationMinutes) * time.Minute
+
+	occurrences := []ScheduleOccurrence{}
+	emitted := 0
+	// add records an occurrence and reports whether the expansion should go on.
+	add := func(occurrenceStart time.Time) bool {
+		if rule.Count > 0 && emitted >= rule.Count {
+			return false
+		}
+		if !rule.Until.IsZero() && occurrenceStart.After(rule.Until) {
+			return false
+		}
+		if !occurrenceStart.Before(to) {
+			return false
+		}
+		emitted++
+		if occurrenceStart.Add(duration).After(from) {
+			occurrences = append(occurrences, ScheduleOccurrence{
+				ScheduleID: schedule.ID,
+				MountName:  schedule.MountName,
+				Title:      schedule.Title,
+				Start:      occurrenceStart,
+				End:        occurrenceStart.Add(duration),
+			})
+		}
+		return true
+	}
+
+	if rule.Freq == "" {
+		add(start)
+		return occurrences, nil
+	}
+
+	byDay := rule.ByDay
+	if len(byDay) == 0 {
+		byDay = []time.Weekday{start.Weekday()}
+	}
+	// Weeks start on monday as in RFC 5545.
+	mondayOffset := func(day time.Weekday) int { return (int(day) + 6) % 7 }
+	slices.SortFunc(byDay, func(a, b time.Weekday) int { return mondayOffset(a) - mondayOffset(b) })
+	weekStart := start.AddDate(0, 0, -mondayOffset(start.Weekday()))
+
+	for i := 0; i < maxRecurrenceIterations; i++ {
+		switch rule.Freq {
+		case recurrenceDaily:
+			if !add(start.AddDate(0, 0, i*rule.Interval)) {
+				return occurrences, nil
+			}
+		case recurrenceWeekly:
+			for _, day := range byDay {
+				candidate := weekStart.AddDate(0, 0, i*7*rule.Interval+mondayOffset(day))
+				if candidate.Before(start) {
+					continue
+				}
+				if !add(candidate) {
+					return occurrences, nil
+				}
+			}
+		case recurrenceMonthly:
+			candidate := start.AddDate(0, i*rule.Interval, 0)
+			// Months without this day are skipped, they do not roll over.
+			if candidate.Day() != start.Day() {
+				continue
+			}
+			if !add(candidate) {
+				return occurrences, nil
+			}
+		}
+	}
+	return occurrences, nil
+}
+
+// isActiveAt reports whether the mount has to be live at the given time,
+// including the lead time before the window.
+func (schedule StreamSchedule) isActiveAt(now time.Time) (bool, error) {
+	lead := time.Duration(schedule.LeadMinutes) * time.Minute
+	occurrences, err := schedule.Occurrences(now, now.Add(lead+time.Nanosecond))
+	if err != nil {
+		return false, err
+	}
+	for _, occurrence := range occurrences {
+		if !now.Before(occurrence.Start.Add(-lead)) && now.Before(occurrence.End) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ###########
+// iCalendar
+// ###########
+
+func icalEscape(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+	return replacer.Replace(text)
+}
+
+// icalFoldLength is the maximum length of a content line in octets.
+const icalFoldLength = 75
+
+// icalFold splits a content line into lines of at most 75 octets, the
+// continuation lines start with a space. UTF-8 characters are not split.
+func icalFold(text string) string {
+	var b strings.Builder
+	limit := icalFoldLength
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		b.WriteString(text[:cut] + "\r\n ")
+		text = text[cut:]
+		limit = icalFoldLength - 1
+	}
+	b.WriteString(text + "\r\n")
+	return b.String()
+}
+
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+// icalTimezone renders a VTIMEZONE with the offset changes of loc in year.
+// They repeat yearly on the same weekday of the month, like the daylight
+// saving rules of the tz database.
+func icalTimezone(loc *time.Location, year int) []string {
+	lines := []string{"BEGIN:VTIMEZONE", "TZID:" + loc.String()}
+	from := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	to := time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	name, offset := time.Unix(from, 0).In(loc).Zone()
+	changes := 0
+	for day := from; day < to; day += 24 * 60 * 60 {
+		_, nextOffset := time.Unix(day+24*60*60, 0).In(loc).Zone()
+		if nextOffset == offset {
+			continue
+		}
+		// Find the first second with the new offset
+		before, after := day, day+24*60*60
+		for after-before > 1 {
+			middle := (before + after) / 2
+			if _, middleOffset := time.Unix(middle, 0).In(loc).Zone(); middleOffset == offset {
+				before = middle
+			} else {
+				after = middle
+			}
+		}
+		lines = append(lines, icalTimezoneRule(time.Unix(after, 0).In(loc), offset)...)
+		offset = nextOffset
+		changes++
+	}
+	if changes == 0 {
+		lines = append(lines, "BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETFROM:"+icalOffset(offset),
+			"TZOFFSETTO:"+icalOffset(offset), "TZNAME:"+name, "END:STANDARD")
+	}
+	return append(lines, "END:VTIMEZONE")
+}
+
+// icalTimezoneRule renders the STANDARD or DAYLIGHT component of an offset
+// change. Its DTSTART is the local time before the change.
+func icalTimezoneRule(change time.Time, fromOffset int) []string {
+	name, toOffset := change.Zone()
+	component := "STANDARD"
+	if change.IsDST() {
+		component = "DAYLIGHT"
+	}
+	local := change.UTC().Add(time.Duration(fromOffset) * time.Second)
+	week := (local.Day()-1)/7 + 1
+	if local.Day()+7 > time.Date(local.Year(), local.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		week = -1
+	}
+	weekday := strings.ToUpper(local.Weekday().String()[:2])
+	return []string{
+		"BEGIN:" + component,
+		"DTSTART:" + local.Format("20060102T150405"),
+		fmt.Sprintf("RRULE:FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", local.Month(), week, weekday),
+		"TZOFFSETFROM:" + icalOffset(fromOffset),
+		"TZOFFSETTO:" + icalOffset(toOffset),
+		"TZNAME:" + name,
+		"END:" + component,
+	}
+}
+
+// buildICalendar renders the schedules of a mount as iCalendar feed. Times in
+// other zones than UTC refer to a VTIMEZONE, so recurring broadcasts keep
+// their local time across daylight saving changes.
+func buildICalendar(mount IcecastMount, schedules []StreamSchedule) string {
+	var b strings.Builder
+	line := func(text string) {
+		b.WriteString(icalFold(text))
+	}
+
+	type icalEvent struct {
+		schedule StreamSchedule
+		start    time.Time
+	}
+	events := []icalEvent{}
+	zones := []*time.Location{}
+	zoneYears := make(map[string]int)
+	for _, schedule := range schedules {
+		loc, err := schedule.location()
+		if err != nil {
+			continue
+		}
+		start, err := time.ParseInLocation(scheduleStartLayout, schedule.Start, loc)
+		if err != nil {
+			continue
+		}
+		events = append(events, icalEvent{schedule: schedule, start: start})
+		if loc.String() == "UTC" {
+			continue
+		}
+		year, ok := zoneYears[loc.String()]
+		if !ok {
+			zones = append(zones, loc)
+		}
+		if !ok || start.Year() < year {
+			zoneYears[loc.String()] = start.Year()
+		}
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//" + getApplicationName() + "//" + getVersion() + "//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:" + icalEscape(mount.StreamName))
+	for _, loc := range zones {
+		for _, text := range icalTimezone(loc, zoneYears[loc.String()]) {
+			line(text)
+		}
+	}
+	for _, event := range events {
+		schedule := event.schedule
+		title := schedule.Title
+		if title == "" {
+			title = mount.StreamName
+		}
+
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:schedule-%d-%s@%s", schedule.ID, mount.MountName, getApplicationName()))
+		line("DTSTAMP:" + schedule.CreatedAt.UTC().Format("20060102T150405Z"))
+		if event.start.Location().String() == "UTC" {
+			line("DTSTART:" + event.start.Format("20060102T150405Z"))
+		} else {
+			line("DTSTART;TZID=" + event.start.Location().String() + ":" + event.start.Format("20060102T150405"))
+		}
+		line(fmt.Sprintf("DURATION:PT%dM", schedule.DurationMinutes))
+		if schedule.RRule != "" {
+			line("RRULE:" + schedule.RRule)
+		}
+		line("SUMMARY:" + icalEscape(title))
+		line("DESCRIPTION:" + icalEscape(mount.StreamDescription))
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.String()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const scheduleColumns = `id, mount_name, title, start, timezone, duration_minutes, rrule, lead_minutes, off_action, created_at`
+
+func scanSchedule(scanner interface{ Scan(...any) error }) (StreamSchedule, error) {
+	var schedule StreamSchedule
+	err := scanner.Scan(&schedule.ID, &schedule.MountName, &schedule.Title, &schedule.Start, &schedule.Timezone,
+		&schedule.DurationMinutes, &schedule.RRule, &schedule.LeadMinutes, &schedule.OffAction, &schedule.CreatedAt)
+	return schedule, err
+}
+
+func (s *SqliteStorage) CreateSchedule(schedule StreamSchedule) (StreamSchedule, error) {
+	defer observeQuery("CreateSchedule", time.Now())
+	logWithCaller(fmt.Sprintf("Creating schedule for mount: %s", schedule.MountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	INSERT INTO schedules (mount_name, title, start, timezone, duration_minutes, rrule, lead_minutes, off_action)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return StreamSchedule{}, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(schedule.MountName, schedule.Title, schedule.Start, schedule.Timezone,
+		schedule.DurationMinutes, schedule.RRule, schedule.LeadMinutes, schedule.OffAction)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return StreamSchedule{}, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting last insert ID: %v", err), FatalLog)
+		return StreamSchedule{}, err
+	}
+	logWithCaller(fmt.Sprintf("Inserted schedule with ID: %d", lastID), InfoLog)
+
+	return s.GetSchedule(schedule.MountName, lastID)
+}
+
+func (s *SqliteStorage) GetSchedule(mountName string, id int64) (StreamSchedule, error) {
+	defer observeQuery("GetSchedule", time.Now())
+	logWithCaller(fmt.Sprintf("Getting schedule %d for mount: %s", id, mountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + scheduleColumns + `
+	FROM schedules
+	WHERE mount_name = $1 AND id = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return StreamSchedule{}, err
+	}
+	defer stmt.Close()
+
+	schedule, err := scanSchedule(stmt.QueryRow(mountName, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logWithCaller(fmt.Sprintf("No schedule %d for mount: %s", id, mountName), DebugLog)
+		}
+		return StreamSchedule{}, err
+	}
+	return schedule, nil
+}
+
+func (s *SqliteStorage) GetSchedules(mountName string) ([]StreamSchedule, error) {
+	defer observeQuery("GetSchedules", time.Now())
+	logWithCaller(fmt.Sprintf("Getting schedules for mount: %s", mountName), InfoLog)
+	return s.querySchedules(`
+	SELECT `+scheduleColumns+`
+	FROM schedules
+	WHERE mount_name = $1
+	ORDER BY id
+	`, mountName)
+}
+
+// GetAllSchedules returns the schedules of all mounts that are not in the trash.
+func (s *SqliteStorage) GetAllSchedules() ([]StreamSchedule, error) {
+	defer observeQuery("GetAllSchedules", time.Now())
+	logWithCaller("Getting all schedules", DebugLog)
+	return s.querySchedules(`
+	SELECT ` + scheduleColumns + `
+	FROM schedules
+	WHERE mount_name NOT IN (SELECT mount_name FROM icecast_mounts WHERE deleted_at IS NOT NULL)
+	ORDER BY mount_name, id
+	`)
+}
+
+func (s *SqliteStorage) querySchedules(query string, args ...any) ([]StreamSchedule, error) {
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []StreamSchedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (s *SqliteStorage) UpdateSchedule(schedule StreamSchedule) error {
+	defer observeQuery("UpdateSchedule", time.Now())
+	logWithCaller(fmt.Sprintf("Updating schedule %d for mount: %s", schedule.ID, schedule.MountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	UPDATE schedules
+	SET title = $1,
+	start = $2,
+	timezone = $3,
+	duration_minutes = $4,
+	rrule = $5,
+	lead_minutes = $6,
+	off_action = $7
+	WHERE mount_name = $8 AND id = $9
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(schedule.Title, schedule.Start, schedule.Timezone, schedule.DurationMinutes,
+		schedule.RRule, schedule.LeadMinutes, schedule.OffAction, schedule.MountName, schedule.ID)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for schedule: %d", affectedRows, schedule.ID), FatalLog)
+		return affectedRowsError(affectedRows, "schedule", schedule.ID)
+	}
+	return nil
+}
+
+func (s *SqliteStorage) DeleteSchedule(mountName string, id int64) error {
+	defer observeQuery("DeleteSchedule", time.Now())
+	logWithCaller(fmt.Sprintf("Deleting schedule %d for mount: %s", id, mountName), InfoLog)
+	stmt, err := s.db.Prepare(`
+	DELETE FROM schedules
+	WHERE mount_name = $1 AND id = $2
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(mountName, id)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for schedule: %d", affectedRows, id), FatalLog)
+		return affectedRowsError(affectedRows, "schedule", id)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestScheduleOneOff(t *testing.T) {
+	initTest(t)
+
+	schedule := StreamSchedule{Start: "2025-04-20T10:00", Timezone: "Europe/Berlin", DurationMinutes: 90}
+	if err := schedule.Validate(); err != nil {
+		t.Fatalf("Failed to validate schedule: %v", err)
+	}
+
+	from := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	occurrences, err := schedule.Occurrences(from, from.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("Failed to expand schedule: %v", err)
+	}
+	if len(occurrences) != 1 {
+		t.Fatalf("Expected 1 occurrence, got %d", len(occurrences))
+	}
+	if !occurrences[0].Start.Equal(time.Date(2025, 4, 20, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected start: %s", occurrences[0].Start)
+	}
+	if occurrences[0].End.Sub(occurrences[0].Start) != 90*time.Minute {
+		t.Fatalf("Unexpected duration: %s", occurrences[0].End.Sub(occurrences[0].Start))
+	}
+}
+
+func TestScheduleWeeklyKeepsWallClockOverDST(t *testing.T) {
+	initTest(t)
+
+	// Summer time in Germany starts on 2025-03-30.
+	schedule := StreamSchedule{Start: "2025-03-23T10:00", Timezone: "Europe/Berlin", DurationMinutes: 60, RRule: "FREQ=WEEKLY;BYDAY=SU"}
+	if err := schedule.Validate(); err != nil {
+		t.Fatalf("Failed to validate schedule: %v", err)
+	}
+
+	from := time.Date(2025, 3, 20, 0, 0, 0, 0, time.UTC)
+	occurrences, err := schedule.Occurrences(from, from.AddDate(0, 0, 14))
+	if err != nil {
+		t.Fatalf("Failed to expand schedule: %v", err)
+	}
+	if len(occurrences) != 2 {
+		t.Fatalf("Expected 2 occurrences, got %d", len(occurrences))
+	}
+	for _, occurrence := range occurrences {
+		if occurrence.Start.Hour() != 10 || occurrence.Start.Weekday() != time.Sunday {
+			t.Fatalf("Occurrence not on sunday 10:00 local time: %s", occurrence.Start)
+		}
+	}
+	if occurrences[1].Start.Sub(occurrences[0].Start) != 7*24*time.Hour-time.Hour {
+		t.Fatalf("Expected DST shift between occurrences, got %s", occurrences[1].Start.Sub(occurrences[0].Start))
+	}
+}
+
+func TestScheduleWeeklyByDayAndCount(t *testing.T) {
+	initTest(t)
+
+	// Starts on a wednesday, so the first friday and the following monday count.
+	schedule := StreamSchedule{Start: "2025-01-01T19:30", Timezone: "UTC", DurationMinutes: 30, RRule: "FREQ=WEEKLY;BYDAY=MO,FR;COUNT=3"}
+	if err := schedule.Validate(); err != nil {
+		t.Fatalf("Failed to validate schedule: %v", err)
+	}
+
+	from := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	occurrences, err := schedule.Occurrences(from, from.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatalf("Failed to expand schedule: %v", err)
+	}
+	expected := []string{"2025-01-03", "2025-01-06", "2025-01-10"}
+	if len(occurrences) != len(expected) {
+		t.Fatalf("Expected %d occurrences, got %d", len(expected), len(occurrences))
+	}
+	for i, occurrence := range occurrences {
+		if occurrence.Start.Format("2006-01-02") != expected[i] {
+			t.Fatalf("Occurrence %d: expected %s, got %s", i, expected[i], occurrence.Start)
+		}
+	}
+}
+
+func TestScheduleMonthlySkipsShortMonths(t *testing.T) {
+	initTest(t)
+
+	schedule := StreamSchedule{Start: "2025-01-31T10:00", Timezone: "UTC", DurationMinutes: 60, RRule: "FREQ=MONTHLY;UNTIL=20250601T000000Z"}
+	if err := schedule.Validate(); err != nil {
+		t.Fatalf("Failed to validate schedule: %v", err)
+	}
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	occurrences, err := schedule.Occurrences(from, from.AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatalf("Failed to expand schedule: %v", err)
+	}
+	expected := []string{"2025-01-31", "2025-03-31", "2025-05-31"}
+	if len(occurrences) != len(expected) {
+		t.Fatalf("Expected %d occurrences, got %d", len(expected), len(occurrences))
+	}
+	for i, occurrence := range occurrences {
+		if occurrence.Start.Format("2006-01-02") != expected[i] {
+			t.Fatalf("Occurrence %d: expected %s, got %s", i, expected[i], occurrence.Start)
+		}
+	}
+}
+
+func TestScheduleIsActiveWithLead(t *testing.T) {
+	initTest(t)
+
+	schedule := StreamSchedule{Start: "2025-01-05T10:00", Timezone: "UTC", DurationMinutes: 60, LeadMinutes: 15, RRule: "FREQ=DAILY"}
+	if err := schedule.Validate(); err != nil {
+		t.Fatalf("Failed to validate schedule: %v", err)
+	}
+
+	cases := map[string]bool{
+		"2025-01-07T09:40:00Z": false,
+		"2025-01-07T09:45:00Z": true,
+		"2025-01-07T10:30:00Z": true,
+		"2025-01-07T11:00:00Z": false,
+		"2025-01-04T10:30:00Z": false,
+	}
+	for value, expected := range cases {
+		now, _ := time.Parse(time.RFC3339, value)
+		active, err := schedule.isActiveAt(now)
+		if err != nil {
+			t.Fatalf("Failed to check schedule: %v", err)
+		}
+		if active != expected {
+			t.Fatalf("Active at %s: expected %t, got %t", value, expected, active)
+		}
+	}
+}
+
+func TestScheduleValidateRejectsInvalidRules(t *testing.T) {
+	initTest(t)
+
+	invalid := []StreamSchedule{
+		{Start: "2025-01-05T10:00", Timezone: "Mars/Olympus", DurationMinutes: 60},
+		{Start: "2025-01-05 10:00", Timezone: "UTC", DurationMinutes: 60},
+		{Start: "2025-01-05T10:00", Timezone: "UTC", DurationMinutes: 0},
+		{Start: "2025-01-05T10:00", Timezone: "UTC", DurationMinutes: 60, RRule: "FREQ=YEARLY"},
+		{Start: "2025-01-05T10:00", Timezone: "UTC", DurationMinutes: 60, RRule: "FREQ=DAILY;BYDAY=MO"},
+		{Start: "2025-01-05T10:00", Timezone: "UTC", DurationMinutes: 60, OffAction: "pause"},
+	}
+	for i, schedule := range invalid {
+		if err := schedule.Validate(); err == nil {
+			t.Fatalf("Expected schedule %d to be invalid", i)
+		}
+	}
+}
+
+func TestBuildICalendar(t *testing.T) {
+	initTest(t)
+
+	mount := IcecastMount{MountName: "gottesdienst", StreamName: "Gottesdienst, Sonntag", StreamDescription: strings.Repeat("Übertragung aus der Kirche ", 5)}
+	schedules := []StreamSchedule{
+		{ID: 3, MountName: "gottesdienst", Start: "2025-04-20T10:00", Timezone: "Europe/Berlin", DurationMinutes: 90, RRule: "FREQ=WEEKLY;BYDAY=SU"},
+		{ID: 4, MountName: "gottesdienst", Start: "2025-12-24T22:00", Timezone: "Europe/Berlin", DurationMinutes: 60},
+		{ID: 5, MountName: "gottesdienst", Start: "2025-05-01T08:00", Timezone: "UTC", DurationMinutes: 30},
+	}
+
+	calendar := buildICalendar(mount, schedules)
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\n" +
+			"BEGIN:DAYLIGHT\r\nDTSTART:20250330T020000\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST\r\nEND:DAYLIGHT\r\n" +
+			"BEGIN:STANDARD\r\nDTSTART:20251026T030000\r\nRRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\nTZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nTZNAME:CET\r\nEND:STANDARD\r\n" +
+			"END:VTIMEZONE\r\n",
+		"DTSTART;TZID=Europe/Berlin:20250420T100000\r\n",
+		"DTSTART:20250501T080000Z\r\n",
+		"DURATION:PT90M\r\n",
+		"RRULE:FREQ=WEEKLY;BYDAY=SU\r\n",
+		"SUMMARY:Gottesdienst\\, Sonntag\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(calendar, expected) {
+			t.Fatalf("Calendar does not contain %q:\n%s", expected, calendar)
+		}
+	}
+	if strings.Count(calendar, "BEGIN:VTIMEZONE") != 1 {
+		t.Fatalf("Expected one VTIMEZONE per time zone:\n%s", calendar)
+	}
+
+	unfolded := strings.ReplaceAll(calendar, "\r\n ", "")
+	if !strings.Contains(unfolded, "DESCRIPTION:"+mount.StreamDescription+"\r\n") {
+		t.Fatalf("Expected the folded description to unfold, got:\n%s", calendar)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(calendar, "\r\n"), "\r\n") {
+		if len(line) > icalFoldLength || !utf8.ValidString(line) {
+			t.Fatalf("Expected lines of at most %d octets without split characters, got %q", icalFoldLength, line)
+		}
+	}
+}
+
+func TestICalTimezoneWithoutDST(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("Failed to load time zone: %v", err)
+	}
+	expected := []string{"BEGIN:VTIMEZONE", "TZID:Asia/Tokyo", "BEGIN:STANDARD", "DTSTART:19700101T000000",
+		"TZOFFSETFROM:+0900", "TZOFFSETTO:+0900", "TZNAME:JST", "END:STANDARD", "END:VTIMEZONE"}
+	if lines := icalTimezone(loc, 2025); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected %v, got %v", expected, lines)
+	}
+}
+
+func TestScheduleHandlersRejectUnknownFields(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mount := IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate}
+	if err := storage.CreateIcecastMount(mount); err != nil {
+		t.Fatalf("Failed to create mount: %v", err)
+	}
+	schedule, err := storage.CreateSchedule(StreamSchedule{MountName: "ostern", Start: "2025-04-20T10:00", Timezone: "UTC", DurationMinutes: 60})
+	if err != nil {
+		t.Fatalf("Failed to create schedule: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+
+	body := `{"start":"2025-04-20T10:00","duration_minutes":60,"colour":"red"}`
+	for _, handler := range []apiFunc{s.handleCreateSchedule, s.handleUpdateSchedule} {
+		r := httptest.NewRequest("POST", "/api/streams/ostern/schedules", strings.NewReader(body))
+		r.SetPathValue("streamName", "ostern")
+		r.SetPathValue("scheduleID", fmt.Sprint(schedule.ID))
+		status, apiErr := apiErrorResponse(handler(httptest.NewRecorder(), r))
+		if status != http.StatusUnprocessableEntity || len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "colour" {
+			t.Errorf("Expected the unknown field to be rejected, got %d %+v", status, apiErr)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	schedulerInterval     = 30 * time.Second
+	defaultScheduleWindow = 7 * 24 * time.Hour
+	maxScheduleWindow     = 366 * 24 * time.Hour
+)
+
+// StreamScheduler activates the config of scheduled mounts before their windows
+// and hides or removes it afterwards. Mounts without schedules are always active.
+type StreamScheduler struct {
+	storage Store
+	icecast *IcecastConfigStore
+	events  *EventBroker
+
+	mu      sync.Mutex
+	applied map[string]bool
+
+	// listenersCheckedAt is when expired listener accounts were last removed.
+	listenersCheckedAt time.Time
+}
+
+func NewStreamScheduler(storage Store, icecast *IcecastConfigStore, events *EventBroker) *StreamScheduler {
+	return &StreamScheduler{
+		storage: storage,
+		icecast: icecast,
+		events:  events,
+		applied: make(map[string]bool),
+	}
+}
+
+// Run checks all schedules every interval. It never returns.
+func (sc *StreamScheduler) Run(interval time.Duration) {
+	logWithCaller(fmt.Sprintf("Starting scheduler with interval %s", interval), InfoLog)
+	sc.tick(time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		sc.tick(now)
+	}
+}
+
+func (sc *StreamScheduler) tick(now time.Time) {
+	sc.expireListenerAccounts(now)
+
+	schedules, err := sc.storage.GetAllSchedules()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Scheduler could not load schedules: %s", err), WarnLog)
+		return
+	}
+
+	byMount := make(map[string][]StreamSchedule)
+	for _, schedule := range schedules {
+		byMount[schedule.MountName] = append(byMount[schedule.MountName], schedule)
+	}
+
+	for mountName, mountSchedules := range byMount {
+		active, offAction := scheduledState(mountSchedules, now)
+
+		sc.mu.Lock()
+		applied, known := sc.applied[mountName]
+		sc.mu.Unlock()
+		if known && applied == active {
+			continue
+		}
+
+		mount, err := sc.storage.GetIcecastMount(mountName)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Scheduler could not load mount %s: %s", mountName, err), WarnLog)
+			continue
+		}
+		err = sc.apply(mount, active, offAction)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Scheduler could not apply mount %s: %s", mountName, err), WarnLog)
+		}
+	}
+}
+
+// expireListenerAccounts rewrites the htpasswd files of mounts whose listener
+// accounts expired since the last tick. With url authentication expiry is
+// checked on every login instead. The first tick covers all expired accounts,
+// the files may be older than the API.
+func (sc *StreamScheduler) expireListenerAccounts(now time.Time) {
+	if sc.icecast.getListenerAuthType() != listenerAuthHtpasswd {
+		return
+	}
+	mountNames, err := sc.storage.GetExpiredListenerMounts(sc.listenersCheckedAt, now)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Scheduler could not load expired listener accounts: %s", err), WarnLog)
+		return
+	}
+	for _, mountName := range mountNames {
+		mount, err := sc.storage.GetIcecastMount(mountName)
+		if err != nil || mount.TemplateType != PrivateTemplate {
+			continue
+		}
+		accounts, err := sc.storage.GetListenerAccounts(mountName)
+		if err == nil {
+			err = sc.icecast.SaveListenerHtpasswd(mountName, accounts, now)
+		}
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Scheduler could not remove expired listener accounts of %s: %s", mountName, err), WarnLog)
+			return
+		}
+		logWithCaller(fmt.Sprintf("Removed expired listener accounts of %s", mountName), InfoLog)
+	}
+	sc.listenersCheckedAt = now
+}
+
+// scheduledState reports whether one of the schedules is active and what to do
+// with the mount otherwise. Removing wins if any schedule asks for it.
+func scheduledState(schedules []StreamSchedule, now time.Time) (bool, string) {
+	active := false
+	offAction := OffActionHide
+	for _, schedule := range schedules {
+		scheduleActive, err := schedule.isActiveAt(now)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Invalid schedule %d for mount %s: %s", schedule.ID, schedule.MountName, err), WarnLog)
+			continue
+		}
+		active = active || scheduleActive
+		if schedule.OffAction == OffActionRemove {
+			offAction = OffActionRemove
+		}
+	}
+	return active, offAction
+}
+
+func (sc *StreamScheduler) apply(mount IcecastMount, active bool, offAction string) error {
+	var err error
+	switch {
+	case active:
+		logWithCaller(fmt.Sprintf("Activating scheduled mount %s", mount.MountName), InfoLog)
+		err = sc.icecast.SaveMountConfig(mount)
+	case offAction == OffActionRemove:
+		logWithCaller(fmt.Sprintf("Removing scheduled mount %s", mount.MountName), InfoLog)
+		if sc.icecast.MountConfigExists(mount) {
+			err = sc.icecast.DeleteMountConfig(mount)
+		}
+	default:
+		logWithCaller(fmt.Sprintf("Hiding scheduled mount %s", mount.MountName), InfoLog)
+		hidden := mount
+		hidden.Public = 0
+		err = sc.icecast.SaveMountConfig(hidden)
+	}
+	if err != nil {
+		sc.events.Publish(EventConfigFailed, mount.MountName, map[string]any{"active": active, "error": err.Error()})
+		return err
+	}
+	sc.events.Publish(EventConfigReloaded, mount.MountName, map[string]any{"active": active})
+
+	sc.mu.Lock()
+	sc.applied[mount.MountName] = active
+	sc.mu.Unlock()
+	return nil
+}
+
+// ApplyMountConfig writes the config of a mount according to its schedules.
+// Handlers use it instead of writing the config directly.
+func (sc *StreamScheduler) ApplyMountConfig(mount IcecastMount) error {
+	schedules, err := sc.storage.GetSchedules(mount.MountName)
+	if err != nil {
+		return err
+	}
+	if len(schedules) == 0 {
+		sc.Forget(mount.MountName)
+		return sc.icecast.SaveMountConfig(mount)
+	}
+	active, offAction := scheduledState(schedules, time.Now())
+	return sc.apply(mount, active, offAction)
+}
+
+// Forget drops the applied state of a mount, e.g. after it was deleted.
+func (sc *StreamScheduler) Forget(mountName string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.applied, mountName)
+}
+
+// IsLive reports whether a mount is currently inside a scheduled window.
+// Mounts without schedules are always live.
+func (sc *StreamScheduler) IsLive(mountName string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	active, known := sc.applied[mountName]
+	return !known || active
+}
+
+// ###########
+// Schedule routes
+// ###########
+
+func (s *ApiServer) addScheduleRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"schedule", makeHTTPHandleFunc(s.handleGetSchedule))
+	addToRouteRightsMap("GET "+autherized+"schedule", "get_all_streams")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/schedules", makeHTTPHandleFunc(s.handleGetStreamSchedules))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/schedules", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"streams/{streamName}/schedules", makeHTTPHandleFunc(s.handleCreateSchedule))
+	addToRouteRightsMap("POST "+autherized+"streams/{streamName}/schedules", "post_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/schedules/{scheduleID}", makeHTTPHandleFunc(s.handleGetStreamSchedule))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/schedules/{scheduleID}", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"streams/{streamName}/schedules/{scheduleID}", makeHTTPHandleFunc(s.handleUpdateSchedule))
+	addToRouteRightsMap("POST "+autherized+"streams/{streamName}/schedules/{scheduleID}", "post_stream")
+
+	autherizedRouter.HandleFunc("DELETE "+autherized+"streams/{streamName}/schedules/{scheduleID}", makeHTTPHandleFunc(s.handleDeleteSchedule))
+	addToRouteRightsMap("DELETE "+autherized+"streams/{streamName}/schedules/{scheduleID}", "delete_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/{streamName}/schedule.ics", makeHTTPHandleFunc(s.handleGetStreamCalendar))
+	addToRouteRightsMap("GET "+autherized+"streams/{streamName}/schedule.ics", "get_stream")
+}
+
+func getScheduleID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("scheduleID"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule id")
+	}
+	return id, nil
+}
+
+func (s *ApiServer) getRequestMount(r *http.Request) (IcecastMount, error) {
+	mountName := r.PathValue("streamName")
+	if mountName == "" {
+		return IcecastMount{}, fmt.Errorf("missing stream name")
+	}
+
+	mount, err := s.storage.GetIcecastMount(mountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching icecast mount: %s %s", mountName, err), WarnLog)
+		return IcecastMount{}, databaseError(err, "stream")
+	}
+	return mount, nil
+}
+
+// handleGetSchedule returns all occurrences between from and to (RFC 3339).
+// Without parameters the next seven days are returned.
+func (s *ApiServer) handleGetSchedule(w http.ResponseWriter, r *http.Request) error {
+	from := time.Now()
+	to := from.Add(defaultScheduleWindow)
+	var err error
+	if value := r.URL.Query().Get("from"); value != "" {
+		from, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid from")
+		}
+		to = from.Add(defaultScheduleWindow)
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		to, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("invalid to")
+		}
+	}
+	if !to.After(from) || to.Sub(from) > maxScheduleWindow {
+		return fmt.Errorf("invalid time range")
+	}
+
+	schedules, err := s.storage.GetAllSchedules()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching schedules: %s", err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	occurrences := []ScheduleOccurrence{}
+	for _, schedule := range schedules {
+		scheduleOccurrences, err := schedule.Occurrences(from, to)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Invalid schedule %d for mount %s: %s", schedule.ID, schedule.MountName, err), WarnLog)
+			continue
+		}
+		occurrences = append(occurrences, scheduleOccurrences...)
+	}
+	slices.SortFunc(occurrences, func(a, b ScheduleOccurrence) int { return a.Start.Compare(b.Start) })
+
+	return WriteJson(w, http.StatusOK, occurrences)
+}
+
+func (s *ApiServer) handleGetStreamSchedules(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+
+	schedules, err := s.storage.GetSchedules(mount.MountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching schedules: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	return WriteJson(w, http.StatusOK, schedules)
+}
+
+func (s *ApiServer) handleGetStreamSchedule(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getScheduleID(r)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := s.storage.GetSchedule(mount.MountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching schedule: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	return WriteJson(w, http.StatusOK, schedule)
+}
+
+func (s *ApiServer) handleCreateSchedule(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+
+	var schedule StreamSchedule
+	err = decodeJSON(w, r, &schedule)
+	if err != nil {
+		return err
+	}
+
+	schedule.MountName = mount.MountName
+	if schedule.Timezone == "" {
+		schedule.Timezone = s.config.DefaultTimezone
+	}
+	err = schedule.Validate()
+	if err != nil {
+		return err
+	}
+
+	created, err := s.storage.CreateSchedule(schedule)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error creating schedule: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	err = s.scheduler.ApplyMountConfig(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error applying schedule: %s %s", mount.MountName, err), WarnLog)
+		return internalError("file error")
+	}
+
+	return WriteJson(w, http.StatusCreated, created)
+}
+
+func (s *ApiServer) handleUpdateSchedule(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getScheduleID(r)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.storage.GetSchedule(mount.MountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching schedule: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	var schedule StreamSchedule
+	err = decodeJSON(w, r, &schedule)
+	if err != nil {
+		return err
+	}
+
+	schedule.ID = existing.ID
+	schedule.MountName = existing.MountName
+	schedule.CreatedAt = existing.CreatedAt
+	if schedule.Timezone == "" {
+		schedule.Timezone = s.config.DefaultTimezone
+	}
+	err = schedule.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.UpdateSchedule(schedule)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error updating schedule: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	err = s.scheduler.ApplyMountConfig(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error applying schedule: %s %s", mount.MountName, err), WarnLog)
+		return internalError("file error")
+	}
+
+	return WriteJson(w, http.StatusOK, schedule)
+}
+
+func (s *ApiServer) handleDeleteSchedule(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	id, err := getScheduleID(r)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.DeleteSchedule(mount.MountName, id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error deleting schedule: %s %d %s", mount.MountName, id, err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	err = s.scheduler.ApplyMountConfig(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error applying schedule: %s %s", mount.MountName, err), WarnLog)
+		return internalError("file error")
+	}
+
+	return WriteJson(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func (s *ApiServer) handleGetStreamCalendar(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil {
+		return err
+	}
+	return s.writeStreamCalendar(w, mount)
+}
+
+// handlePublicStreamCalendar serves the iCalendar feed of public mounts without
+// a token, so it can be subscribed to from calendar apps.
+func (s *ApiServer) handlePublicStreamCalendar(w http.ResponseWriter, r *http.Request) error {
+	mount, err := s.getRequestMount(r)
+	if err != nil || !isPublicMount(mount) {
+		return notFoundError("stream not found")
+	}
+	return s.writeStreamCalendar(w, mount)
+}
+
+func (s *ApiServer) writeStreamCalendar(w http.ResponseWriter, mount IcecastMount) error {
+	schedules, err := s.storage.GetSchedules(mount.MountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Database error fetching schedules: %s %s", mount.MountName, err), WarnLog)
+		return databaseError(err, "schedule")
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write([]byte(buildICalendar(mount, schedules)))
+	return err
+}
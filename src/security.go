@@ -27,7 +27,7 @@ var (
 	//rightsIcecastAdmin = []string{"get_stream", "post_stream", "delete_stream", "get_all_streams"}
 	//rightsUser         = []string{"change_password"}
 	//rightsUserAdmin    = []string{"create_user", "edit_user", "delete_user"}
-	rightsAdmin = []string{"change_password", "create_user", "edit_user", "delete_user", "get_all_streams", "get_stream", "post_stream", "delete_stream"}
+	rightsAdmin = []string{"change_password", "create_user", "edit_user", "delete_user", "get_all_streams", "get_stream", "post_stream", "delete_stream", "change_log_level", "get_audit_log"}
 )
 
 func setSecretKey(secret string) error {
@@ -145,90 +145,102 @@ func createToken(username string, rights []string, exparation time.Time) (string
 	return tokenPrefix + encryptedSecurePart, nil
 }
 
+// checkTokeHasRight reports whether the token of the user carries the right.
+// Failures are counted by reason in the metrics.
 func checkTokeHasRight(token, right, username string) bool {
+	reason := tokenRightFailure(token, right, username)
+	if reason != "" {
+		authFailures.Inc(reason)
+	}
+	return reason == ""
+}
+
+// tokenRightFailure returns why the token does not carry the right, or an
+// empty string if it does.
+func tokenRightFailure(token, right, username string) string {
 	if token == "" {
 		logWithCaller("Token is empty", WarnLog)
-		return false
+		return "missing_token"
 	}
 
 	if right == "" {
 		logWithCaller("Right is empty", WarnLog)
-		return false
+		return "missing_right"
 	}
 
 	if username == "" {
 		logWithCaller("Username is empty", WarnLog)
-		return false
+		return "unknown_user"
 	}
 
 	if len(token) < len(tokenPrefix) {
 		logWithCaller("Token is too short", WarnLog)
-		return false
+		return "malformed_token"
 	}
 
 	if token[:len(tokenPrefix)] != tokenPrefix {
 		logWithCaller("Token does not start with k_token: "+token[:len(tokenPrefix)], WarnLog)
-		return false
+		return "malformed_token"
 	}
 
 	decrypted, err := decryptString(token[len(tokenPrefix):])
 	if err != nil {
 		logWithCaller("Failed to decrypt token: "+err.Error(), WarnLog)
-		return false
+		return "invalid_token"
 	}
 
 	// Split the decrypted string into parts
 	parts := strings.Split(decrypted, "|")
 	if len(parts) < 5 {
 		logWithCaller("Decrypted token does not have enough parts", WarnLog)
-		return false
+		return "malformed_token"
 	}
 
 	timestamp := parts[0]
 	if timestamp == "" {
 		logWithCaller("Timestamp is empty", WarnLog)
-		return false
+		return "malformed_token"
 	}
 
 	timestampTime, err := time.Parse(time.RFC3339Nano, timestamp)
 	if err != nil {
 		logWithCaller("Failed to parse timestamp: "+err.Error(), WarnLog)
-		return false
+		return "malformed_token"
 	}
 	if timestampTime.After(time.Now()) {
 		logWithCaller("Creation Timestamp is after today", WarnLog)
-		return false
+		return "invalid_token"
 	}
 
 	exparationTimestamp := parts[1]
 	if exparationTimestamp == "" {
 		logWithCaller("Exparation Timestamp is empty", WarnLog)
-		return false
+		return "malformed_token"
 	}
 
 	exparationTime, err := time.Parse(time.RFC3339Nano, exparationTimestamp)
 	if err != nil {
 		logWithCaller("Failed to parse exparation timestamp: "+err.Error(), WarnLog)
-		return false
+		return "malformed_token"
 	}
 	if exparationTime.Before(time.Now()) {
 		logWithCaller("Token expired: "+exparationTimestamp, WarnLog)
-		return false
+		return "expired_token"
 	}
 	usernameToken := parts[2]
 	if usernameToken == "" {
 		logWithCaller("Username is empty", WarnLog)
-		return false
+		return "unknown_user"
 	}
 	if usernameToken != username {
 		logWithCaller("Username does not match", WarnLog)
-		return false
+		return "user_mismatch"
 	}
 
 	applicationName := parts[3]
 	if applicationName != getApplicationName() {
 		logWithCaller("Application name does not match", WarnLog)
-		return false
+		return "invalid_token"
 	}
 	tokenRights := parts[4:]
 
@@ -236,13 +248,13 @@ func checkTokeHasRight(token, right, username string) bool {
 		r = strings.TrimSpace(r)
 		right = strings.TrimSpace(right)
 		if r == right {
-			return true
+			return ""
 		}
 	}
 
 	logWithCaller("Token does not have the right: "+right, WarnLog)
 
-	return false
+	return "insufficient_rights"
 }
 
 func getHash(input string) string {
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -12,6 +13,8 @@ type Store interface {
 	DeleteIcecastMount(mountName string) (IcecastMount, error)
 	GetIcecastMount(mountName string) (IcecastMount, error)
 	GetIcecastMounts() ([]IcecastMount, error)
+	GetIcecastMountPage(filter MountFilter) (MountPage, error)
+	ApplyIcecastMountChanges(changes []MountChange, atomic bool) ([]error, error)
 	UpdateIcecastMount(mount IcecastMount) error
 
 	SaveUser(username, password string) error
@@ -19,17 +22,89 @@ type Store interface {
 	DeleteUser(username string) error
 	GetUserByToken(token string) (string, error)
 	GetTokenByUser(username string) (string, error)
+	GetUserStream(username string) (string, error)
 
 	SaveToken(username, token string) error
+
+	CreateListenerAccount(account ListenerAccount) (ListenerAccount, error)
+	GetListenerAccount(mountName string, id int64) (ListenerAccount, error)
+	GetListenerAccounts(mountName string) ([]ListenerAccount, error)
+	UpdateListenerAccount(account ListenerAccount) error
+	DeleteListenerAccount(mountName string, id int64) error
+	GetExpiredListenerMounts(from, to time.Time) ([]string, error)
+
+	CreateSchedule(schedule StreamSchedule) (StreamSchedule, error)
+	GetSchedule(mountName string, id int64) (StreamSchedule, error)
+	GetSchedules(mountName string) ([]StreamSchedule, error)
+	GetAllSchedules() ([]StreamSchedule, error)
+	UpdateSchedule(schedule StreamSchedule) error
+	DeleteSchedule(mountName string, id int64) error
+
+	SaveRecording(recording Recording) error
+	GetRecording(mountName string, id int64) (Recording, error)
+	GetRecordings(mountName string) ([]Recording, error)
+	GetAllRecordings() ([]Recording, error)
+	DeleteRecording(id int64) error
+
+	AddMetadataEntry(entry MetadataEntry) error
+	GetMetadataHistory(mountName string, from, to time.Time) ([]MetadataEntry, error)
+
+	CreateWebhook(webhook Webhook) (Webhook, error)
+	GetWebhook(id int64) (Webhook, error)
+	GetWebhooks() ([]Webhook, error)
+	UpdateWebhook(webhook Webhook) error
+	DeleteWebhook(id int64) error
+	CreateWebhookDelivery(delivery WebhookDelivery) error
+	GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error)
+	GetWebhookDeliveries(webhookID int64, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(delivery WebhookDelivery) error
+	DeleteWebhookDeliveries(before time.Time) (int64, error)
+
+	CreateAlert(alert Alert) (Alert, error)
+	GetAlert(id int64) (Alert, error)
+	GetAlerts(mountName string, openOnly bool) ([]Alert, error)
+	AlertExists(mountName string, occurrenceStart time.Time) (bool, error)
+	UpdateAlert(alert Alert) error
+	CreateAlertSilence(silence AlertSilence) (AlertSilence, error)
+	GetAlertSilences() ([]AlertSilence, error)
+	DeleteAlertSilence(id int64) error
+
+	CreateAuditEntry(entry AuditEntry) error
+	GetAuditEntries(filter AuditFilter) ([]AuditEntry, error)
+
+	CreateMountRevision(revision MountRevision) (MountRevision, error)
+	GetMountRevision(mountName string, id int64) (MountRevision, error)
+	GetMountRevisions(mountName string) ([]MountRevision, error)
+
+	TrashIcecastMount(mountName, deletedBy string) (IcecastMount, error)
+	RestoreIcecastMount(mountName string) (IcecastMount, error)
+	GetTrashedIcecastMount(mountName string) (TrashedMount, error)
+	GetTrashedIcecastMounts() ([]TrashedMount, error)
+
+	RenameIcecastMount(mount IcecastMount, newName string, redirect bool) (IcecastMount, error)
+	CreateMountRedirect(redirect MountRedirect) error
+	GetMountRedirect(fromName string) (MountRedirect, error)
+	GetMountRedirects(mountName string) ([]MountRedirect, error)
+	DeleteMountRedirect(fromName string) error
+
+	CreateIdempotencyKey(entry IdempotencyKey) error
+	GetIdempotencyKey(username, key string) (IdempotencyKey, error)
+	CompleteIdempotencyKey(entry IdempotencyKey) error
+	DeleteIdempotencyKey(username, key string) error
+	DeleteIdempotencyKeys(before time.Time) (int64, error)
 }
 
 type SqliteStorage struct {
 	db *sql.DB
 }
 
+// sqliteBusyTimeout lets concurrent writers, e.g. the background workers, wait
+// for each other instead of failing with SQLITE_BUSY.
+const sqliteBusyTimeout = "?_pragma=busy_timeout(5000)"
+
 func NewSqliteStore(config *Config) (*SqliteStorage, error) {
 
-	db, err := sql.Open("sqlite", config.DbFile)
+	db, err := sql.Open("sqlite", config.DbFile+sqliteBusyTimeout)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error opening database: %v", err), FatalLog)
 		return nil, err
@@ -72,12 +147,170 @@ func initDb(db *sql.DB, config *Config) error {
 		icecast_mount TEXT UNIQUE,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS listener_accounts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mount_name TEXT NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		password_hash TEXT NOT NULL DEFAULT '',
+		htpasswd_hash TEXT NOT NULL DEFAULT '',
+		access_code_hash TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMP,
+		max_sessions INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_listener_accounts_mount ON listener_accounts (mount_name);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_listener_accounts_username ON listener_accounts (mount_name, username) WHERE username != '';
+
+	CREATE TABLE IF NOT EXISTS schedules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mount_name TEXT NOT NULL,
+		title TEXT NOT NULL DEFAULT '',
+		start TEXT NOT NULL,
+		timezone TEXT NOT NULL,
+		duration_minutes INTEGER NOT NULL,
+		rrule TEXT NOT NULL DEFAULT '',
+		lead_minutes INTEGER NOT NULL DEFAULT 0,
+		off_action TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_schedules_mount ON schedules (mount_name);
+
+	CREATE TABLE IF NOT EXISTS recordings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mount_name TEXT NOT NULL,
+		file_name TEXT UNIQUE NOT NULL,
+		size INTEGER NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		ended_at TIMESTAMP NOT NULL,
+		duration_seconds INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_recordings_mount ON recordings (mount_name);
+
+	CREATE TABLE IF NOT EXISTS metadata_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mount_name TEXT NOT NULL,
+		title TEXT NOT NULL,
+		observed_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_metadata_history_mount ON metadata_history (mount_name, observed_at);
+
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		mount_name TEXT NOT NULL DEFAULT '',
+		active INTEGER NOT NULL DEFAULT 1,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
+
+	CREATE TABLE IF NOT EXISTS alerts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mount_name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		message TEXT NOT NULL,
+		occurrence_start TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		notified_at TIMESTAMP,
+		acknowledged_at TIMESTAMP,
+		acknowledged_by TEXT NOT NULL DEFAULT '',
+		resolved_at TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_alerts_mount ON alerts (mount_name, occurrence_start);
+
+	CREATE TABLE IF NOT EXISTS alert_silences (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mount_name TEXT NOT NULL DEFAULT '',
+		until TIMESTAMP NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_by TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at TIMESTAMP NOT NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		token_id TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		changes TEXT,
+		source_ip TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT '',
+		status INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target);
+
+	CREATE TABLE IF NOT EXISTS mount_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mount_name TEXT NOT NULL,
+		mount TEXT NOT NULL,
+		config_xml TEXT NOT NULL DEFAULT '',
+		reason TEXT NOT NULL,
+		created_by TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_mount_revisions_mount ON mount_revisions (mount_name);
+
+	CREATE TABLE IF NOT EXISTS mount_redirects (
+		from_name TEXT PRIMARY KEY,
+		mount_name TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_mount_redirects_mount ON mount_redirects (mount_name);
+
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		username TEXT NOT NULL,
+		idempotency_key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		etag TEXT NOT NULL DEFAULT '',
+		body BLOB,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (username, idempotency_key)
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys (created_at);
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit log is append-only');
+	END;
 	`)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error creating database table: %v", err), FatalLog)
 		return err
 	}
 
+	err = migrateDb(db)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error migrating database: %v", err), FatalLog)
+		return err
+	}
+
 	err = createAdminUser(db, config.AdminUsername, config.AdminPassword)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error creating admin: %v", err), FatalLog)
@@ -94,6 +327,99 @@ func initDb(db *sql.DB, config *Config) error {
 	return nil
 }
 
+// migrateDb adds columns that were introduced after the tables were first created.
+func migrateDb(db *sql.DB) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"icecast_mounts", "recording", "INTEGER NOT NULL DEFAULT 0"},
+		{"icecast_mounts", "recording_pattern", "TEXT NOT NULL DEFAULT ''"},
+		{"icecast_mounts", "recording_retention_days", "INTEGER NOT NULL DEFAULT 0"},
+		{"icecast_mounts", "podcast", "INTEGER NOT NULL DEFAULT 0"},
+		{"icecast_mounts", "deleted_at", "TIMESTAMP"},
+		{"icecast_mounts", "deleted_by", "TEXT NOT NULL DEFAULT ''"},
+		{"icecast_mounts", "version", "INTEGER NOT NULL DEFAULT 1"},
+	}
+	for _, c := range columns {
+		err := addColumnIfMissing(db, c.table, c.column, c.definition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info($1)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	logWithCaller(fmt.Sprintf("Adding column %s to table %s", column, table), InfoLog)
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+const icecastMountColumns = `mount_name, username, password, public, stream_name, stream_description, template_type,
+	recording, recording_pattern, recording_retention_days, podcast, version`
+
+const insertIcecastMountQuery = `
+	INSERT INTO icecast_mounts (mount_name, username, password, public, stream_name, stream_description, template_type,
+		recording, recording_pattern, recording_retention_days, podcast)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+func insertIcecastMountArgs(mount IcecastMount) []any {
+	return []any{mount.MountName, mount.Username, mount.Password, mount.Public, mount.StreamName, mount.StreamDescription, mount.TemplateType,
+		mount.Recording, mount.RecordingPattern, mount.RecordingRetentionDays, mount.Podcast}
+}
+
+// updateIcecastMountQuery only changes the mount if it has the version of
+// the mount or the version is 0.
+const updateIcecastMountQuery = `
+	UPDATE icecast_mounts
+	SET username = $1,
+	password = $2,
+	public = $3,
+	stream_name = $4,
+	stream_description = $5,
+	template_type = $6,
+	recording = $7,
+	recording_pattern = $8,
+	recording_retention_days = $9,
+	podcast = $10,
+	version = version + 1
+	WHERE mount_name = $11 AND deleted_at IS NULL AND ($12 = 0 OR version = $12)
+	`
+
+func updateIcecastMountArgs(mount IcecastMount) []any {
+	return []any{mount.Username, mount.Password, mount.Public, mount.StreamName, mount.StreamDescription, mount.TemplateType,
+		mount.Recording, mount.RecordingPattern, mount.RecordingRetentionDays, mount.Podcast, mount.MountName, mount.Version}
+}
+
+func scanIcecastMount(scanner interface{ Scan(...any) error }) (IcecastMount, error) {
+	var mount IcecastMount
+	err := scanner.Scan(&mount.MountName, &mount.Username, &mount.Password, &mount.Public, &mount.StreamName, &mount.StreamDescription, &mount.TemplateType,
+		&mount.Recording, &mount.RecordingPattern, &mount.RecordingRetentionDays, &mount.Podcast, &mount.Version)
+	return mount, err
+}
+
 func createAdminUser(db *sql.DB, username, password string) error {
 	logWithCaller("Creating admin user", InfoLog)
 
@@ -117,16 +443,14 @@ func createAdminUser(db *sql.DB, username, password string) error {
 }
 
 func (s *SqliteStorage) CreateIcecastMount(mount IcecastMount) error {
-	stmt, err := s.db.Prepare(`
-	INSERT INTO icecast_mounts (mount_name, username, password, public, stream_name, stream_description, template_type)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`)
+	defer observeQuery("CreateIcecastMount", time.Now())
+	stmt, err := s.db.Prepare(insertIcecastMountQuery)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Database error creating icecast_mounts prepared statement: %v", err), FatalLog)
 		return err
 	}
 
-	result, err := stmt.Exec(mount.MountName, mount.Username, mount.Password, mount.Public, mount.StreamName, mount.StreamDescription, mount.TemplateType)
+	result, err := stmt.Exec(insertIcecastMountArgs(mount)...)
 
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Database error excecutiong icecast_mounts prepared statement: %v", err), FatalLog)
@@ -144,17 +468,22 @@ func (s *SqliteStorage) CreateIcecastMount(mount IcecastMount) error {
 
 	return nil
 }
+
+// DeleteIcecastMount removes a mount from the trash for good, together with
+// its listener accounts and schedules.
 func (s *SqliteStorage) DeleteIcecastMount(mountName string) (IcecastMount, error) {
+	defer observeQuery("DeleteIcecastMount", time.Now())
 	logWithCaller(fmt.Sprintf("Deleting mount from Database: %s", mountName), InfoLog)
-	mount, err := s.GetIcecastMount(mountName)
+	trashed, err := s.GetTrashedIcecastMount(mountName)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error getting mount: %s %v", mountName, err), FatalLog)
 		return IcecastMount{}, err
 	}
+	mount := trashed.IcecastMount
 
 	stmt, err := s.db.Prepare(`
 	DELETE FROM icecast_mounts
-	WHERE mount_name = $1
+	WHERE mount_name = $1 AND deleted_at IS NOT NULL
 	`)
 
 	if err != nil {
@@ -179,23 +508,34 @@ func (s *SqliteStorage) DeleteIcecastMount(mountName string) (IcecastMount, erro
 	logWithCaller(fmt.Sprintf("Deleted mount: %s", mountName), InfoLog)
 	logWithCaller(fmt.Sprintf("Affected rows: %d", affectedRows), DebugLog)
 
+	_, err = s.db.Exec(`DELETE FROM listener_accounts WHERE mount_name = $1`, mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error deleting listener accounts of mount %s: %v", mountName, err), FatalLog)
+		return IcecastMount{}, err
+	}
+	_, err = s.db.Exec(`DELETE FROM schedules WHERE mount_name = $1`, mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error deleting schedules of mount %s: %v", mountName, err), FatalLog)
+		return IcecastMount{}, err
+	}
+
 	return mount, nil
 }
 func (s *SqliteStorage) GetIcecastMount(mountName string) (IcecastMount, error) {
+	defer observeQuery("GetIcecastMount", time.Now())
 	logWithCaller(fmt.Sprintf("Getting mount from Database: %s", mountName), InfoLog)
 
 	stmt, err := s.db.Prepare(`
-	SELECT mount_name, username, password, public, stream_name, stream_description, template_type
+	SELECT ` + icecastMountColumns + `
 	FROM icecast_mounts
-	WHERE mount_name = $1
+	WHERE mount_name = $1 AND deleted_at IS NULL
 	`)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
 		return IcecastMount{}, err
 	}
 	defer stmt.Close()
-	var mount IcecastMount
-	err = stmt.QueryRow(mountName).Scan(&mount.MountName, &mount.Username, &mount.Password, &mount.Public, &mount.StreamName, &mount.StreamDescription, &mount.TemplateType)
+	mount, err := scanIcecastMount(stmt.QueryRow(mountName))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			logWithCaller(fmt.Sprintf("No rows found for mount name: %s", mountName), DebugLog)
@@ -206,10 +546,13 @@ func (s *SqliteStorage) GetIcecastMount(mountName string) (IcecastMount, error)
 	return mount, nil
 }
 func (s *SqliteStorage) GetIcecastMounts() ([]IcecastMount, error) {
+	defer observeQuery("GetIcecastMounts", time.Now())
 	logWithCaller("Getting all mounts from Database", InfoLog)
 	stmt, err := s.db.Prepare(`
-	SELECT mount_name, username, password, public, stream_name, stream_description, template_type
+	SELECT ` + icecastMountColumns + `
 	FROM icecast_mounts
+	WHERE deleted_at IS NULL
+	ORDER BY mount_name
 	`)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
@@ -226,8 +569,7 @@ func (s *SqliteStorage) GetIcecastMounts() ([]IcecastMount, error) {
 	defer rows.Close()
 	var mounts []IcecastMount
 	for rows.Next() {
-		var mount IcecastMount
-		err = rows.Scan(&mount.MountName, &mount.Username, &mount.Password, &mount.Public, &mount.StreamName, &mount.StreamDescription, &mount.TemplateType)
+		mount, err := scanIcecastMount(rows)
 		if err != nil {
 			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
 
@@ -244,25 +586,20 @@ func (s *SqliteStorage) GetIcecastMounts() ([]IcecastMount, error) {
 
 	return mounts, nil
 }
+
+// UpdateIcecastMount stores the mount and increases its version. With a
+// version set the mount is only changed if it still has this version.
 func (s *SqliteStorage) UpdateIcecastMount(mount IcecastMount) error {
+	defer observeQuery("UpdateIcecastMount", time.Now())
 	logWithCaller(fmt.Sprintf("Updating mount in Database: %s", mount.MountName), InfoLog)
 
-	stmt, err := s.db.Prepare(`
-	UPDATE icecast_mounts
-	SET username = $1,
-	password = $2,
-	public = $3,
-	stream_name = $4,
-	stream_description = $5,
-	template_type = $6
-	WHERE mount_name = $7
-	`)
+	stmt, err := s.db.Prepare(updateIcecastMountQuery)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
 		return err
 	}
 	defer stmt.Close()
-	result, err := stmt.Exec(mount.Username, mount.Password, mount.Public, mount.StreamName, mount.StreamDescription, mount.TemplateType, mount.MountName)
+	result, err := stmt.Exec(updateIcecastMountArgs(mount)...)
 	if err != nil {
 		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
 		return err
@@ -276,9 +613,13 @@ func (s *SqliteStorage) UpdateIcecastMount(mount IcecastMount) error {
 	}
 	logWithCaller(fmt.Sprintf("Affected rows: %d", affecttedRows), DebugLog)
 
+	if affecttedRows == 0 && mount.Version != 0 {
+		logWithCaller(fmt.Sprintf("Mount %s changed since version %d", mount.MountName, mount.Version), WarnLog)
+		return fmt.Errorf("%w: mount %s version %d", ErrVersionMismatch, mount.MountName, mount.Version)
+	}
 	if affecttedRows != 1 {
 		logWithCaller(fmt.Sprintf("Affected rows %d for: %s", affecttedRows, mount.MountName), FatalLog)
-		return fmt.Errorf("affected rows %d for: %s", affecttedRows, mount.MountName)
+		return affectedRowsError(affecttedRows, "mount", mount.MountName)
 	}
 
 	logWithCaller(fmt.Sprintf("Updated mount in database: %s", mount.MountName), InfoLog)
@@ -287,6 +628,7 @@ func (s *SqliteStorage) UpdateIcecastMount(mount IcecastMount) error {
 }
 
 func (s *SqliteStorage) SaveUser(username, hashedPassword string) error {
+	defer observeQuery("SaveUser", time.Now())
 	logWithCaller(fmt.Sprintf("Saving user to database: %s", username), InfoLog)
 	stmt, err := s.db.Prepare(`
 	INSERT INTO users (username, password)
@@ -309,6 +651,7 @@ func (s *SqliteStorage) SaveUser(username, hashedPassword string) error {
 }
 
 func (s *SqliteStorage) GetUser(username string) (string, error) {
+	defer observeQuery("GetUser", time.Now())
 	logWithCaller(fmt.Sprintf("Getting user from database: %s", username), InfoLog)
 	stmt, err := s.db.Prepare(`
 	SELECT password
@@ -332,7 +675,7 @@ func (s *SqliteStorage) GetUser(username string) (string, error) {
 	return hashedPassword, nil
 }
 func (s *SqliteStorage) DeleteUser(username string) error {
-
+	defer observeQuery("DeleteUser", time.Now())
 	tokenDelStmt, err := s.db.Prepare(`
 	DELETE FROM token
 	WHERE user_id = (SELECT id FROM users WHERE username = $1)
@@ -365,6 +708,7 @@ func (s *SqliteStorage) DeleteUser(username string) error {
 	return nil
 }
 func (s *SqliteStorage) GetUserByToken(tokenHash string) (string, error) {
+	defer observeQuery("GetUserByToken", time.Now())
 	logWithCaller("Getting user by token from database", InfoLog)
 	stmt, err := s.db.Prepare(`
 	SELECT username
@@ -380,7 +724,7 @@ func (s *SqliteStorage) GetUserByToken(tokenHash string) (string, error) {
 	err = stmt.QueryRow(tokenHash).Scan(&username)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			logWithCaller(fmt.Sprintf("No rows found for token: %s", tokenHash), FatalLog)
+			logWithCaller("No user found for token", FatalLog)
 			return "", err
 		}
 		logWithCaller(fmt.Sprintf("Error scanning row: %s", err.Error()), WarnLog)
@@ -391,6 +735,7 @@ func (s *SqliteStorage) GetUserByToken(tokenHash string) (string, error) {
 }
 
 func (s *SqliteStorage) GetTokenByUser(username string) (string, error) {
+	defer observeQuery("GetTokenByUser", time.Now())
 	logWithCaller(fmt.Sprintf("Getting token from database for user: %s", username), InfoLog)
 	stmt, err := s.db.Prepare(`
 	SELECT token_hash
@@ -416,8 +761,31 @@ func (s *SqliteStorage) GetTokenByUser(username string) (string, error) {
 	return token_hash, nil
 }
 
+// GetUserStream returns the mount a user owns or an empty string.
+func (s *SqliteStorage) GetUserStream(username string) (string, error) {
+	defer observeQuery("GetUserStream", time.Now())
+	logWithCaller(fmt.Sprintf("Getting stream of user: %s", username), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT icecast_mount
+	FROM users
+	WHERE username = $1
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %s", err.Error()), FatalLog)
+		return "", err
+	}
+	defer stmt.Close()
+	var mountName sql.NullString
+	err = stmt.QueryRow(username).Scan(&mountName)
+	if err != nil {
+		return "", err
+	}
+	return mountName.String, nil
+}
+
 // SaveToken saves the token hash for a user. Token must be a hash.
 func (s *SqliteStorage) SaveToken(username, token_hash string) error {
+	defer observeQuery("SaveToken", time.Now())
 	insertToken, err := s.db.Prepare(`
 	INSERT INTO token (user_id, token_hash)
 	VALUES ((SELECT id FROM users WHERE username = $1) ,$2)
@@ -434,7 +802,7 @@ func (s *SqliteStorage) SaveToken(username, token_hash string) error {
 		return err
 	}
 
-	logWithCaller(fmt.Sprintf("Inserted hash %s for user: %s", token_hash, username), DebugLog)
+	logWithCaller(fmt.Sprintf("Inserted token hash for user: %s", username), DebugLog)
 
 	return nil
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxImportBodySize limits import bodies, they hold many streams.
+const maxImportBodySize = 4 << 20
+
+const (
+	ImportConflictSkip      = "skip"
+	ImportConflictOverwrite = "overwrite"
+	ImportConflictFail      = "fail"
+)
+
+const (
+	ImportStatusCreated  = "created"
+	ImportStatusUpdated  = "updated"
+	ImportStatusSkipped  = "skipped"
+	ImportStatusConflict = "conflict"
+	ImportStatusInvalid  = "invalid"
+	ImportStatusFailed   = "failed"
+)
+
+// ImportItem reports what happened to one stream of an import. In a dry run
+// the status is what would happen.
+type ImportItem struct {
+	MountName string       `json:"mount_name"`
+	Status    string       `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+
+	mount  IcecastMount
+	before IcecastMount
+}
+
+type ImportReport struct {
+	DryRun     bool         `json:"dry_run"`
+	OnConflict string       `json:"on_conflict"`
+	Created    int          `json:"created"`
+	Updated    int          `json:"updated"`
+	Skipped    int          `json:"skipped"`
+	Failed     int          `json:"failed"`
+	Items      []ImportItem `json:"items"`
+}
+
+func (s *ApiServer) addImportRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"streams/export", makeHTTPHandleFunc(s.handleExportStreams))
+	addToRouteRightsMap("GET "+autherized+"streams/export", "get_all_streams")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"streams/import", makeHTTPHandleFunc(s.idempotent(s.handleImportStreams)))
+	addToRouteRightsMap("POST "+autherized+"streams/import", "post_stream")
+}
+
+// handleExportStreams returns all streams including their passwords in the
+// format the import reads, JSON or with format=yaml YAML.
+func (s *ApiServer) handleExportStreams(w http.ResponseWriter, r *http.Request) error {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "yaml" {
+		return fmt.Errorf("format must be json or yaml")
+	}
+
+	mounts, err := s.storage.GetIcecastMounts()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching icecast mounts: %s", err), WarnLog)
+		return databaseError(err, "stream")
+	}
+	if mounts == nil {
+		mounts = []IcecastMount{}
+	}
+	logRequest(r, fmt.Sprintf("Exporting %d streams as %s", len(mounts), format), InfoLog)
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="streams.%s"`, format))
+	if format == "json" {
+		return WriteJson(w, http.StatusOK, mounts)
+	}
+
+	data, err := yaml.Marshal(mounts)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Error encoding streams as YAML: %s", err), WarnLog)
+		return internalError("encoding error")
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(data)
+	return err
+}
+
+// handleImportStreams creates the streams of a JSON or YAML export or of the
+// <mount> sections of an Icecast config. on_conflict decides what happens to
+// streams that exist already, with fail nothing is imported if one exists or
+// is invalid. With dry_run=true only the report is returned.
+func (s *ApiServer) handleImportStreams(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	report := ImportReport{OnConflict: query.Get("on_conflict")}
+	if report.OnConflict == "" {
+		report.OnConflict = ImportConflictSkip
+	}
+	if report.OnConflict != ImportConflictSkip && report.OnConflict != ImportConflictOverwrite && report.OnConflict != ImportConflictFail {
+		return fmt.Errorf("on_conflict must be %s, %s or %s", ImportConflictSkip, ImportConflictOverwrite, ImportConflictFail)
+	}
+	if value := query.Get("dry_run"); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("dry_run must be true or false")
+		}
+		report.DryRun = dryRun
+	}
+
+	mounts, err := readImport(w, r)
+	if err != nil {
+		return err
+	}
+	logRequest(r, fmt.Sprintf("Importing %d streams, on conflict %s, dry run %t", len(mounts), report.OnConflict, report.DryRun), InfoLog)
+
+	status := http.StatusOK
+	seen := map[string]bool{}
+	for _, mount := range mounts {
+		item, err := s.planImport(mount, report.OnConflict, seen)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Database error planning import of %s: %s", mount.MountName, err), WarnLog)
+			return databaseError(err, "stream")
+		}
+		report.Items = append(report.Items, item)
+		if report.OnConflict == ImportConflictFail {
+			switch item.Status {
+			case ImportStatusInvalid:
+				status = http.StatusUnprocessableEntity
+			case ImportStatusConflict:
+				if status == http.StatusOK {
+					status = http.StatusConflict
+				}
+			}
+		}
+	}
+
+	for i := range report.Items {
+		item := &report.Items[i]
+		switch {
+		case status != http.StatusOK && (item.Status == ImportStatusCreated || item.Status == ImportStatusUpdated):
+			item.Status, item.Error = ImportStatusSkipped, "not imported because of the other streams"
+		case status == http.StatusOK && !report.DryRun:
+			s.applyImport(r, item)
+		}
+	}
+
+	for _, item := range report.Items {
+		switch item.Status {
+		case ImportStatusCreated:
+			report.Created++
+		case ImportStatusUpdated:
+			report.Updated++
+		case ImportStatusSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+
+	setAuditTarget(r, "streams/import")
+	if !report.DryRun && status == http.StatusOK {
+		setAuditChange(r, nil, report)
+	}
+	return WriteJson(w, status, report)
+}
+
+// readImport reads the streams of the body by its content type.
+func readImport(w http.ResponseWriter, r *http.Request) ([]IcecastMount, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var mounts []IcecastMount
+	switch mediaType {
+	case "", "application/json":
+		err := decodeJSONLimit(w, r, &mounts, maxImportBodySize)
+		if err != nil {
+			return nil, err
+		}
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		data, err := readImportBody(w, r)
+		if err != nil {
+			return nil, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&mounts)
+		if err != nil && err != io.EOF {
+			logRequest(r, fmt.Sprintf("YAML error decoding request body: %s", err), WarnLog)
+			return nil, fmt.Errorf("invalid YAML: %s", err)
+		}
+	case "application/xml", "text/xml":
+		data, err := readImportBody(w, r)
+		if err != nil {
+			return nil, err
+		}
+		mounts, err = parseIcecastMounts(data)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("XML error decoding request body: %s", err), WarnLog)
+			return nil, err
+		}
+	default:
+		return nil, &StatusError{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    ErrorCodeUnsupportedMediaType,
+			Message: "content type must be application/json, application/yaml or application/xml",
+		}
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no streams to import")
+	}
+	return mounts, nil
+}
+
+func readImportBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodySize)
+	defer r.Body.Close()
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, jsonError(err)
+	}
+	return data, nil
+}
+
+// planImport decides what to do with a stream without changing anything.
+func (s *ApiServer) planImport(mount IcecastMount, onConflict string, seen map[string]bool) (ImportItem, error) {
+	item := ImportItem{MountName: mount.MountName}
+
+	err := mount.Validate()
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		item.Status, item.Error, item.Fields = ImportStatusInvalid, statusErr.Message, statusErr.Fields
+		return item, nil
+	}
+	if seen[mount.MountName] {
+		item.Status, item.Error = ImportStatusInvalid, "stream appears more than once"
+		return item, nil
+	}
+	seen[mount.MountName] = true
+	item.mount = mount
+
+	_, err = s.storage.GetTrashedIcecastMount(mount.MountName)
+	if err == nil {
+		item.Status, item.Error = ImportStatusConflict, "stream is in the trash, restore or purge it first"
+		return item, nil
+	}
+
+	before, err := s.storage.GetIcecastMount(mount.MountName)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		item.Status = ImportStatusCreated
+	case err != nil:
+		return ImportItem{}, err
+	case onConflict == ImportConflictOverwrite:
+		item.Status, item.before = ImportStatusUpdated, before
+	case onConflict == ImportConflictSkip:
+		item.Status, item.Error = ImportStatusSkipped, "stream exists"
+	default:
+		item.Status, item.Error = ImportStatusConflict, "stream exists"
+	}
+	return item, nil
+}
+
+// applyImport creates or overwrites the stream of a planned item. Errors are
+// reported in the item, the other streams are imported anyway.
+func (s *ApiServer) applyImport(r *http.Request, item *ImportItem) {
+	var err error
+	switch item.Status {
+	case ImportStatusCreated:
+		_, err = s.createStream(r, item.mount)
+	case ImportStatusUpdated:
+		_, err = s.saveStream(r, item.before, item.mount, RevisionReasonImport)
+	default:
+		return
+	}
+	if err != nil {
+		item.Status, item.Error = ImportStatusFailed, err.Error()
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	streamMaxLimit = 500
+
+	totalCountHeader = "X-Total-Count"
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+// mountSortColumns maps the sort parameter to the column streams are sorted
+// by. Ties are broken by the mount name.
+var mountSortColumns = map[string]string{
+	"mount_name":  "mount_name",
+	"stream_name": "stream_name",
+	"created":     "id",
+}
+
+// MountFilter selects a page of streams. A limit of 0 returns all streams.
+type MountFilter struct {
+	Public       *int
+	TemplateType TemplateType
+	Search       string
+	Sort         string
+	Descending   bool
+	Cursor       *MountCursor
+	Limit        int
+}
+
+// MountCursor is the position after the last stream of a page.
+type MountCursor struct {
+	SortValue any    `json:"v"`
+	MountName string `json:"m"`
+}
+
+// MountPage is a page of streams with the number of all matching streams.
+type MountPage struct {
+	Mounts     []IcecastMount
+	Total      int
+	NextCursor *MountCursor
+}
+
+func (cursor MountCursor) encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMountCursor(value string) (*MountCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor MountCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.MountName == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	switch cursor.SortValue.(type) {
+	case string, float64:
+	default:
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// getMountFilter reads limit, cursor, sort, public, template_type and q.
+func getMountFilter(r *http.Request) (MountFilter, error) {
+	query := r.URL.Query()
+	filter := MountFilter{
+		TemplateType: TemplateType(query.Get("template_type")),
+		Search:       strings.TrimSpace(query.Get("q")),
+		Sort:         "mount_name",
+	}
+
+	if value := query.Get("sort"); value != "" {
+		filter.Descending = strings.HasPrefix(value, "-")
+		filter.Sort = strings.TrimPrefix(value, "-")
+		if _, ok := mountSortColumns[filter.Sort]; !ok {
+			return MountFilter{}, fmt.Errorf("sort must be mount_name, stream_name or created, with - for descending order")
+		}
+	}
+	if value := query.Get("public"); value != "" {
+		public, err := strconv.Atoi(value)
+		if err != nil || (public != 0 && public != 1) {
+			return MountFilter{}, fmt.Errorf("public must be 0 or 1")
+		}
+		filter.Public = &public
+	}
+	if filter.TemplateType != "" && filter.TemplateType != DefaultTemplate && filter.TemplateType != PrivateTemplate {
+		return MountFilter{}, fmt.Errorf("template_type must be %s or %s", DefaultTemplate, PrivateTemplate)
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > streamMaxLimit {
+			return MountFilter{}, fmt.Errorf("limit must be between 1 and %d", streamMaxLimit)
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeMountCursor(value)
+		if err != nil {
+			return MountFilter{}, err
+		}
+		filter.Cursor = cursor
+	}
+	return filter, nil
+}
+
+// handleGetAllStreams lists the streams matching the filter. The body stays
+// a plain array, the total count and the cursor of the next page are sent as
+// headers.
+func (s *ApiServer) handleGetAllStreams(w http.ResponseWriter, r *http.Request) error {
+	filter, err := getMountFilter(r)
+	if err != nil {
+		return err
+	}
+
+	page, err := s.storage.GetIcecastMountPage(filter)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching icecast mounts: %s", err), WarnLog)
+		return databaseError(err, "stream")
+	}
+
+	w.Header().Set(totalCountHeader, strconv.Itoa(page.Total))
+	if page.NextCursor != nil {
+		w.Header().Set(nextCursorHeader, page.NextCursor.encode())
+	}
+	return WriteJson(w, http.StatusOK, page.Mounts)
+}
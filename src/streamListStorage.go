@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sortKeyScanner scans the sort column selected after the mount columns, the
+// cursor of the next page is built from it.
+type sortKeyScanner struct {
+	scanner interface{ Scan(...any) error }
+	key     *any
+}
+
+func (s sortKeyScanner) Scan(dest ...any) error {
+	return s.scanner.Scan(append(dest, s.key)...)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, the statement has to
+// use ESCAPE '\'.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// mountFilterWhere builds the WHERE clause shared by the page and the count.
+// Every word of the search has to appear in the name, title or description.
+func mountFilterWhere(filter MountFilter, args *[]any) string {
+	arg := func(value any) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	if filter.Public != nil {
+		conditions = append(conditions, "public = "+arg(*filter.Public))
+	}
+	if filter.TemplateType != "" {
+		conditions = append(conditions, "template_type = "+arg(filter.TemplateType))
+	}
+	for _, word := range strings.Fields(filter.Search) {
+		pattern := arg("%" + escapeLike(word) + "%")
+		conditions = append(conditions, fmt.Sprintf(
+			`(mount_name LIKE %[1]s ESCAPE '\' OR stream_name LIKE %[1]s ESCAPE '\' OR stream_description LIKE %[1]s ESCAPE '\')`,
+			pattern))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// GetIcecastMountPage returns the mounts matching the filter, sorted and
+// limited, together with the number of all matching mounts.
+func (s *SqliteStorage) GetIcecastMountPage(filter MountFilter) (MountPage, error) {
+	defer observeQuery("GetIcecastMountPage", time.Now())
+	logWithCaller(fmt.Sprintf("Getting mounts from Database: %+v", filter), DebugLog)
+	column, ok := mountSortColumns[filter.Sort]
+	if !ok {
+		column = "mount_name"
+	}
+
+	var countArgs []any
+	where := mountFilterWhere(filter, &countArgs)
+	page := MountPage{Mounts: []IcecastMount{}}
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM icecast_mounts WHERE `+where, countArgs...).Scan(&page.Total)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error counting mounts: %v", err), FatalLog)
+		return MountPage{}, err
+	}
+
+	args := countArgs
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.Cursor != nil {
+		args = append(args, filter.Cursor.SortValue, filter.Cursor.MountName)
+		where += fmt.Sprintf(" AND (%s, mount_name) %s ($%d, $%d)", column, comparison, len(args)-1, len(args))
+	}
+	query := `
+	SELECT ` + icecastMountColumns + `, ` + column + `
+	FROM icecast_mounts
+	WHERE ` + where + `
+	ORDER BY ` + column + ` ` + direction + `, mount_name ` + direction
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return MountPage{}, err
+	}
+	defer rows.Close()
+
+	var lastKey any
+	for rows.Next() {
+		var key any
+		mount, err := scanIcecastMount(sortKeyScanner{rows, &key})
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return MountPage{}, err
+		}
+		if filter.Limit > 0 && len(page.Mounts) == filter.Limit {
+			last := page.Mounts[len(page.Mounts)-1]
+			page.NextCursor = &MountCursor{SortValue: lastKey, MountName: last.MountName}
+			break
+		}
+		page.Mounts = append(page.Mounts, mount)
+		lastKey = key
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return MountPage{}, err
+	}
+	logWithCaller(fmt.Sprintf("Found %d of %d mounts", len(page.Mounts), page.Total), DebugLog)
+	return page, nil
+}
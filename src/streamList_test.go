@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetIcecastMountPage(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	mounts := []IcecastMount{
+		{MountName: "st-anna", StreamName: "St. Anna", StreamDescription: "Gottesdienst aus St. Anna", Public: 1},
+		{MountName: "st-peter", StreamName: "St. Peter", StreamDescription: "100% Gottesdienst", Public: 0, TemplateType: PrivateTemplate},
+		{MountName: "dom", StreamName: "Dom", StreamDescription: "Vesper im Dom", Public: 1},
+	}
+	for _, mount := range mounts {
+		mount.Username, mount.Password = "source", "secret"
+		if mount.TemplateType == "" {
+			mount.TemplateType = DefaultTemplate
+		}
+		if err := storage.CreateIcecastMount(mount); err != nil {
+			t.Fatalf("Failed to create mount: %v", err)
+		}
+	}
+
+	names := func(page MountPage) []string {
+		var names []string
+		for _, mount := range page.Mounts {
+			names = append(names, mount.MountName)
+		}
+		return names
+	}
+
+	// Walk the pages through the encoded cursor
+	var seen []string
+	filter := MountFilter{Sort: "created", Descending: true, Limit: 2}
+	for {
+		page, err := storage.GetIcecastMountPage(filter)
+		if err != nil {
+			t.Fatalf("Failed to get page: %v", err)
+		}
+		if page.Total != 3 {
+			t.Fatalf("Expected a total of 3, got %d", page.Total)
+		}
+		seen = append(seen, names(page)...)
+		if page.NextCursor == nil {
+			break
+		}
+		filter.Cursor, err = decodeMountCursor(page.NextCursor.encode())
+		if err != nil {
+			t.Fatalf("Failed to decode cursor: %v", err)
+		}
+	}
+	if len(seen) != 3 || seen[0] != "dom" || seen[1] != "st-peter" || seen[2] != "st-anna" {
+		t.Fatalf("Unexpected order: %v", seen)
+	}
+
+	tests := []struct {
+		filter   MountFilter
+		expected int
+	}{
+		{MountFilter{Search: "gottesdienst st."}, 2},
+		{MountFilter{Search: "100%"}, 1},
+		{MountFilter{Search: "_"}, 0},
+		{MountFilter{Public: new(int)}, 1},
+		{MountFilter{TemplateType: DefaultTemplate, Search: "dom"}, 1},
+	}
+	for _, test := range tests {
+		page, err := storage.GetIcecastMountPage(test.filter)
+		if err != nil || len(page.Mounts) != test.expected || page.Total != test.expected {
+			t.Fatalf("Filter %+v: expected %d mounts, got %v %d %v", test.filter, test.expected, names(page), page.Total, err)
+		}
+	}
+}
+
+func TestGetMountFilter(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=501", "sort=password", "public=2", "template_type=other", "cursor=abc"} {
+		r := httptest.NewRequest("GET", "/api/streams?"+query, nil)
+		if _, err := getMountFilter(r); err == nil {
+			t.Fatalf("Expected an error for %s", query)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/api/streams?sort=-stream_name&limit=10&public=1", nil)
+	filter, err := getMountFilter(r)
+	if err != nil || filter.Sort != "stream_name" || !filter.Descending || filter.Limit != 10 || *filter.Public != 1 {
+		t.Fatalf("Unexpected filter: %+v %v", filter, err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	trashPurgeInterval        = time.Hour
+	defaultTrashRetentionDays = 30
+)
+
+// TrashedMount is a deleted mount that can be restored until it is purged.
+type TrashedMount struct {
+	IcecastMount
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by"`
+	PurgeAt   time.Time `json:"purge_at"`
+}
+
+func (s *ApiServer) trashRetention() time.Duration {
+	days := s.config.TrashRetentionDays
+	if days <= 0 {
+		days = defaultTrashRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RunTrashPurge purges the mounts whose retention in the trash is over every
+// interval. It never returns.
+func (s *ApiServer) RunTrashPurge(interval time.Duration) {
+	logWithCaller(fmt.Sprintf("Starting trash purge with interval %s and retention %s", interval, s.trashRetention()), InfoLog)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.purgeTrash(time.Now())
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error purging trash: %s", err), WarnLog)
+		}
+		<-ticker.C
+	}
+}
+
+func (s *ApiServer) purgeTrash(now time.Time) error {
+	mounts, err := s.storage.GetTrashedIcecastMounts()
+	if err != nil {
+		return err
+	}
+	for _, trashed := range mounts {
+		if trashed.DeletedAt.Add(s.trashRetention()).After(now) {
+			continue
+		}
+		logWithCaller(fmt.Sprintf("Purging mount %s, deleted at %s", trashed.MountName, trashed.DeletedAt), InfoLog)
+		err = s.removeRedirects(trashed.MountName)
+		if err != nil {
+			return err
+		}
+		_, err = s.storage.DeleteIcecastMount(trashed.MountName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ApiServer) addTrashRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"trash/streams", makeHTTPHandleFunc(s.handleGetTrash))
+	addToRouteRightsMap("GET "+autherized+"trash/streams", "get_all_streams")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"trash/streams/{streamName}/restore", makeHTTPHandleFunc(s.handleRestoreStream))
+	addToRouteRightsMap("POST "+autherized+"trash/streams/{streamName}/restore", "post_stream")
+
+	autherizedRouter.HandleFunc("DELETE "+autherized+"trash/streams/{streamName}", makeHTTPHandleFunc(s.handlePurgeStream))
+	addToRouteRightsMap("DELETE "+autherized+"trash/streams/{streamName}", "delete_stream")
+}
+
+func (s *ApiServer) handleGetTrash(w http.ResponseWriter, r *http.Request) error {
+	mounts, err := s.storage.GetTrashedIcecastMounts()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching trash: %s", err), WarnLog)
+		return databaseError(err, "stream")
+	}
+	for i := range mounts {
+		mounts[i].IcecastMount = mounts[i].IcecastMount.redacted()
+		mounts[i].PurgeAt = mounts[i].DeletedAt.Add(s.trashRetention())
+	}
+	return WriteJson(w, http.StatusOK, mounts)
+}
+
+// handleRestoreStream takes a mount out of the trash and writes its Icecast
+// config and listener accounts again.
+func (s *ApiServer) handleRestoreStream(w http.ResponseWriter, r *http.Request) error {
+	mountName := r.PathValue("streamName")
+	logRequest(r, fmt.Sprintf("Restoring stream %s from trash", mountName), InfoLog)
+
+	mount, err := s.storage.RestoreIcecastMount(mountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error restoring icecast mount: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "stream")
+	}
+
+	err = s.scheduler.ApplyMountConfig(mount)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Config error restoring icecast mount: %s %s", mountName, err), WarnLog)
+		return internalError("file error")
+	}
+	if mount.TemplateType == PrivateTemplate {
+		err = s.refreshListenerAuth(mountName)
+		if err != nil {
+			return err
+		}
+	}
+
+	setAuditChange(r, nil, mount)
+	s.events.Publish(EventStreamRestored, mountName, mount.redacted())
+
+	setMountETag(w, mount)
+	return WriteJson(w, http.StatusOK, mount)
+}
+
+// handlePurgeStream deletes a mount in the trash for good.
+func (s *ApiServer) handlePurgeStream(w http.ResponseWriter, r *http.Request) error {
+	mountName := r.PathValue("streamName")
+	logRequest(r, fmt.Sprintf("Purging stream %s from trash", mountName), InfoLog)
+
+	_, err := s.storage.GetTrashedIcecastMount(mountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching icecast mount: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "stream")
+	}
+	err = s.removeRedirects(mountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Error deleting redirects: %s %s", mountName, err), WarnLog)
+		return internalError("file error")
+	}
+
+	mount, err := s.storage.DeleteIcecastMount(mountName)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error purging icecast mount: %s %s", mountName, err), WarnLog)
+		return databaseError(err, "stream")
+	}
+
+	setAuditChange(r, mount, nil)
+	return WriteJson(w, http.StatusOK, map[string]string{"status": "purged"})
+}
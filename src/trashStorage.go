@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const trashedMountColumns = icecastMountColumns + `, deleted_at, deleted_by`
+
+func scanTrashedMount(scanner interface{ Scan(...any) error }) (TrashedMount, error) {
+	var trashed TrashedMount
+	mount := &trashed.IcecastMount
+	err := scanner.Scan(&mount.MountName, &mount.Username, &mount.Password, &mount.Public, &mount.StreamName, &mount.StreamDescription, &mount.TemplateType,
+		&mount.Recording, &mount.RecordingPattern, &mount.RecordingRetentionDays, &mount.Podcast, &mount.Version, &trashed.DeletedAt, &trashed.DeletedBy)
+	return trashed, err
+}
+
+// setMountDeleted moves a mount into the trash or, with a nil time, out of it.
+func (s *SqliteStorage) setMountDeleted(mountName string, deletedAt *time.Time, deletedBy string) error {
+	condition := "deleted_at IS NULL"
+	if deletedAt == nil {
+		condition = "deleted_at IS NOT NULL"
+	}
+	stmt, err := s.db.Prepare(`
+	UPDATE icecast_mounts
+	SET deleted_at = $1,
+	deleted_by = $2
+	WHERE mount_name = $3 AND ` + condition)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(nullableTime(deletedAt), deletedBy, mountName)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for: %s ", affectedRows, mountName), FatalLog)
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TrashIcecastMount moves a mount into the trash. Its listener accounts and
+// schedules are kept until it is purged.
+func (s *SqliteStorage) TrashIcecastMount(mountName, deletedBy string) (IcecastMount, error) {
+	defer observeQuery("TrashIcecastMount", time.Now())
+	logWithCaller(fmt.Sprintf("Moving mount to trash: %s", mountName), InfoLog)
+	mount, err := s.GetIcecastMount(mountName)
+	if err != nil {
+		return IcecastMount{}, err
+	}
+	now := time.Now()
+	err = s.setMountDeleted(mountName, &now, deletedBy)
+	if err != nil {
+		return IcecastMount{}, err
+	}
+	return mount, nil
+}
+
+func (s *SqliteStorage) RestoreIcecastMount(mountName string) (IcecastMount, error) {
+	defer observeQuery("RestoreIcecastMount", time.Now())
+	logWithCaller(fmt.Sprintf("Restoring mount from trash: %s", mountName), InfoLog)
+	err := s.setMountDeleted(mountName, nil, "")
+	if err != nil {
+		return IcecastMount{}, err
+	}
+	return s.GetIcecastMount(mountName)
+}
+
+func (s *SqliteStorage) GetTrashedIcecastMount(mountName string) (TrashedMount, error) {
+	defer observeQuery("GetTrashedIcecastMount", time.Now())
+	logWithCaller(fmt.Sprintf("Getting mount from trash: %s", mountName), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + trashedMountColumns + `
+	FROM icecast_mounts
+	WHERE mount_name = $1 AND deleted_at IS NOT NULL
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return TrashedMount{}, err
+	}
+	defer stmt.Close()
+
+	return scanTrashedMount(stmt.QueryRow(mountName))
+}
+
+// GetTrashedIcecastMounts returns the mounts in the trash, oldest deletion first.
+func (s *SqliteStorage) GetTrashedIcecastMounts() ([]TrashedMount, error) {
+	defer observeQuery("GetTrashedIcecastMounts", time.Now())
+	stmt, err := s.db.Prepare(`
+	SELECT ` + trashedMountColumns + `
+	FROM icecast_mounts
+	WHERE deleted_at IS NOT NULL
+	ORDER BY deleted_at
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	mounts := []TrashedMount{}
+	for rows.Next() {
+		trashed, err := scanTrashedMount(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		mounts = append(mounts, trashed)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return mounts, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrashIcecastMount(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	s := &ApiServer{storage: storage, config: Config{TrashRetentionDays: 7}}
+	for _, mountName := range []string{"ostern", "pfingsten"} {
+		mount := IcecastMount{MountName: mountName, Username: "source", Password: "secret", TemplateType: DefaultTemplate}
+		if err := storage.CreateIcecastMount(mount); err != nil {
+			t.Fatalf("Failed to create mount: %v", err)
+		}
+		if _, err := storage.TrashIcecastMount(mountName, "admin"); err != nil {
+			t.Fatalf("Failed to trash mount: %v", err)
+		}
+	}
+
+	if _, err := storage.GetIcecastMount("ostern"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected a trashed mount to be hidden, got %v", err)
+	}
+	if _, err := storage.TrashIcecastMount("ostern", "admin"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected trashing a trashed mount to fail, got %v", err)
+	}
+	trashed, err := storage.GetTrashedIcecastMount("ostern")
+	if err != nil || trashed.DeletedBy != "admin" || trashed.DeletedAt.IsZero() {
+		t.Fatalf("Expected the mount in the trash, got %+v %v", trashed, err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/streams", nil)
+	_, err = s.createStream(r, IcecastMount{MountName: "ostern", Username: "source", Password: "secret", TemplateType: DefaultTemplate})
+	if status, body := apiErrorResponse(err); status != http.StatusConflict || body.Error != "stream ostern is in the trash, restore or purge it first" {
+		t.Fatalf("Expected a conflict creating a mount in the trash, got %d %+v", status, body)
+	}
+
+	restored, err := storage.RestoreIcecastMount("ostern")
+	if err != nil || restored.MountName != "ostern" || restored.Password != "secret" {
+		t.Fatalf("Failed to restore mount: %+v %v", restored, err)
+	}
+	if _, err := storage.RestoreIcecastMount("ostern"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected restoring a mount outside the trash to fail, got %v", err)
+	}
+
+	if err := s.purgeTrash(time.Now().AddDate(0, 0, 6)); err != nil {
+		t.Fatalf("Failed to purge trash: %v", err)
+	}
+	if _, err := storage.GetTrashedIcecastMount("pfingsten"); err != nil {
+		t.Fatalf("Expected the mount to stay in the trash within the retention, got %v", err)
+	}
+	if err := s.purgeTrash(time.Now().AddDate(0, 0, 8)); err != nil {
+		t.Fatalf("Failed to purge trash: %v", err)
+	}
+	if _, err := storage.GetTrashedIcecastMount("pfingsten"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected the mount to be purged after the retention, got %v", err)
+	}
+	if _, err := storage.GetIcecastMount("ostern"); err != nil {
+		t.Fatalf("Expected the restored mount to stay, got %v", err)
+	}
+}
@@ -8,15 +8,98 @@ type Config struct {
 	SecretKey            string `yaml:"secret_key"`
 	AdminUsername        string `yaml:"admin_username"`
 	AdminPassword        string `yaml:"admin_password"`
+
+	// ListenerAuthType selects how listener accounts of private mounts are
+	// handed to Icecast: "htpasswd" (default) or "url".
+	ListenerAuthType string `yaml:"listener_auth_type"`
+	// ListenerAuthURL is the base URL under which Icecast reaches this API for url authentication.
+	ListenerAuthURL string `yaml:"listener_auth_url"`
+	// ListenerAuthSecret is appended to the url authentication callback and checked on every call.
+	ListenerAuthSecret string `yaml:"listener_auth_secret"`
+	// ListenerHtpasswdFolder is where htpasswd files are written. Defaults to the mounts folder.
+	ListenerHtpasswdFolder string `yaml:"listener_htpasswd_folder"`
+
+	// DefaultTimezone is used for schedules created without timezone. Defaults to UTC.
+	DefaultTimezone string `yaml:"default_timezone"`
+
+	// RecordingsFolder is the archive Icecast writes dump files to, as seen by this API.
+	RecordingsFolder string `yaml:"recordings_folder"`
+	// IcecastRecordingsFolder is the same folder as seen by Icecast. Defaults to RecordingsFolder.
+	IcecastRecordingsFolder string `yaml:"icecast_recordings_folder"`
+	// RecordingRetentionDays deletes recordings older than this. 0 keeps them forever.
+	RecordingRetentionDays int `yaml:"recording_retention_days"`
+
+	// IcecastStatusURL is the status-json.xsl of Icecast, used to find out which mounts are live.
+	IcecastStatusURL string `yaml:"icecast_status_url"`
+	// IcecastPollIntervalSeconds defaults to 15 seconds.
+	IcecastPollIntervalSeconds int `yaml:"icecast_poll_interval_seconds"`
+
+	// IcecastPublicURL is the URL listeners reach Icecast under, used for listen URLs.
+	IcecastPublicURL string `yaml:"icecast_public_url"`
+	// PublicBaseURL is the URL listeners reach this API under, used in feeds and playlists.
+	PublicBaseURL string `yaml:"public_base_url"`
+	// PodcastAuthor and PodcastImageURL are used in all podcast feeds.
+	PodcastAuthor   string `yaml:"podcast_author"`
+	PodcastImageURL string `yaml:"podcast_image_url"`
+
+	// AlertChannels are the channels alerts are sent to: "log", "webhook" and "mail".
+	// Defaults to log and webhook.
+	AlertChannels []string `yaml:"alert_channels"`
+	// AlertGraceMinutes is how long a scheduled stream may be without source. Defaults to 5.
+	AlertGraceMinutes int `yaml:"alert_grace_minutes"`
+	// AlertRepeatMinutes repeats unacknowledged alerts. 0 sends every alert once.
+	AlertRepeatMinutes int      `yaml:"alert_repeat_minutes"`
+	AlertMailFrom      string   `yaml:"alert_mail_from"`
+	AlertMailTo        []string `yaml:"alert_mail_to"`
+	// AlertMailFolder writes alert mails to this folder instead of sending them.
+	AlertMailFolder string `yaml:"alert_mail_folder"`
+	SMTPHost        string `yaml:"smtp_host"`
+	SMTPPort        int    `yaml:"smtp_port"`
+	SMTPUsername    string `yaml:"smtp_username"`
+	SMTPPassword    string `yaml:"smtp_password"`
+
+	// TrashRetentionDays is how long deleted streams can be restored. Defaults to 30.
+	TrashRetentionDays int `yaml:"trash_retention_days"`
+
+	// MetricsToken protects /metrics. Prometheus has to send it as bearer token.
+	MetricsToken string `yaml:"metrics_token"`
+
+	// IdempotencyKeyTTLHours is how long responses to requests with an Idempotency-Key are kept. Defaults to 24.
+	IdempotencyKeyTTLHours int `yaml:"idempotency_key_ttl_hours"`
+
+	// WebhookDeliveryRetentionDays is how long delivered and failed webhook deliveries are kept. Defaults to 7.
+	WebhookDeliveryRetentionDays int `yaml:"webhook_delivery_retention_days"`
 }
 
 // IcecastMount represents the configuration for an Icecast mount point
 type IcecastMount struct {
-	MountName         string       `json:"mount_name"`
-	Username          string       `json:"username"`
-	Password          string       `json:"password"`
-	Public            int          `json:"public"`
-	StreamName        string       `json:"stream_name"`
-	StreamDescription string       `json:"stream_description"`
-	TemplateType      TemplateType `json:"template_type"`
+	MountName         string       `json:"mount_name" yaml:"mount_name"`
+	Username          string       `json:"username" yaml:"username"`
+	Password          string       `json:"password" yaml:"password"`
+	Public            int          `json:"public" yaml:"public"`
+	StreamName        string       `json:"stream_name" yaml:"stream_name"`
+	StreamDescription string       `json:"stream_description" yaml:"stream_description"`
+	TemplateType      TemplateType `json:"template_type" yaml:"template_type"`
+
+	// Recording enables the Icecast dump-file of the mount.
+	Recording bool `json:"recording" yaml:"recording"`
+	// RecordingPattern is the file name of recordings, strftime directives are expanded by Icecast.
+	RecordingPattern string `json:"recording_pattern,omitempty" yaml:"recording_pattern,omitempty"`
+	// RecordingRetentionDays overrides the retention of the config, 0 uses the config.
+	RecordingRetentionDays int `json:"recording_retention_days,omitempty" yaml:"recording_retention_days,omitempty"`
+	// Podcast publishes the recordings as public podcast feed.
+	Podcast bool `json:"podcast" yaml:"podcast"`
+
+	// Version is increased on every update and returned as ETag.
+	Version int64 `json:"-" yaml:"-"`
 }
+
+// redacted returns a copy of the mount without the source password.
+func (mount IcecastMount) redacted() IcecastMount {
+	if mount.Password != "" {
+		mount.Password = redactedValue
+	}
+	return mount
+}
+
+const redactedValue = "[redacted]"
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxRequestBodySize limits JSON request bodies.
+	maxRequestBodySize = 64 << 10
+
+	maxMountNameLength         = 64
+	maxMountUsernameLength     = 64
+	maxMountPasswordLength     = 128
+	maxStreamNameLength        = 100
+	maxStreamDescriptionLength = 500
+	maxRecordingPatternLength  = 100
+)
+
+// reservedMountNames are paths below /api/streams/ that are not streams.
+var reservedMountNames = map[string]bool{
+	"export": true,
+	"import": true,
+}
+
+// decodeJSON reads the request body into v. The body must be a single JSON
+// value of at most maxRequestBodySize bytes without fields v does not know.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	return decodeJSONLimit(w, r, v, maxRequestBodySize)
+}
+
+// decodeJSONLimit is decodeJSON for bodies of at most limit bytes.
+func decodeJSONLimit(w http.ResponseWriter, r *http.Request, v any, limit int64) error {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	defer r.Body.Close()
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = fmt.Errorf("unexpected data after the JSON value")
+	}
+	if err == nil {
+		return nil
+	}
+	logRequest(r, fmt.Sprintf("JSON error decoding request body: %s", err), WarnLog)
+	return jsonError(err)
+}
+
+// jsonError maps a decoding error to the API error.
+func jsonError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &StatusError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    ErrorCodeTooLarge,
+			Message: fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit),
+		}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return validationError(fieldError(typeErr.Field, "must be a %s", typeErr.Type))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return validationError(fieldError(field, "is unknown"))
+	}
+	return fmt.Errorf("invalid JSON")
+}
+
+// validName accepts names used in file names and URLs: letters, digits,
+// '-', '_' and '.', not starting with '.'.
+func validName(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// validateName checks a required name field, see validName.
+func validateName(field, name string, maxLength int) []FieldError {
+	switch {
+	case name == "":
+		return []FieldError{fieldError(field, "is required")}
+	case len(name) > maxLength:
+		return []FieldError{fieldError(field, "must not be longer than %d characters", maxLength)}
+	case !validName(name):
+		return []FieldError{fieldError(field, "must consist of letters, digits, '-', '_' and '.' and not start with '.'")}
+	}
+	return nil
+}
+
+// validText accepts single line text, the mount templates escape it for XML.
+func validText(text string) bool {
+	return utf8.ValidString(text) && !strings.ContainsAny(text, "\x00\r\n")
+}
+
+// Validate checks all fields of the mount and reports every violation at
+// once. An empty template type is set to the default template.
+func (mount *IcecastMount) Validate() error {
+	var fields []FieldError
+	check := func(valid bool, field, format string, args ...any) {
+		if !valid {
+			fields = append(fields, fieldError(field, format, args...))
+		}
+	}
+
+	fields = append(fields, validateName("mount_name", mount.MountName, maxMountNameLength)...)
+	check(!reservedMountNames[mount.MountName], "mount_name", "is reserved")
+	fields = append(fields, validateName("username", mount.Username, maxMountUsernameLength)...)
+
+	switch {
+	case mount.Password == "":
+		check(false, "password", "is required")
+	case len(mount.Password) > maxMountPasswordLength:
+		check(false, "password", "must not be longer than %d characters", maxMountPasswordLength)
+	default:
+		check(validText(mount.Password), "password", "must not contain line breaks")
+	}
+
+	check(mount.Public == 0 || mount.Public == 1, "public", "must be 0 or 1")
+
+	check(utf8.RuneCountInString(mount.StreamName) <= maxStreamNameLength, "stream_name", "must not be longer than %d characters", maxStreamNameLength)
+	check(validText(mount.StreamName), "stream_name", "must not contain line breaks")
+
+	check(utf8.RuneCountInString(mount.StreamDescription) <= maxStreamDescriptionLength, "stream_description", "must not be longer than %d characters", maxStreamDescriptionLength)
+	check(validText(mount.StreamDescription), "stream_description", "must not contain line breaks")
+
+	if mount.TemplateType == "" {
+		mount.TemplateType = DefaultTemplate
+	}
+	check(mount.TemplateType == DefaultTemplate || mount.TemplateType == PrivateTemplate, "template_type", "must be %s or %s", DefaultTemplate, PrivateTemplate)
+
+	check(len(mount.RecordingPattern) <= maxRecordingPatternLength, "recording_pattern", "must not be longer than %d characters", maxRecordingPatternLength)
+	check(validRecordingPattern(mount.RecordingPattern), "recording_pattern", "must not contain path separators, .. or XML characters")
+	check(mount.RecordingRetentionDays >= 0, "recording_retention_days", "must not be negative")
+
+	if len(fields) > 0 {
+		return validationError(fields...)
+	}
+	return nil
+}
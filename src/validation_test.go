@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMountValidate(t *testing.T) {
+	initTest(t)
+
+	mount := IcecastMount{MountName: "christmas.mp3", Username: "source", Password: "secret", Public: 1, StreamName: "Christmas"}
+	if err := mount.Validate(); err != nil {
+		t.Fatalf("Expected a valid mount, got %v", err)
+	}
+	if mount.TemplateType != DefaultTemplate {
+		t.Fatalf("Expected the default template, got %s", mount.TemplateType)
+	}
+
+	invalid := IcecastMount{
+		MountName:         "../christmas",
+		Public:            2,
+		StreamDescription: strings.Repeat("x", maxStreamDescriptionLength+1),
+		TemplateType:      "secret",
+	}
+	var statusErr *StatusError
+	if !errors.As(invalid.Validate(), &statusErr) {
+		t.Fatalf("Expected a validation error")
+	}
+	fields := map[string]bool{}
+	for _, field := range statusErr.Fields {
+		fields[field.Field] = true
+	}
+	for _, field := range []string{"mount_name", "username", "password", "public", "stream_description", "template_type"} {
+		if !fields[field] {
+			t.Fatalf("Expected a violation of %s, got %+v", field, statusErr.Fields)
+		}
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	initTest(t)
+
+	tests := []struct {
+		body   string
+		status int
+		field  string
+	}{
+		{`{"mount_name":"christmas","colour":"red"}`, http.StatusUnprocessableEntity, "colour"},
+		{`{"mount_name":"christmas","public":"yes"}`, http.StatusUnprocessableEntity, "public"},
+		{`{"mount_name":"christmas"} {}`, http.StatusBadRequest, ""},
+		{`{"stream_description":"` + strings.Repeat("x", maxRequestBodySize) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/api/streams", strings.NewReader(test.body))
+		var mount IcecastMount
+		err := decodeJSON(httptest.NewRecorder(), r, &mount)
+		if err == nil {
+			t.Fatalf("Expected an error for %.40s", test.body)
+		}
+		status, apiErr := apiErrorResponse(err)
+		if status != test.status {
+			t.Fatalf("Expected status %d for %.40s, got %d %+v", test.status, test.body, status, apiErr)
+		}
+		if test.field != "" && (len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != test.field) {
+			t.Fatalf("Expected a violation of %s, got %+v", test.field, apiErr.Fields)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const webhookColumns = `id, url, secret, events, mount_name, active, created_at`
+
+// scanWebhook reads a webhook row. The secret is stored encrypted because it is
+// needed in plain text to sign the deliveries.
+func scanWebhook(scanner interface{ Scan(...any) error }) (Webhook, error) {
+	var webhook Webhook
+	var encryptedSecret, events string
+	err := scanner.Scan(&webhook.ID, &webhook.URL, &encryptedSecret, &events, &webhook.MountName, &webhook.Active, &webhook.CreatedAt)
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook.Secret, err = decryptString(encryptedSecret)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("error decrypting secret of webhook %d: %w", webhook.ID, err)
+	}
+	webhook.Events = []string{}
+	for _, eventType := range strings.Split(events, ",") {
+		if eventType != "" {
+			webhook.Events = append(webhook.Events, eventType)
+		}
+	}
+	return webhook, nil
+}
+
+func (s *SqliteStorage) CreateWebhook(webhook Webhook) (Webhook, error) {
+	defer observeQuery("CreateWebhook", time.Now())
+	logWithCaller(fmt.Sprintf("Creating webhook for URL: %s", webhook.URL), InfoLog)
+	encryptedSecret, err := encryptString(webhook.Secret)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error encrypting webhook secret: %v", err), FatalLog)
+		return Webhook{}, err
+	}
+
+	stmt, err := s.db.Prepare(`
+	INSERT INTO webhooks (url, secret, events, mount_name, active)
+	VALUES ($1, $2, $3, $4, $5)
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return Webhook{}, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(webhook.URL, encryptedSecret, strings.Join(webhook.Events, ","), webhook.MountName, webhook.Active)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return Webhook{}, err
+	}
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting last insert ID: %v", err), FatalLog)
+		return Webhook{}, err
+	}
+	logWithCaller(fmt.Sprintf("Inserted webhook with ID: %d", lastID), InfoLog)
+
+	return s.GetWebhook(lastID)
+}
+
+func (s *SqliteStorage) GetWebhook(id int64) (Webhook, error) {
+	defer observeQuery("GetWebhook", time.Now())
+	logWithCaller(fmt.Sprintf("Getting webhook %d", id), DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + webhookColumns + `
+	FROM webhooks
+	WHERE id = $1
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return Webhook{}, err
+	}
+	defer stmt.Close()
+
+	webhook, err := scanWebhook(stmt.QueryRow(id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			logWithCaller(fmt.Sprintf("No webhook %d", id), DebugLog)
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (s *SqliteStorage) GetWebhooks() ([]Webhook, error) {
+	defer observeQuery("GetWebhooks", time.Now())
+	logWithCaller("Getting all webhooks", DebugLog)
+	stmt, err := s.db.Prepare(`
+	SELECT ` + webhookColumns + `
+	FROM webhooks
+	ORDER BY id
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (s *SqliteStorage) UpdateWebhook(webhook Webhook) error {
+	defer observeQuery("UpdateWebhook", time.Now())
+	logWithCaller(fmt.Sprintf("Updating webhook %d", webhook.ID), InfoLog)
+	encryptedSecret, err := encryptString(webhook.Secret)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error encrypting webhook secret: %v", err), FatalLog)
+		return err
+	}
+
+	stmt, err := s.db.Prepare(`
+	UPDATE webhooks
+	SET url = $1,
+	secret = $2,
+	events = $3,
+	mount_name = $4,
+	active = $5
+	WHERE id = $6
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(webhook.URL, encryptedSecret, strings.Join(webhook.Events, ","), webhook.MountName, webhook.Active, webhook.ID)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for webhook: %d", affectedRows, webhook.ID), FatalLog)
+		return affectedRowsError(affectedRows, "webhook", webhook.ID)
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook together with its delivery log.
+func (s *SqliteStorage) DeleteWebhook(id int64) error {
+	defer observeQuery("DeleteWebhook", time.Now())
+	logWithCaller(fmt.Sprintf("Deleting webhook %d", id), InfoLog)
+	tx, err := s.db.Begin()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error starting transaction: %v", err), FatalLog)
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return err
+	}
+	if affectedRows != 1 {
+		logWithCaller(fmt.Sprintf("Affected rows %d for webhook: %d", affectedRows, id), FatalLog)
+		return affectedRowsError(affectedRows, "webhook", id)
+	}
+
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = $1`, id)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return tx.Commit()
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_type, payload, status, attempts, next_attempt_at, response_status, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(scanner interface{ Scan(...any) error }) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := scanner.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventType, &delivery.Payload, &delivery.Status,
+		&delivery.Attempts, &delivery.NextAttemptAt, &delivery.ResponseStatus, &delivery.LastError, &delivery.CreatedAt, &deliveredAt)
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+	return delivery, nil
+}
+
+func (s *SqliteStorage) CreateWebhookDelivery(delivery WebhookDelivery) error {
+	defer observeQuery("CreateWebhookDelivery", time.Now())
+	logWithCaller(fmt.Sprintf("Queueing %s delivery for webhook %d", delivery.EventType, delivery.WebhookID), DebugLog)
+	stmt, err := s.db.Prepare(`
+	INSERT INTO webhook_deliveries (webhook_id, event_type, payload, status, next_attempt_at)
+	VALUES ($1, $2, $3, $4, $5)
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(delivery.WebhookID, delivery.EventType, delivery.Payload, delivery.Status, delivery.NextAttemptAt.UTC())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return nil
+}
+
+func (s *SqliteStorage) queryWebhookDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error scanning row: %v", err), FatalLog)
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		logWithCaller(fmt.Sprintf("Error iterating rows: %v", err), FatalLog)
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetDueWebhookDeliveries returns pending deliveries whose next attempt is due, oldest first.
+func (s *SqliteStorage) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	defer observeQuery("GetDueWebhookDeliveries", time.Now())
+	return s.queryWebhookDeliveries(`
+	SELECT `+webhookDeliveryColumns+`
+	FROM webhook_deliveries
+	WHERE status = $1 AND next_attempt_at <= $2
+	ORDER BY id
+	LIMIT $3
+	`, DeliveryPending, now.UTC(), limit)
+}
+
+// GetWebhookDeliveries returns the latest deliveries of a webhook, newest first.
+func (s *SqliteStorage) GetWebhookDeliveries(webhookID int64, limit int) ([]WebhookDelivery, error) {
+	defer observeQuery("GetWebhookDeliveries", time.Now())
+	logWithCaller(fmt.Sprintf("Getting deliveries of webhook %d", webhookID), DebugLog)
+	return s.queryWebhookDeliveries(`
+	SELECT `+webhookDeliveryColumns+`
+	FROM webhook_deliveries
+	WHERE webhook_id = $1
+	ORDER BY id DESC
+	LIMIT $2
+	`, webhookID, limit)
+}
+
+func (s *SqliteStorage) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	defer observeQuery("UpdateWebhookDelivery", time.Now())
+	stmt, err := s.db.Prepare(`
+	UPDATE webhook_deliveries
+	SET status = $1,
+	attempts = $2,
+	next_attempt_at = $3,
+	response_status = $4,
+	last_error = $5,
+	delivered_at = $6
+	WHERE id = $7
+	`)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error preparing statement: %v", err), FatalLog)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(delivery.Status, delivery.Attempts, delivery.NextAttemptAt.UTC(), delivery.ResponseStatus,
+		delivery.LastError, nullableTime(delivery.DeliveredAt), delivery.ID)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return err
+	}
+	return nil
+}
+
+// DeleteWebhookDeliveries deletes the delivered and failed deliveries queued
+// before the given time. Pending deliveries are kept.
+func (s *SqliteStorage) DeleteWebhookDeliveries(before time.Time) (int64, error) {
+	defer observeQuery("DeleteWebhookDeliveries", time.Now())
+	result, err := s.db.Exec(`DELETE FROM webhook_deliveries WHERE status != $1 AND created_at < $2`, DeliveryPending, before.UTC())
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error executing statement: %v", err), FatalLog)
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Error getting affected rows: %v", err), FatalLog)
+		return 0, err
+	}
+	logWithCaller(fmt.Sprintf("Deleted %d old webhook deliveries", deleted), DebugLog)
+	return deleted, nil
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+
+	webhookDeliveryInterval = 10 * time.Second
+	webhookTimeout          = 10 * time.Second
+	webhookBatchSize        = 50
+	webhookMaxAttempts      = 8
+	webhookRetryBase        = 30 * time.Second
+	webhookRetryMax         = time.Hour
+	webhookSecretLength     = 32
+	webhookDeliveryLimit    = 50
+	webhookMaxDeliveryLimit = 500
+	webhookResponseLimit    = 1024
+
+	webhookPurgeInterval            = time.Hour
+	defaultWebhookDeliveryRetention = 7
+)
+
+// Webhook is an URL that receives the events of all streams or of one stream.
+// An empty event list subscribes to all event types.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	MountName string    `json:"mount_name,omitempty"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (webhook Webhook) matches(event Event) bool {
+	if !webhook.Active {
+		return false
+	}
+	if webhook.MountName != "" && webhook.MountName != event.MountName {
+		return false
+	}
+	return len(webhook.Events) == 0 || slices.Contains(webhook.Events, event.Type)
+}
+
+func (webhook Webhook) clearSecrets() Webhook {
+	webhook.Secret = ""
+	return webhook
+}
+
+// WebhookDelivery is one event queued for a webhook, together with the result
+// of the last attempt.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	WebhookID      int64      `json:"webhook_id"`
+	EventType      string     `json:"event_type"`
+	Payload        string     `json:"payload"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, webhookSecretLength)
+	_, err := rand.Read(secret)
+	if err != nil {
+		logWithCaller("Failed to generate webhook secret: "+err.Error(), FatalLog)
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// signWebhookPayload returns the value of the X-Webhook-Signature header.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay doubles the delay after every failed attempt.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMax)
+}
+
+// WebhookDispatcher queues events for the matching webhooks and delivers the
+// queue. Events are queued while they are published, so none are lost to a
+// busy dispatcher. The queue lives in the database, so pending deliveries
+// survive a restart.
+type WebhookDispatcher struct {
+	storage Store
+	events  *EventBroker
+	client  *http.Client
+	wake    chan struct{}
+}
+
+func NewWebhookDispatcher(storage Store, events *EventBroker) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		storage: storage,
+		events:  events,
+		client:  &http.Client{Timeout: webhookTimeout},
+		wake:    make(chan struct{}, 1),
+	}
+	events.Handle(d.enqueue)
+	return d
+}
+
+// Run delivers due deliveries every interval and whenever events were queued.
+// It never returns.
+func (d *WebhookDispatcher) Run(interval time.Duration) {
+	logWithCaller(fmt.Sprintf("Starting webhook dispatcher with interval %s", interval), InfoLog)
+	d.deliverLoop(interval)
+}
+
+func (s *ApiServer) webhookDeliveryRetention() time.Duration {
+	days := s.config.WebhookDeliveryRetentionDays
+	if days <= 0 {
+		days = defaultWebhookDeliveryRetention
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RunWebhookDeliveryPurge deletes delivered and failed deliveries older than
+// the retention every interval. It never returns.
+func (s *ApiServer) RunWebhookDeliveryPurge(interval time.Duration) {
+	logWithCaller(fmt.Sprintf("Starting webhook delivery purge with interval %s and retention %s", interval, s.webhookDeliveryRetention()), InfoLog)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := s.storage.DeleteWebhookDeliveries(time.Now().Add(-s.webhookDeliveryRetention()))
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Error purging webhook deliveries: %s", err), WarnLog)
+		}
+		<-ticker.C
+	}
+}
+
+func (d *WebhookDispatcher) enqueue(event Event) {
+	webhooks, err := d.storage.GetWebhooks()
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Webhook dispatcher could not load webhooks: %s", err), WarnLog)
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Webhook dispatcher could not encode event %d: %s", event.ID, err), WarnLog)
+		return
+	}
+
+	queued := false
+	for _, webhook := range webhooks {
+		if !webhook.matches(event) {
+			continue
+		}
+		err = d.storage.CreateWebhookDelivery(WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventType:     event.Type,
+			Payload:       string(payload),
+			Status:        DeliveryPending,
+			NextAttemptAt: event.Time,
+		})
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Webhook dispatcher could not queue event %d for webhook %d: %s", event.ID, webhook.ID, err), WarnLog)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliverLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(time.Now())
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliverDue(now time.Time) {
+	deliveries, err := d.storage.GetDueWebhookDeliveries(now, webhookBatchSize)
+	if err != nil {
+		logWithCaller(fmt.Sprintf("Webhook dispatcher could not load deliveries: %s", err), WarnLog)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		webhook, err := d.storage.GetWebhook(delivery.WebhookID)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Webhook dispatcher could not load webhook %d: %s", delivery.WebhookID, err), WarnLog)
+			continue
+		}
+
+		delivery = d.attempt(webhook, delivery, time.Now())
+		err = d.storage.UpdateWebhookDelivery(delivery)
+		if err != nil {
+			logWithCaller(fmt.Sprintf("Webhook dispatcher could not save delivery %d: %s", delivery.ID, err), WarnLog)
+		}
+	}
+}
+
+// attempt posts the delivery and returns it with the result. Failed deliveries
+// are retried with backoff until webhookMaxAttempts is reached.
+func (d *WebhookDispatcher) attempt(webhook Webhook, delivery WebhookDelivery, now time.Time) WebhookDelivery {
+	delivery.Attempts++
+	delivery.ResponseStatus = 0
+	delivery.LastError = ""
+
+	err := d.post(webhook, delivery, &delivery.ResponseStatus)
+	if err == nil {
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = &now
+		logWithCaller(fmt.Sprintf("Delivered %s to webhook %d", delivery.EventType, webhook.ID), DebugLog)
+		return delivery
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = DeliveryFailed
+		logWithCaller(fmt.Sprintf("Giving up delivery %d to webhook %d after %d attempts: %s", delivery.ID, webhook.ID, delivery.Attempts, err), WarnLog)
+		return delivery
+	}
+	delivery.NextAttemptAt = now.Add(webhookRetryDelay(delivery.Attempts))
+	logWithCaller(fmt.Sprintf("Delivery %d to webhook %d failed, retrying at %s: %s", delivery.ID, webhook.ID, delivery.NextAttemptAt.Format(time.RFC3339), err), InfoLog)
+	return delivery
+}
+
+func (d *WebhookDispatcher) post(webhook Webhook, delivery WebhookDelivery, responseStatus *int) error {
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", getApplicationName()+"/"+getVersion())
+	request.Header.Set("X-Webhook-Event", delivery.EventType)
+	request.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	request.Header.Set("X-Webhook-Signature", signWebhookPayload(webhook.Secret, payload))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, webhookResponseLimit))
+
+	*responseStatus = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// ###########
+// Webhook routes
+// ###########
+
+func (s *ApiServer) addWebhookRoutes(autherizedRouter *http.ServeMux, autherized string) {
+	autherizedRouter.HandleFunc("GET "+autherized+"webhooks", makeHTTPHandleFunc(s.handleGetWebhooks))
+	addToRouteRightsMap("GET "+autherized+"webhooks", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"webhooks", makeHTTPHandleFunc(s.handleCreateWebhook))
+	addToRouteRightsMap("POST "+autherized+"webhooks", "post_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"webhooks/{webhookID}", makeHTTPHandleFunc(s.handleGetWebhook))
+	addToRouteRightsMap("GET "+autherized+"webhooks/{webhookID}", "get_stream")
+
+	autherizedRouter.HandleFunc("POST "+autherized+"webhooks/{webhookID}", makeHTTPHandleFunc(s.handleUpdateWebhook))
+	addToRouteRightsMap("POST "+autherized+"webhooks/{webhookID}", "post_stream")
+
+	autherizedRouter.HandleFunc("DELETE "+autherized+"webhooks/{webhookID}", makeHTTPHandleFunc(s.handleDeleteWebhook))
+	addToRouteRightsMap("DELETE "+autherized+"webhooks/{webhookID}", "delete_stream")
+
+	autherizedRouter.HandleFunc("GET "+autherized+"webhooks/{webhookID}/deliveries", makeHTTPHandleFunc(s.handleGetWebhookDeliveries))
+	addToRouteRightsMap("GET "+autherized+"webhooks/{webhookID}/deliveries", "get_stream")
+}
+
+// webhookRequest is the body of create and update. Active defaults to true.
+type webhookRequest struct {
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret"`
+	Events    []string `json:"events"`
+	MountName string   `json:"mount_name"`
+	Active    *bool    `json:"active"`
+}
+
+func validateWebhookURL(value string) error {
+	webhookURL, err := url.Parse(value)
+	if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Host == "" {
+		return validationError(fieldError("url", "must be an absolute http or https URL"))
+	}
+	return nil
+}
+
+func validateWebhookEvents(events []string) error {
+	for _, eventType := range events {
+		if !slices.Contains(eventTypes, eventType) {
+			return validationError(fieldError("events", "contains the unknown event type %s", eventType))
+		}
+	}
+	return nil
+}
+
+// applyWebhookRequest validates the request and copies it to the webhook. Users
+// without get_all_streams can only subscribe to the stream they own.
+func (s *ApiServer) applyWebhookRequest(r *http.Request, webhook *Webhook, request webhookRequest) error {
+	err := validateWebhookURL(request.URL)
+	if err != nil {
+		return err
+	}
+	err = validateWebhookEvents(request.Events)
+	if err != nil {
+		return err
+	}
+
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return err
+	}
+	mountName := strings.TrimSpace(request.MountName)
+	switch {
+	case !all && ownStream == "":
+		return fmt.Errorf("user owns no stream")
+	case !all && mountName == "":
+		mountName = ownStream
+	case !all && mountName != ownStream:
+		return fmt.Errorf("webhooks can only subscribe to your own stream")
+	case all && mountName != "":
+		_, err = s.storage.GetIcecastMount(mountName)
+		if err != nil {
+			logRequest(r, fmt.Sprintf("Database error fetching icecast mount: %s %s", mountName, err), WarnLog)
+			return notFoundError("stream not found")
+		}
+	}
+
+	webhook.URL = request.URL
+	webhook.Events = request.Events
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+	webhook.MountName = mountName
+	if request.Active != nil {
+		webhook.Active = *request.Active
+	}
+	if request.Secret != "" {
+		webhook.Secret = request.Secret
+	}
+	return nil
+}
+
+// getRequestWebhook loads the webhook of the request if the caller may see it.
+func (s *ApiServer) getRequestWebhook(r *http.Request) (Webhook, error) {
+	id, err := strconv.ParseInt(r.PathValue("webhookID"), 10, 64)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("invalid webhook id")
+	}
+
+	webhook, err := s.storage.GetWebhook(id)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching webhook: %d %s", id, err), WarnLog)
+		return Webhook{}, databaseError(err, "webhook")
+	}
+
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if !all && (ownStream == "" || webhook.MountName != ownStream) {
+		return Webhook{}, notFoundError("webhook not found")
+	}
+	return webhook, nil
+}
+
+func (s *ApiServer) handleGetWebhooks(w http.ResponseWriter, r *http.Request) error {
+	ownStream, all, err := s.getVisibleStream(r)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := s.storage.GetWebhooks()
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching webhooks: %s", err), WarnLog)
+		return databaseError(err, "webhook")
+	}
+
+	visible := []Webhook{}
+	for _, webhook := range webhooks {
+		if all || (ownStream != "" && webhook.MountName == ownStream) {
+			visible = append(visible, webhook.clearSecrets())
+		}
+	}
+
+	return WriteJson(w, http.StatusOK, visible)
+}
+
+func (s *ApiServer) handleGetWebhook(w http.ResponseWriter, r *http.Request) error {
+	webhook, err := s.getRequestWebhook(r)
+	if err != nil {
+		return err
+	}
+	return WriteJson(w, http.StatusOK, webhook.clearSecrets())
+}
+
+// handleCreateWebhook creates a webhook. Without secret a random secret is
+// generated. The secret is only returned in this response.
+func (s *ApiServer) handleCreateWebhook(w http.ResponseWriter, r *http.Request) error {
+	var request webhookRequest
+	err := decodeJSON(w, r, &request)
+	if err != nil {
+		return err
+	}
+
+	webhook := Webhook{Active: true}
+	err = s.applyWebhookRequest(r, &webhook, request)
+	if err != nil {
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret, err = generateWebhookSecret()
+		if err != nil {
+			return internalError("error generating secret")
+		}
+	}
+
+	created, err := s.storage.CreateWebhook(webhook)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error creating webhook: %s", err), WarnLog)
+		return databaseError(err, "webhook")
+	}
+
+	return WriteJson(w, http.StatusCreated, created)
+}
+
+// handleUpdateWebhook replaces url, events, stream and active. An empty secret
+// keeps the stored secret.
+func (s *ApiServer) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) error {
+	webhook, err := s.getRequestWebhook(r)
+	if err != nil {
+		return err
+	}
+
+	var request webhookRequest
+	err = decodeJSON(w, r, &request)
+	if err != nil {
+		return err
+	}
+
+	err = s.applyWebhookRequest(r, &webhook, request)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.UpdateWebhook(webhook)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error updating webhook: %d %s", webhook.ID, err), WarnLog)
+		return databaseError(err, "webhook")
+	}
+
+	return WriteJson(w, http.StatusOK, webhook.clearSecrets())
+}
+
+func (s *ApiServer) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) error {
+	webhook, err := s.getRequestWebhook(r)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.DeleteWebhook(webhook.ID)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error deleting webhook: %d %s", webhook.ID, err), WarnLog)
+		return databaseError(err, "webhook")
+	}
+
+	return WriteJson(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// handleGetWebhookDeliveries returns the delivery log of a webhook, newest first.
+// The number of entries is set with limit, default 50.
+func (s *ApiServer) handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) error {
+	webhook, err := s.getRequestWebhook(r)
+	if err != nil {
+		return err
+	}
+
+	limit := webhookDeliveryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > webhookMaxDeliveryLimit {
+			return fmt.Errorf("limit must be between 1 and %d", webhookMaxDeliveryLimit)
+		}
+	}
+
+	deliveries, err := s.storage.GetWebhookDeliveries(webhook.ID, limit)
+	if err != nil {
+		logRequest(r, fmt.Sprintf("Database error fetching webhook deliveries: %d %s", webhook.ID, err), WarnLog)
+		return databaseError(err, "delivery")
+	}
+
+	return WriteJson(w, http.StatusOK, deliveries)
+}
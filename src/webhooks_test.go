@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	initTest(t)
+
+	// echo -n '{"id":1}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=03def589620c813f198fd03d7967e292b163ef0435ebf43071ce0e9519763cb7"
+	signature := signWebhookPayload("secret", []byte(`{"id":1}`))
+	if signature != expected {
+		t.Fatalf("Expected signature %s, got %s", expected, signature)
+	}
+	if signature == signWebhookPayload("other", []byte(`{"id":1}`)) {
+		t.Fatalf("Signature does not depend on the secret")
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	initTest(t)
+
+	expected := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	}
+	for attempts, delay := range expected {
+		if got := webhookRetryDelay(attempts); got != delay {
+			t.Fatalf("Expected delay %s after %d attempts, got %s", delay, attempts, got)
+		}
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	initTest(t)
+
+	event := Event{Type: EventSourceConnected, MountName: "live"}
+	cases := []struct {
+		webhook Webhook
+		matches bool
+	}{
+		{Webhook{Active: true}, true},
+		{Webhook{Active: false}, false},
+		{Webhook{Active: true, MountName: "live"}, true},
+		{Webhook{Active: true, MountName: "other"}, false},
+		{Webhook{Active: true, Events: []string{EventSourceConnected, EventSourceDisconnected}}, true},
+		{Webhook{Active: true, Events: []string{EventStreamCreated}}, false},
+	}
+	for i, c := range cases {
+		if c.webhook.matches(event) != c.matches {
+			t.Fatalf("Case %d: expected match %t", i, c.matches)
+		}
+	}
+}
+
+func TestWebhookAttemptRetriesAndGivesUp(t *testing.T) {
+	initTest(t)
+
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Webhook-Signature") != signWebhookPayload("secret", []byte(`{}`)) {
+			t.Errorf("Invalid signature header: %s", r.Header.Get("X-Webhook-Signature"))
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	dispatcher := NewWebhookDispatcher(nil, NewEventBroker())
+	webhook := Webhook{ID: 1, URL: server.URL, Secret: "secret", Active: true}
+	now := time.Date(2025, 4, 20, 10, 0, 0, 0, time.UTC)
+
+	delivery := dispatcher.attempt(webhook, WebhookDelivery{ID: 1, Payload: `{}`, Status: DeliveryPending}, now)
+	if delivery.Status != DeliveryPending || delivery.Attempts != 1 || delivery.ResponseStatus != status {
+		t.Fatalf("Unexpected delivery after failure: %+v", delivery)
+	}
+	if !delivery.NextAttemptAt.Equal(now.Add(webhookRetryBase)) {
+		t.Fatalf("Unexpected next attempt: %s", delivery.NextAttemptAt)
+	}
+
+	delivery.Attempts = webhookMaxAttempts - 1
+	delivery = dispatcher.attempt(webhook, delivery, now)
+	if delivery.Status != DeliveryFailed {
+		t.Fatalf("Expected delivery to fail after %d attempts, got %s", webhookMaxAttempts, delivery.Status)
+	}
+
+	status = http.StatusNoContent
+	delivery = dispatcher.attempt(webhook, WebhookDelivery{ID: 2, Payload: `{}`, Status: DeliveryPending}, now)
+	if delivery.Status != DeliveryDelivered || delivery.DeliveredAt == nil || delivery.LastError != "" {
+		t.Fatalf("Unexpected delivery after success: %+v", delivery)
+	}
+}
+
+func TestWebhookDispatcherQueuesEveryEvent(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	webhook, err := storage.CreateWebhook(Webhook{URL: "http://localhost/hook", Active: true})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	events := NewEventBroker()
+	NewWebhookDispatcher(storage, events)
+
+	// Far more events than a subscriber buffers, nobody delivers meanwhile
+	count := 4 * eventBufferSize
+	for i := range count {
+		events.Publish(EventListenersChanged, "ostern", map[string]int{"listeners": i})
+	}
+	deliveries, err := storage.GetWebhookDeliveries(webhook.ID, 2*count)
+	if err != nil || len(deliveries) != count {
+		t.Fatalf("Expected %d queued deliveries, got %d %v", count, len(deliveries), err)
+	}
+}
+
+func TestDeleteWebhookDeliveries(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	webhook, err := storage.CreateWebhook(Webhook{URL: "http://localhost/hook", Secret: "secret", Active: true})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	for _, status := range []string{DeliveryPending, DeliveryDelivered, DeliveryFailed} {
+		delivery := WebhookDelivery{WebhookID: webhook.ID, EventType: EventStreamCreated, Payload: "{}", Status: status, NextAttemptAt: time.Now()}
+		if err := storage.CreateWebhookDelivery(delivery); err != nil {
+			t.Fatalf("Failed to create delivery: %v", err)
+		}
+	}
+
+	if deleted, err := storage.DeleteWebhookDeliveries(time.Now().Add(-time.Hour)); err != nil || deleted != 0 {
+		t.Fatalf("Expected recent deliveries to be kept, got %d %v", deleted, err)
+	}
+	if deleted, err := storage.DeleteWebhookDeliveries(time.Now().Add(time.Hour)); err != nil || deleted != 2 {
+		t.Fatalf("Expected the delivered and failed deliveries to be deleted, got %d %v", deleted, err)
+	}
+	deliveries, err := storage.GetWebhookDeliveries(webhook.ID, webhookDeliveryLimit)
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status != DeliveryPending {
+		t.Fatalf("Expected the pending delivery to be kept, got %+v %v", deliveries, err)
+	}
+}
+
+func TestWebhookHandlersRejectUnknownFields(t *testing.T) {
+	initTest(t)
+
+	storage, err := NewSqliteStore(&Config{DbFile: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	webhook, err := storage.CreateWebhook(Webhook{URL: "http://localhost/hook", Secret: "secret", Active: true})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	s := &ApiServer{storage: storage}
+	token, err := createToken("admin", rightsAdmin, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+
+	body := `{"url":"http://localhost/hook","retries":3}`
+	for _, handler := range []apiFunc{s.handleCreateWebhook, s.handleUpdateWebhook} {
+		r := httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey, authInfo{Username: "admin", Token: token}))
+		r.SetPathValue("webhookID", fmt.Sprint(webhook.ID))
+		status, apiErr := apiErrorResponse(handler(httptest.NewRecorder(), r))
+		if status != http.StatusUnprocessableEntity || len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "retries" {
+			t.Errorf("Expected the unknown field to be rejected, got %d %+v", status, apiErr)
+		}
+	}
+}